@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The agentx subcommand is an AgentX (RFC 2741) subagent of the host's
+// snmpd. It collects on its own interval and answers the Get requests
+// snmpd forwards for the subtree of MEGARAID-COLLECTOR-MIB.txt.
+
+func init() {
+	flags := flag.NewFlagSet("agentx", flag.ExitOnError)
+	address := flags.String("address", "/var/agentx/master", "AgentX socket of snmpd, a Unix socket path or host:port.")
+	oid := flags.String("oid", agentxDefaultOID, "OID the MIB is registered at.")
+	interval := flags.Duration("collect-interval", time.Minute, "Collect at this interval.")
+
+	RegisterSubcommand("agentx", &Subcommand{
+		Flags: flags,
+		Run: func(source Source) error {
+			base, err := parseOID(*oid)
+			if err != nil {
+				return fmt.Errorf("-oid: %w", err)
+			}
+			return runAgentX(source, *address, base, *interval)
+		},
+	})
+}
+
+// netSnmpPlaypen.1, the experimental subtree of the Net-SNMP enterprise.
+const agentxDefaultOID = "1.3.6.1.4.1.8072.9999.9999.1"
+
+// AgentX PDU types.
+const (
+	agentxOpen       = 1
+	agentxClose      = 2
+	agentxRegister   = 3
+	agentxGet        = 5
+	agentxGetNext    = 6
+	agentxGetBulk    = 7
+	agentxTestSet    = 8
+	agentxCommitSet  = 9
+	agentxUndoSet    = 10
+	agentxCleanupSet = 11
+	agentxPing       = 13
+	agentxResponse   = 18
+)
+
+// Header flags.
+const (
+	agentxNonDefaultContext = 0x08
+	agentxNetworkByteOrder  = 0x10
+)
+
+// SNMP value types, as AgentX encodes them.
+const (
+	snmpInteger      = 2
+	snmpOctetString  = 4
+	snmpCounter32    = 65
+	snmpNoSuchObject = 128
+	snmpEndOfMibView = 130
+)
+
+// Error of a set request, nothing here is writable.
+const agentxNotWritable = 17
+
+type oid []uint32
+
+func parseOID(s string) (oid, error) {
+	var parsed oid
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a numeric OID", s)
+		}
+		parsed = append(parsed, uint32(n))
+	}
+	return parsed, nil
+}
+
+func (o oid) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+func (o oid) compare(other oid) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		if o[i] != other[i] {
+			if o[i] < other[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(o) - len(other)
+}
+
+func (o oid) append(subids ...uint32) oid {
+	return append(append(oid{}, o...), subids...)
+}
+
+// snmpValue is an object of the MIB with its value.
+type snmpValue struct {
+	OID    oid
+	Type   uint16
+	Int    int64
+	String string
+}
+
+// agentxMIB holds the objects of the last collection, sorted by OID.
+type agentxMIB struct {
+	mu      sync.RWMutex
+	objects []snmpValue
+}
+
+func (m *agentxMIB) set(objects []snmpValue) {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].OID.compare(objects[j].OID) < 0
+	})
+	m.mu.Lock()
+	m.objects = objects
+	m.mu.Unlock()
+}
+
+// get returns the object at name, or noSuchObject.
+func (m *agentxMIB) get(name oid) snmpValue {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := sort.Search(len(m.objects), func(i int) bool {
+		return m.objects[i].OID.compare(name) >= 0
+	})
+	if i < len(m.objects) && m.objects[i].OID.compare(name) == 0 {
+		return m.objects[i]
+	}
+	return snmpValue{OID: name, Type: snmpNoSuchObject}
+}
+
+// next returns the first object after start, or at it with include,
+// that is before end. An empty end has no bound.
+func (m *agentxMIB) next(start oid, include bool, end oid) snmpValue {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := sort.Search(len(m.objects), func(i int) bool {
+		c := m.objects[i].OID.compare(start)
+		return c > 0 || include && c == 0
+	})
+	if i < len(m.objects) && (len(end) == 0 || m.objects[i].OID.compare(end) < 0) {
+		return m.objects[i]
+	}
+	return snmpValue{OID: start, Type: snmpEndOfMibView}
+}
+
+// Health of an object in the MIB: ok(1), warning(2), critical(3) and
+// unknown(4) while the last collection failed.
+func snmpHealth(severity Severity) int64 {
+	return int64(severity) + 1
+}
+
+const snmpHealthUnknown = 4
+
+// agentxObjects lays out the model as MEGARAID-COLLECTOR-MIB.txt
+// describes it.
+func agentxObjects(base oid, system *System) []snmpValue {
+
+	findings := evaluateHealth(system)
+	health := func(object string) int64 {
+		worst := SeverityOK
+		for _, finding := range findings {
+			if finding.Object == object && finding.Severity > worst {
+				worst = finding.Severity
+			}
+		}
+		return snmpHealth(worst)
+	}
+
+	serial := func(serial string) string {
+		if *anonymizeSerials {
+			return anonymizeSerial(serial)
+		}
+		return serial
+	}
+
+	var objects []snmpValue
+	add := func(name oid, kind uint16, value interface{}) {
+		object := snmpValue{OID: name, Type: kind}
+		switch v := value.(type) {
+		case string:
+			object.String = v
+		case int:
+			object.Int = int64(v)
+		case int64:
+			object.Int = v
+		case float64:
+			object.Int = int64(v)
+		}
+		objects = append(objects, object)
+	}
+
+	controllerEntry := base.append(1, 1)
+	virtualDriveEntry := base.append(2, 1)
+	physicalDriveEntry := base.append(3, 1)
+	for _, controller := range system.Controllers {
+		index := uint32(controller.Index)
+		add(controllerEntry.append(2, index), snmpOctetString, controller.Model)
+		add(controllerEntry.append(3, index), snmpOctetString, serial(controller.Serial))
+		add(controllerEntry.append(4, index), snmpOctetString, controller.Status)
+		add(controllerEntry.append(5, index), snmpInteger, health(controllerObject(controller)))
+		add(controllerEntry.append(6, index), snmpInteger, controller.Temperature)
+
+		for _, virtualDrive := range controller.VirtualDrives {
+			number, err := strconv.ParseUint(virtualDrive.VolumeGroup, 10, 32)
+			if err != nil {
+				continue
+			}
+			row := []uint32{index, uint32(number)}
+			add(virtualDriveEntry.append(append([]uint32{3}, row...)...), snmpOctetString, virtualDrive.Name)
+			add(virtualDriveEntry.append(append([]uint32{4}, row...)...), snmpOctetString, virtualDrive.Type)
+			add(virtualDriveEntry.append(append([]uint32{5}, row...)...), snmpOctetString, virtualDrive.State)
+			add(virtualDriveEntry.append(append([]uint32{6}, row...)...), snmpInteger, health(virtualDriveObject(controller, virtualDrive)))
+		}
+
+		for _, drive := range controller.PhysicalDrives {
+			// Drives attached without an enclosure have none.
+			enclosure, _ := strconv.ParseUint(drive.Enclosure, 10, 32)
+			slot, err := strconv.ParseUint(drive.Slot, 10, 32)
+			if err != nil {
+				continue
+			}
+			row := []uint32{index, uint32(enclosure), uint32(slot)}
+			add(physicalDriveEntry.append(append([]uint32{4}, row...)...), snmpOctetString, drive.Model)
+			add(physicalDriveEntry.append(append([]uint32{5}, row...)...), snmpOctetString, serial(drive.Serial))
+			add(physicalDriveEntry.append(append([]uint32{6}, row...)...), snmpOctetString, drive.State)
+			add(physicalDriveEntry.append(append([]uint32{7}, row...)...), snmpCounter32, drive.MediaErrors)
+			add(physicalDriveEntry.append(append([]uint32{8}, row...)...), snmpCounter32, drive.OtherErrors)
+			add(physicalDriveEntry.append(append([]uint32{9}, row...)...), snmpCounter32, drive.PredictiveErrors)
+			add(physicalDriveEntry.append(append([]uint32{10}, row...)...), snmpInteger, health(physicalDriveObject(controller, drive)))
+		}
+	}
+
+	add(base.append(4, 1, 0), snmpInteger, snmpHealth(worstSeverity(findings)))
+	add(base.append(4, 2, 0), snmpInteger, len(findings))
+
+	return objects
+}
+
+// runAgentX collects at the interval and serves the results to snmpd,
+// connecting again whenever snmpd goes away.
+func runAgentX(source Source, address string, base oid, interval time.Duration) error {
+
+	mib := &agentxMIB{}
+	refresh := func() {
+		system, err := collect(source)
+		if err != nil {
+			log.Printf("AgentX: %v", err)
+			// Keep the last tables but say they can't be trusted.
+			mib.mu.Lock()
+			for i, object := range mib.objects {
+				if object.OID.compare(base.append(4, 1, 0)) == 0 {
+					mib.objects[i].Int = snmpHealthUnknown
+				}
+			}
+			if len(mib.objects) == 0 {
+				mib.objects = []snmpValue{{OID: base.append(4, 1, 0), Type: snmpInteger, Int: snmpHealthUnknown}}
+			}
+			mib.mu.Unlock()
+			return
+		}
+		applySimulations(system)
+		mib.set(agentxObjects(base, system))
+	}
+	refresh()
+	go func() {
+		for range time.Tick(interval) {
+			refresh()
+		}
+	}()
+
+	for {
+		err := serveAgentX(address, base, mib)
+		log.Printf("AgentX: %v, connecting again in 10s", err)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func serveAgentX(address string, base oid, mib *agentxMIB) error {
+
+	network := "unix"
+	if !strings.HasPrefix(address, "/") {
+		network = "tcp"
+	}
+	conn, err := net.DialTimeout(network, address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	session := &agentxSession{conn: conn}
+	var open bytes.Buffer
+	open.Write([]byte{60, 0, 0, 0}) // Timeout in seconds, reserved.
+	writeOID(&open, base, false)
+	writeOctetString(&open, "storcli-collector "+Version)
+	response, err := session.request(agentxOpen, open.Bytes())
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	session.id = response.sessionID
+
+	var register bytes.Buffer
+	register.Write([]byte{0, 127, 0, 0}) // Timeout, default priority, no range.
+	writeOID(&register, base, false)
+	if _, err := session.request(agentxRegister, register.Bytes()); err != nil {
+		return fmt.Errorf("registering %s: %w", base, err)
+	}
+	log.Printf("AgentX: registered %s with %s", base, address)
+
+	for {
+		pdu, err := readAgentXPDU(conn)
+		if err != nil {
+			return err
+		}
+		if err := session.handle(pdu, mib); err != nil {
+			return err
+		}
+	}
+}
+
+type agentxPDU struct {
+	kind          byte
+	flags         byte
+	sessionID     uint32
+	transactionID uint32
+	packetID      uint32
+	payload       []byte
+	order         binary.ByteOrder
+}
+
+type agentxSession struct {
+	conn     net.Conn
+	id       uint32
+	packetID uint32
+}
+
+// request sends a PDU of the subagent and waits for its response.
+func (s *agentxSession) request(kind byte, payload []byte) (*agentxPDU, error) {
+
+	s.packetID++
+	if err := s.send(kind, 0, s.packetID, payload); err != nil {
+		return nil, err
+	}
+	s.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer s.conn.SetReadDeadline(time.Time{})
+	response, err := readAgentXPDU(s.conn)
+	if err != nil {
+		return nil, err
+	}
+	if response.kind != agentxResponse || len(response.payload) < 8 {
+		return nil, errors.New("unexpected response")
+	}
+	if code := response.order.Uint16(response.payload[4:]); code != 0 {
+		return nil, fmt.Errorf("error %d", code)
+	}
+	return response, nil
+}
+
+func (s *agentxSession) send(kind byte, transactionID uint32, packetID uint32, payload []byte) error {
+
+	header := make([]byte, 20)
+	header[0] = 1
+	header[1] = kind
+	header[2] = agentxNetworkByteOrder
+	binary.BigEndian.PutUint32(header[4:], s.id)
+	binary.BigEndian.PutUint32(header[8:], transactionID)
+	binary.BigEndian.PutUint32(header[12:], packetID)
+	binary.BigEndian.PutUint32(header[16:], uint32(len(payload)))
+	_, err := s.conn.Write(append(header, payload...))
+	return err
+}
+
+// respond answers a request of snmpd with an error and the varbinds.
+func (s *agentxSession) respond(pdu *agentxPDU, code uint16, index uint16, values []snmpValue) error {
+
+	var payload bytes.Buffer
+	binary.Write(&payload, binary.BigEndian, uint32(0)) // sysUpTime
+	binary.Write(&payload, binary.BigEndian, code)
+	binary.Write(&payload, binary.BigEndian, index)
+	for _, value := range values {
+		writeVarBind(&payload, value)
+	}
+	return s.send(agentxResponse, pdu.transactionID, pdu.packetID, payload.Bytes())
+}
+
+func (s *agentxSession) handle(pdu *agentxPDU, mib *agentxMIB) error {
+
+	r := &agentxReader{data: pdu.payload, order: pdu.order}
+	if pdu.flags&agentxNonDefaultContext != 0 {
+		r.octetString()
+	}
+
+	switch pdu.kind {
+	case agentxGet:
+		var values []snmpValue
+		for r.more() {
+			start, _ := r.oid()
+			r.oid()
+			values = append(values, mib.get(start))
+		}
+		return s.respond(pdu, 0, 0, values)
+
+	case agentxGetNext:
+		var values []snmpValue
+		for r.more() {
+			start, include := r.oid()
+			end, _ := r.oid()
+			values = append(values, mib.next(start, include, end))
+		}
+		return s.respond(pdu, 0, 0, values)
+
+	case agentxGetBulk:
+		nonRepeaters := int(r.uint16())
+		maxRepetitions := int(r.uint16())
+		type searchRange struct {
+			start   oid
+			include bool
+			end     oid
+		}
+		var ranges []searchRange
+		for r.more() {
+			start, include := r.oid()
+			end, _ := r.oid()
+			ranges = append(ranges, searchRange{start, include, end})
+		}
+		var values []snmpValue
+		for i := 0; i < nonRepeaters && i < len(ranges); i++ {
+			values = append(values, mib.next(ranges[i].start, ranges[i].include, ranges[i].end))
+		}
+		if nonRepeaters > len(ranges) {
+			nonRepeaters = len(ranges)
+		}
+		repeaters := ranges[nonRepeaters:]
+		for repetition := 0; repetition < maxRepetitions && len(repeaters) > 0; repetition++ {
+			done := true
+			for i, search := range repeaters {
+				value := mib.next(search.start, search.include, search.end)
+				values = append(values, value)
+				repeaters[i] = searchRange{value.OID, false, search.end}
+				if value.Type != snmpEndOfMibView {
+					done = false
+				}
+			}
+			if done {
+				break
+			}
+		}
+		return s.respond(pdu, 0, 0, values)
+
+	case agentxTestSet:
+		return s.respond(pdu, agentxNotWritable, 1, nil)
+
+	case agentxCommitSet, agentxUndoSet, agentxCleanupSet, agentxPing:
+		return s.respond(pdu, 0, 0, nil)
+
+	case agentxClose:
+		return errors.New("snmpd closed the session")
+	}
+
+	return nil
+}
+
+func readAgentXPDU(conn net.Conn) (*agentxPDU, error) {
+
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[2]&agentxNetworkByteOrder != 0 {
+		order = binary.BigEndian
+	}
+	pdu := &agentxPDU{
+		kind:          header[1],
+		flags:         header[2],
+		sessionID:     order.Uint32(header[4:]),
+		transactionID: order.Uint32(header[8:]),
+		packetID:      order.Uint32(header[12:]),
+		order:         order,
+	}
+	length := order.Uint32(header[16:])
+	if length > 1<<20 {
+		return nil, fmt.Errorf("PDU of %d bytes", length)
+	}
+	pdu.payload = make([]byte, length)
+	if _, err := io.ReadFull(conn, pdu.payload); err != nil {
+		return nil, err
+	}
+	return pdu, nil
+}
+
+// agentxReader decodes the payload of a PDU. Malformed data reads as
+// zeros rather than panicking.
+type agentxReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func (r *agentxReader) more() bool {
+	return len(r.data) >= 4
+}
+
+func (r *agentxReader) take(n int) []byte {
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	taken := r.data[:n]
+	r.data = r.data[n:]
+	return taken
+}
+
+func (r *agentxReader) uint16() uint16 {
+	b := r.take(2)
+	if len(b) < 2 {
+		return 0
+	}
+	return r.order.Uint16(b)
+}
+
+func (r *agentxReader) uint32() uint32 {
+	b := r.take(4)
+	if len(b) < 4 {
+		return 0
+	}
+	return r.order.Uint32(b)
+}
+
+// oid reads an OID and its include field. A prefix of n stands for
+// 1.3.6.1.n.
+func (r *agentxReader) oid() (oid, bool) {
+	header := r.take(4)
+	if len(header) < 4 {
+		return nil, false
+	}
+	var name oid
+	if header[1] != 0 {
+		name = oid{1, 3, 6, 1, uint32(header[1])}
+	}
+	for i := 0; i < int(header[0]); i++ {
+		name = append(name, r.uint32())
+	}
+	return name, header[2] != 0
+}
+
+func (r *agentxReader) octetString() string {
+	length := int(r.uint32())
+	value := r.take(length)
+	r.take((4 - length%4) % 4)
+	return string(value)
+}
+
+func writeOID(buf *bytes.Buffer, name oid, include bool) {
+	var flag byte
+	if include {
+		flag = 1
+	}
+	buf.Write([]byte{byte(len(name)), 0, flag, 0})
+	for _, subid := range name {
+		binary.Write(buf, binary.BigEndian, subid)
+	}
+}
+
+func writeOctetString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(s)))
+	buf.WriteString(s)
+	buf.Write(make([]byte, (4-len(s)%4)%4))
+}
+
+func writeVarBind(buf *bytes.Buffer, value snmpValue) {
+	binary.Write(buf, binary.BigEndian, value.Type)
+	buf.Write([]byte{0, 0})
+	writeOID(buf, value.OID, false)
+	switch value.Type {
+	case snmpInteger:
+		binary.Write(buf, binary.BigEndian, int32(value.Int))
+	case snmpCounter32:
+		count := value.Int
+		if count < 0 {
+			count = 0
+		}
+		binary.Write(buf, binary.BigEndian, uint32(count))
+	case snmpOctetString:
+		writeOctetString(buf, value.String)
+	}
+}
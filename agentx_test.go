@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// agentxTestPDU frames a payload as snmpd would, in either byte order.
+func agentxTestPDU(kind byte, flags byte, order binary.ByteOrder, payload []byte) []byte {
+	header := make([]byte, 20)
+	header[0] = 1
+	header[1] = kind
+	header[2] = flags
+	order.PutUint32(header[4:], 7)
+	order.PutUint32(header[8:], 8)
+	order.PutUint32(header[12:], 9)
+	order.PutUint32(header[16:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+// agentxTestSearchRange encodes a start OID with the 1.3.6.1 prefix
+// compressed and an empty end.
+func agentxTestSearchRange(order binary.ByteOrder, subids []uint32, include bool) []byte {
+	var flag byte
+	if include {
+		flag = 1
+	}
+	data := []byte{byte(len(subids)), 4, flag, 0}
+	for _, subid := range subids {
+		data = append(data, 0, 0, 0, 0)
+		order.PutUint32(data[len(data)-4:], subid)
+	}
+	return append(data, 0, 0, 0, 0)
+}
+
+func TestReadAgentXPDU(t *testing.T) {
+
+	for _, test := range []struct {
+		name  string
+		flags byte
+		order binary.ByteOrder
+	}{
+		{"network byte order", agentxNetworkByteOrder, binary.BigEndian},
+		{"little endian", 0, binary.LittleEndian},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			payload := agentxTestSearchRange(test.order, []uint32{1, 8072, 9999}, true)
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go client.Write(agentxTestPDU(agentxGetNext, test.flags, test.order, payload))
+
+			pdu, err := readAgentXPDU(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pdu.kind != agentxGetNext || pdu.sessionID != 7 || pdu.transactionID != 8 || pdu.packetID != 9 {
+				t.Errorf("got kind %d, session %d, transaction %d, packet %d", pdu.kind, pdu.sessionID, pdu.transactionID, pdu.packetID)
+			}
+			if !bytes.Equal(pdu.payload, payload) {
+				t.Errorf("got payload %x, want %x", pdu.payload, payload)
+			}
+
+			r := &agentxReader{data: pdu.payload, order: pdu.order}
+			start, include := r.oid()
+			if want := (oid{1, 3, 6, 1, 4, 1, 8072, 9999}); !reflect.DeepEqual(start, want) || !include {
+				t.Errorf("got start %v, %v, want %v, true", start, include, want)
+			}
+			if end, _ := r.oid(); len(end) != 0 {
+				t.Errorf("got end %v, want none", end)
+			}
+			if r.more() {
+				t.Errorf("%d bytes left over", len(r.data))
+			}
+		})
+	}
+}
+
+func TestAgentXReaderMalformed(t *testing.T) {
+
+	// Truncated data reads as zeros instead of panicking.
+	r := &agentxReader{data: []byte{3, 0, 0, 0, 0, 0, 0, 1}, order: binary.BigEndian}
+	name, _ := r.oid()
+	if want := (oid{1, 0, 0}); !reflect.DeepEqual(name, want) {
+		t.Errorf("got %v, want %v", name, want)
+	}
+	if r.uint16() != 0 || r.uint32() != 0 || r.octetString() != "" {
+		t.Error("reading past the end didn't give zeros")
+	}
+}
+
+func TestAgentXOctetStringPadding(t *testing.T) {
+
+	for _, s := range []string{"", "a", "abcd", "abcde"} {
+		var buf bytes.Buffer
+		writeOctetString(&buf, s)
+		if buf.Len()%4 != 0 {
+			t.Errorf("%q is %d bytes, not padded to 4", s, buf.Len())
+		}
+		buf.Write([]byte{0, 0, 0, 42})
+		r := &agentxReader{data: buf.Bytes(), order: binary.BigEndian}
+		if got := r.octetString(); got != s {
+			t.Errorf("got %q, want %q", got, s)
+		}
+		if next := r.uint32(); next != 42 {
+			t.Errorf("after %q read %d, want 42", s, next)
+		}
+	}
+}
+
+func TestAgentXGetNext(t *testing.T) {
+
+	base := oid{1, 3, 6, 1, 4, 1, 8072, 9999, 9999, 1}
+	mib := &agentxMIB{}
+	mib.set([]snmpValue{
+		{OID: base.append(2, 1), Type: snmpOctetString, String: "PERC H730P Mini"},
+		{OID: base.append(1), Type: snmpInteger, Int: 2},
+	})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	session := &agentxSession{conn: server, id: 7}
+
+	payload := agentxTestSearchRange(binary.LittleEndian, []uint32{1, 8072, 9999, 9999, 1, 1}, false)
+	pdu := &agentxPDU{kind: agentxGetNext, transactionID: 8, packetID: 9, payload: payload, order: binary.LittleEndian}
+	go session.handle(pdu, mib)
+
+	response, err := readAgentXPDU(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.kind != agentxResponse || response.transactionID != 8 || response.packetID != 9 {
+		t.Fatalf("got kind %d, transaction %d, packet %d", response.kind, response.transactionID, response.packetID)
+	}
+
+	r := &agentxReader{data: response.payload, order: response.order}
+	r.uint32() // sysUpTime
+	if code, index := r.uint16(), r.uint16(); code != 0 || index != 0 {
+		t.Errorf("got error %d at %d", code, index)
+	}
+	valueType := r.uint16()
+	r.uint16()
+	name, _ := r.oid()
+	value := r.octetString()
+	if valueType != snmpOctetString || !reflect.DeepEqual(name, base.append(2, 1)) || value != "PERC H730P Mini" {
+		t.Errorf("got type %d, %v = %q", valueType, name, value)
+	}
+}
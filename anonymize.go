@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var anonymizeSerials = flag.Bool("anonymize-serials", false, "Replace controller and drive serial numbers in labels with a stable hash, for metrics shipped to third parties.")
+
+// anonymizeSerial hashes a serial number. The same serial always gets
+// the same hash, so drives can still be followed over time and across
+// slots.
+func anonymizeSerial(serial string) string {
+	if serial == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(serial))
+	return hex.EncodeToString(sum[:8])
+}
+
+// withAnonymizedSerials replaces the value of every serial label.
+func withAnonymizedSerials(families []*dto.MetricFamily) {
+
+	if !*anonymizeSerials {
+		return
+	}
+
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			for _, label := range metric.Label {
+				if label.GetName() == "serial" {
+					anonymized := anonymizeSerial(label.GetValue())
+					label.Value = &anonymized
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAnonymizeSerial(t *testing.T) {
+
+	sum := sha256.Sum256([]byte("S0M1ABCD"))
+	tests := []struct {
+		serial string
+		hash   string
+	}{
+		{"S0M1ABCD", hex.EncodeToString(sum[:8])},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if hash := anonymizeSerial(test.serial); hash != test.hash {
+			t.Errorf("%q: got %q, want %q", test.serial, hash, test.hash)
+		}
+	}
+	if anonymizeSerial("S0M1ABCD") == anonymizeSerial("S0M1ABCE") {
+		t.Error("different serials got the same hash")
+	}
+}
+
+func TestWithAnonymizedSerials(t *testing.T) {
+
+	defer func(enabled bool) { *anonymizeSerials = enabled }(*anonymizeSerials)
+
+	families := func() []*dto.MetricFamily {
+		return []*dto.MetricFamily{{
+			Name: proto.String("megaraid_pd_info"),
+			Metric: []*dto.Metric{{Label: []*dto.LabelPair{
+				{Name: proto.String("serial"), Value: proto.String("S0M1ABCD")},
+				{Name: proto.String("slot"), Value: proto.String("S0M1ABCD")},
+			}}},
+		}}
+	}
+
+	tests := []struct {
+		enabled bool
+		serial  string
+	}{
+		{false, "S0M1ABCD"},
+		{true, anonymizeSerial("S0M1ABCD")},
+	}
+
+	for _, test := range tests {
+		*anonymizeSerials = test.enabled
+		anonymized := families()
+		withAnonymizedSerials(anonymized)
+		labels := anonymized[0].Metric[0].Label
+		if labels[0].GetValue() != test.serial {
+			t.Errorf("enabled %v: got serial %q, want %q", test.enabled, labels[0].GetValue(), test.serial)
+		}
+		if labels[1].GetValue() != "S0M1ABCD" {
+			t.Errorf("enabled %v: other labels changed to %q", test.enabled, labels[1].GetValue())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+var auditLog = flag.String("audit-log", "", "(Optional) Append every command that is run to this file as JSON lines, with its duration and exit code.")
+
+// AuditRecord is a line of the audit log.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// The whole command line, including sudo or other wrappers.
+	Command  []string `json:"command"`
+	Duration float64  `json:"duration_seconds"`
+	ExitCode int      `json:"exit_code"`
+	Error    string   `json:"error,omitempty"`
+	// The collector's user, before sudo if any. -1 on Windows.
+	UID int `json:"uid"`
+}
+
+var auditMu sync.Mutex
+
+// audit appends the finished command to --audit-log. An audit log that
+// can't be written is logged but doesn't stop the collection.
+func audit(cmd *exec.Cmd, start time.Time, err error) {
+
+	if *auditLog == "" {
+		return
+	}
+
+	record := AuditRecord{
+		Time:     start,
+		Command:  cmd.Args,
+		Duration: time.Since(start).Seconds(),
+		UID:      os.Getuid(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+		record.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			record.ExitCode = exitErr.ExitCode()
+		}
+	}
+	line, _ := json.Marshal(record)
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	file, openErr := os.OpenFile(*auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if openErr != nil {
+		log.Printf("Could not write audit log: %v", openErr)
+		return
+	}
+	defer file.Close()
+	if _, writeErr := file.Write(append(line, '\n')); writeErr != nil {
+		log.Printf("Could not write audit log: %v", writeErr)
+	}
+}
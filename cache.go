@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+var cacheTTL = flag.Duration("cache-ttl", 0, "Reuse the output of the drive detail commands, the slowest ones, for this long, e.g. 5m. Controller, virtual drive and drive states are still read on every collection.")
+
+// CacheSource keeps the output of drive detail commands for a while.
+// They take the longest on hosts with many drives, while what they
+// report, SMART counters and error counts, changes slowly.
+type CacheSource struct {
+	Source Source
+	TTL    time.Duration
+	// For megaraid_cache_age_seconds, see takeCacheAge.
+	Host string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	data []byte
+	time time.Time
+}
+
+func NewCacheSource(source Source, ttl time.Duration, host string) *CacheSource {
+	return &CacheSource{Source: source, TTL: ttl, Host: host, entries: map[string]cacheEntry{}}
+}
+
+func (c *CacheSource) Query(args ...string) ([]byte, error) {
+
+	if commandKind(args) != "drives" {
+		return c.Source.Query(args...)
+	}
+
+	key := strings.Join(args, " ")
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	c.mu.Unlock()
+	if found && time.Since(entry.time) < c.TTL {
+		recordCacheHit(c.Host, entry.time)
+		return entry.data, nil
+	}
+
+	// Failed output isn't kept, the next collection tries again.
+	data, err := c.Source.Query(args...)
+	if err == nil {
+		c.mu.Lock()
+		c.entries[key] = cacheEntry{data, time.Now()}
+		c.mu.Unlock()
+	}
+	return data, err
+}
+
+// Drive details are buffered to be kept, everything else is streamed.
+func (c *CacheSource) QueryStream(args ...string) (io.ReadCloser, error) {
+	if commandKind(args) == "drives" {
+		data, err := c.Query(args...)
+		return bufferedOutput(data, err), nil
+	}
+	return queryStream(c.Source, args...)
+}
+
+// When the oldest cached output used by the current collection of each
+// host was fresh.
+var cacheHits = struct {
+	sync.Mutex
+	oldest map[string]time.Time
+}{oldest: map[string]time.Time{}}
+
+func recordCacheHit(host string, fresh time.Time) {
+	cacheHits.Lock()
+	defer cacheHits.Unlock()
+	if oldest, found := cacheHits.oldest[host]; !found || fresh.Before(oldest) {
+		cacheHits.oldest[host] = fresh
+	}
+}
+
+// takeCacheAge returns the age of the oldest cached output the last
+// collection of the host used, zero if it ran every command, and starts
+// over for the next collection.
+func takeCacheAge(host string) time.Duration {
+	cacheHits.Lock()
+	defer cacheHits.Unlock()
+	oldest, found := cacheHits.oldest[host]
+	delete(cacheHits.oldest, host)
+	if !found {
+		return 0
+	}
+	return time.Since(oldest)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Exit codes of a Nagios plugin.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+func init() {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+
+	RegisterSubcommand("check", &Subcommand{
+		Flags: flags,
+		Run: func(source Source) error {
+			os.Exit(runCheck(source))
+			return nil
+		},
+		ExitCode: nagiosUnknown,
+	})
+}
+
+// runCheck prints the result of the health rules the way Nagios and
+// Icinga expect it: a status line with performance data, then a line
+// per finding. It returns the exit code.
+func runCheck(source Source) int {
+
+	system, err := collect(source)
+	if err != nil {
+		fmt.Printf("RAID UNKNOWN - %v\n", err)
+		return nagiosUnknown
+	}
+	applySimulations(system)
+
+	findings := evaluateHealth(system)
+	worst := worstSeverity(findings)
+
+	var summary string
+	switch {
+	case len(findings) == 0:
+		summary = checkInventory(system)
+	case len(findings) == 1:
+		summary = findings[0].Object + " " + findings[0].Message
+	default:
+		summary = fmt.Sprintf("%d problems, worst: %s %s", len(findings), findings[0].Object, findings[0].Message)
+	}
+	fmt.Printf("RAID %s - %s | %s\n", checkStatus(worst), summary, checkPerfdata(system))
+	for _, finding := range findings {
+		fmt.Printf("%s: %s %s (%s)\n", finding.Severity, finding.Object, finding.Message, finding.Rule)
+	}
+
+	switch worst {
+	case SeverityCrit:
+		return nagiosCritical
+	case SeverityWarn:
+		return nagiosWarning
+	}
+	return nagiosOK
+}
+
+func checkStatus(severity Severity) string {
+	switch severity {
+	case SeverityCrit:
+		return "CRITICAL"
+	case SeverityWarn:
+		return "WARNING"
+	}
+	return "OK"
+}
+
+func checkInventory(system *System) string {
+
+	var virtualDrives, physicalDrives int
+	for _, controller := range system.Controllers {
+		virtualDrives += len(controller.VirtualDrives)
+		physicalDrives += len(controller.PhysicalDrives)
+	}
+	return fmt.Sprintf("%d controllers, %d virtual drives, %d physical drives", len(system.Controllers), virtualDrives, physicalDrives)
+}
+
+// checkPerfdata counts objects by state, so trends show up in the
+// graphs of the monitoring system.
+func checkPerfdata(system *System) string {
+
+	counts := map[string]int{"controllers": len(system.Controllers), "failed_collections": len(system.FailedControllers)}
+	names := []string{"controllers", "failed_collections", "vds", "vds_degraded", "vds_offline", "drives", "drives_failed", "drives_critical"}
+	for _, controller := range system.Controllers {
+		for _, virtualDrive := range controller.VirtualDrives {
+			counts["vds"]++
+			if virtualDrive.Degraded() {
+				counts["vds_degraded"]++
+			}
+			if virtualDrive.Offline() {
+				counts["vds_offline"]++
+			}
+		}
+		for _, drive := range controller.PhysicalDrives {
+			counts["drives"]++
+			if drive.Failed() {
+				counts["drives_failed"]++
+			}
+			if drive.Critical() {
+				counts["drives_critical"]++
+			}
+		}
+	}
+
+	var perfdata []string
+	for _, name := range names {
+		perfdata = append(perfdata, fmt.Sprintf("%s=%d", name, counts[name]))
+	}
+	return strings.Join(perfdata, " ")
+}
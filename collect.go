@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var storcliTZ = flag.String("storcli-tz", "", "Time zone to run storcli in and read its dates with, e.g. UTC. Defaults to the host's.")
+
+// storcliLocation is the time zone of the dates storcli prints.
+var storcliLocation = time.Local
+
+var concurrency = flag.Int("concurrency", 4, "Query up to this many controllers at once.")
+
+// Source runs a storcli query and returns its raw output. It is the only
+// stage that talks to the controller.
+type Source interface {
+	Query(args ...string) ([]byte, error)
+}
+
+// StorcliSource executes the storcli binary directly.
+type StorcliSource struct {
+	Path string
+	// Appended to the command line, keyed by commandKind.
+	ExtraArgs map[string][]string
+	// storcli can hang for minutes on a sick controller. Zero waits
+	// forever.
+	Timeout time.Duration
+	// TZ of the storcli process. Empty keeps the host's.
+	TimeZone string
+	// Command storcli is started with, e.g. sudo, see storcliWrapper.
+	Wrapper []string
+	// Locked around every command, see --storcli-lock-file.
+	LockFile string
+	Priority Priority
+}
+
+func (s StorcliSource) Query(args ...string) ([]byte, error) {
+
+	if _, err := os.Stat(s.executable()); os.IsNotExist(err) {
+		return nil, err
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	if s.LockFile != "" {
+		unlock, err := lockStorcli(ctx, s.LockFile)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	cmd := s.command(ctx, args)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	start := time.Now()
+	err := cmd.Start()
+	if err == nil {
+		s.lowerPriority(cmd)
+		err = cmd.Wait()
+	}
+	data := stdout.Bytes()
+	audit(cmd, start, err)
+	if ctx.Err() == context.DeadlineExceeded {
+		return data, fmt.Errorf("storcli %s timed out after %s", strings.Join(cmd.Args[1:], " "), s.Timeout)
+	}
+	if err != nil && shutdownContext.Err() != nil {
+		return data, fmt.Errorf("storcli %s: killed to shut down", strings.Join(cmd.Args[1:], " "))
+	}
+
+	return data, err
+}
+
+// QueryStream runs the query and returns its output while storcli is
+// still writing it.
+func (s StorcliSource) QueryStream(args ...string) (io.ReadCloser, error) {
+
+	if _, err := os.Stat(s.executable()); os.IsNotExist(err) {
+		return nil, err
+	}
+
+	ctx, cancel := s.context()
+	unlock := func() {}
+	if s.LockFile != "" {
+		var err error
+		if unlock, err = lockStorcli(ctx, s.LockFile); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	cmd := s.command(ctx, args)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		unlock()
+		cancel()
+		return nil, err
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		audit(cmd, start, err)
+		unlock()
+		cancel()
+		return nil, err
+	}
+	s.lowerPriority(cmd)
+
+	return &commandOutput{ReadCloser: stdout, cmd: cmd, ctx: ctx, cancel: cancel, unlock: unlock, timeout: s.Timeout, start: start}, nil
+}
+
+// executable is the binary that is run, which is the wrapper if there
+// is one.
+func (s StorcliSource) executable() string {
+	if len(s.Wrapper) > 0 {
+		return s.Wrapper[0]
+	}
+	return s.Path
+}
+
+func (s StorcliSource) context() (context.Context, context.CancelFunc) {
+	if s.Timeout > 0 {
+		return context.WithTimeout(shutdownContext, s.Timeout)
+	}
+	return context.WithCancel(shutdownContext)
+}
+
+var priorityFailed sync.Once
+
+// lowerPriority applies --storcli-nice and --storcli-ionice to the
+// started command. Failing to is logged once and storcli keeps running
+// at the normal priority.
+func (s StorcliSource) lowerPriority(cmd *exec.Cmd) {
+	if s.Priority == (Priority{}) {
+		return
+	}
+	if err := lowerPriority(cmd.Process.Pid, s.Priority); err != nil {
+		priorityFailed.Do(func() {
+			log.Printf("Lowering the priority of storcli: %v", err)
+		})
+	}
+}
+
+func (s StorcliSource) command(ctx context.Context, args []string) *exec.Cmd {
+
+	kind := commandKind(args)
+	args = append(append([]string{}, args...), s.ExtraArgs["all"]...)
+	args = append(args, s.ExtraArgs[kind]...)
+
+	if len(s.Wrapper) > 0 {
+		args = append(append(append([]string{}, s.Wrapper[1:]...), s.Path), args...)
+	}
+	cmd := exec.CommandContext(ctx, s.executable(), args...)
+	killProcessGroup(cmd)
+	// Don't wait on children of a killed storcli that still hold
+	// the output pipe open.
+	cmd.WaitDelay = time.Second
+	// Localized OEM builds translate month and day names, which the
+	// fixed date formats can't parse.
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "LANG=C")
+	if s.TimeZone != "" {
+		cmd.Env = append(cmd.Env, "TZ="+s.TimeZone)
+	}
+	return cmd
+}
+
+// commandOutput is the stdout of a running storcli. Close waits for the
+// command and returns its error.
+type commandOutput struct {
+	io.ReadCloser
+	cmd     *exec.Cmd
+	ctx     context.Context
+	cancel  context.CancelFunc
+	unlock  func()
+	timeout time.Duration
+	start   time.Time
+}
+
+func (o *commandOutput) Close() error {
+
+	defer o.cancel()
+	defer o.unlock()
+
+	// storcli blocks on a full pipe if the reader stopped early.
+	io.Copy(io.Discard, o.ReadCloser)
+	err := o.cmd.Wait()
+	audit(o.cmd, o.start, err)
+	if o.ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("storcli %s timed out after %s", strings.Join(o.cmd.Args[1:], " "), o.timeout)
+	}
+	if err != nil && shutdownContext.Err() != nil {
+		return fmt.Errorf("storcli %s: killed to shut down", strings.Join(o.cmd.Args[1:], " "))
+	}
+
+	return err
+}
+
+// StreamingSource is a Source that can hand out output before the
+// command has finished, so large outputs don't have to be buffered.
+type StreamingSource interface {
+	Source
+	QueryStream(args ...string) (io.ReadCloser, error)
+}
+
+// queryStream streams the query if the source supports it and buffers
+// it otherwise. The command error is returned by Close.
+func queryStream(source Source, args ...string) (io.ReadCloser, error) {
+	if streaming, ok := source.(StreamingSource); ok {
+		return streaming.QueryStream(args...)
+	}
+	data, err := source.Query(args...)
+	return bufferedOutput(data, err), nil
+}
+
+func bufferedOutput(data []byte, err error) io.ReadCloser {
+	return &queryOutput{Reader: bytes.NewReader(data), err: err}
+}
+
+type queryOutput struct {
+	io.Reader
+	err error
+}
+
+func (o *queryOutput) Close() error {
+	return o.err
+}
+
+// commandKind names the type of a storcli query, for settings that only
+// apply to some of them.
+func commandKind(args []string) string {
+	if len(args) > 2 {
+		switch args[2] {
+		case "events":
+			return "events"
+		case "termlog":
+			return "termlog"
+		}
+	}
+	if len(args) > 0 && strings.Contains(args[0], "/s") {
+		return "drives"
+	}
+	return "controllers"
+}
+
+// collect queries the source and builds the normalized model. Every
+// controller is queried on its own, up to --concurrency at a time, so a
+// controller with broken output doesn't take the others down with it.
+func collect(source Source) (*System, error) {
+
+	waited := storcliLockWaitTotal()
+	system, err := collectBackend(source)
+	if system != nil {
+		system.StorcliLockWait = (storcliLockWaitTotal() - waited).Seconds()
+	}
+	return system, err
+}
+
+func collectBackend(source Source) (*System, error) {
+
+	if *backend == "megacli" {
+		return collectMegaCLI(source)
+	}
+
+	data, cmdErr := source.Query("show", "ctrlcount", "J")
+	count, err := parseControllerCount(data)
+	plainText := false
+	if err != nil && looksLikeText(data) {
+		log.Print("storcli doesn't support JSON output, falling back to plain text")
+		data, cmdErr = source.Query("show", "ctrlcount")
+		count, err = parseTextControllerCount(data)
+		plainText = true
+	}
+	if err != nil {
+		return nil, wrapCommandError(err, cmdErr)
+	}
+
+	workers := *concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	slots := make(chan struct{}, workers)
+	states := make([]*ControllerState, count)
+	errs := make([]error, count)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			states[i], errs[i] = collectController(source, i, plainText)
+		}(i)
+	}
+	wg.Wait()
+
+	system := &System{StorcliVersion: parseCLIVersion(data)}
+	for i := 0; i < count; i++ {
+		if errs[i] != nil {
+			log.Printf("Could not collect controller %d: %v", i, errs[i])
+			system.FailedControllers = append(system.FailedControllers, i)
+			continue
+		}
+		system.Controllers = append(system.Controllers, states[i])
+	}
+
+	if len(system.Controllers) == 0 {
+		return nil, errors.New("Could not collect any controller.")
+	}
+
+	return system, nil
+}
+
+// collectController runs the queries of a single controller. With
+// plainText, storcli is queried without the J switch and only the
+// summary of every drive is read.
+func collectController(source Source, index int, plainText bool) (*ControllerState, error) {
+
+	controllerPath := "/c" + strconv.Itoa(index)
+
+	var data []byte
+	var cmdErr, err error
+	var getControllers ControllerData
+	if plainText {
+		data, cmdErr = source.Query(controllerPath, "show", "all")
+		getControllers, err = parseTextControllers(data)
+	} else {
+		data, cmdErr = source.Query(controllerPath, "show", "all", "J")
+		getControllers, err = parseControllers(data)
+	}
+	if err != nil {
+		return nil, wrapCommandError(err, cmdErr)
+	}
+	controller := getControllers.Controllers[0]
+	state := newControllerState(controller)
+
+	if state.IsHBA() {
+		data, err := source.Query(controllerPath+"/pALL", "show", "phyerrorcounters", "J")
+		if phyErrors, parseErr := parsePhyErrors(data); parseErr == nil {
+			state.PhyErrors = phyErrors
+		} else {
+			log.Printf("Could not read PHY errors of controller %d: %v", state.Index, wrapCommandError(parseErr, err))
+		}
+	}
+
+	if state.SecurityEnabled && state.IsMegaraid() {
+		data, err := source.Query(controllerPath, "show", "securitykey", "J")
+		if mode := parseKeyManagement(data); mode != "" {
+			state.KeyManagement = mode
+		} else if err != nil {
+			log.Printf("Could not read the security key of controller %d: %v", state.Index, err)
+		}
+	}
+
+	if eventsEnabled() && state.IsMegaraid() {
+		data, err := queryEvents(source, state.Index)
+		if err != nil && len(data) == 0 {
+			log.Printf("Could not read events of controller %d: %v", state.Index, err)
+		} else {
+			state.Events = parseEvents(data)
+			state.EventsCollected = true
+			correctEventTimes(state)
+		}
+	}
+
+	if termLogEnabled() && state.IsMegaraid() {
+		data, err := queryTermLog(source, state.Index)
+		if err != nil && len(data) == 0 {
+			log.Printf("Could not read termlog of controller %d: %v", state.Index, err)
+		} else {
+			state.TermLogErrors = countTermLogErrors(data)
+			state.TermLogCollected = true
+		}
+	}
+
+	if state.PhysicalDriveCount == 0 {
+		return state, nil
+	}
+
+	var driveInfo map[string]interface{}
+	if !plainText {
+		// The drive details run to tens of megabytes on large JBODs,
+		// so they are decoded while storcli is still writing them.
+		output, err := queryStream(source, controllerPath+"/eALL/sALL", "show", "all", "J")
+		if err != nil {
+			return nil, err
+		}
+		drives, err := parseDrives(output)
+		cmdErr = output.Close()
+		if err != nil {
+			return nil, wrapCommandError(err, cmdErr)
+		}
+		driveInfo = drives.ByController()[state.Index]
+	}
+
+	for _, physicalDrive := range controller.ResponseData.PDList {
+		drive, err := safePhysicalDriveState(physicalDrive, driveInfo, state.Index)
+		if err != nil {
+			log.Printf("Skipping drive %s of controller %d: %v", physicalDrive.EIDSlt, state.Index, err)
+			continue
+		}
+		state.PhysicalDrives = append(state.PhysicalDrives, drive)
+	}
+
+	return state, nil
+}
+
+// safePhysicalDriveState keeps one drive with output nobody anticipated
+// from taking down the whole collection.
+func safePhysicalDriveState(physicalDrive PhysicalDrive, driveInfo map[string]interface{}, controllerIndex int) (drive *PhysicalDriveState, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unexpected drive details: %v", r)
+		}
+	}()
+	return newPhysicalDriveState(physicalDrive, driveInfo, controllerIndex), nil
+}
+
+// storcli often exits non-zero and still prints usable JSON, so the
+// command error only matters once parsing has failed too.
+func wrapCommandError(err error, cmdErr error) error {
+	if cmdErr == nil {
+		return err
+	}
+	return fmt.Errorf("%w (storcli: %v)", err, cmdErr)
+}
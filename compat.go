@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var compatPrefix = flag.String("compat-prefix", "", "Also expose every metric under this prefix instead of "+Namespace+"_, e.g. storcli, so dashboards built for other exporters keep working while migrating.")
+
+// withCompatPrefix appends a copy of every family renamed to
+// --compat-prefix. The samples are shared, only the names differ.
+func withCompatPrefix(families []*dto.MetricFamily) []*dto.MetricFamily {
+
+	if *compatPrefix == "" {
+		return families
+	}
+
+	prefix := strings.TrimSuffix(*compatPrefix, "_") + "_"
+	renamed := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		name := prefix + strings.TrimPrefix(family.GetName(), Namespace+"_")
+		renamed = append(renamed, &dto.MetricFamily{
+			Name:   &name,
+			Help:   family.Help,
+			Type:   family.Type,
+			Unit:   family.Unit,
+			Metric: family.Metric,
+		})
+	}
+
+	return append(families, renamed...)
+}
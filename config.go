@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+var configFile = flag.String("config", "", "(Optional) JSON configuration file.")
+
+// Config holds the settings that are too site specific for flags.
+type Config struct {
+	// Arguments appended to storcli commands, keyed by command kind
+	// (see commandKind). Arguments under "all" go to every command.
+	ExtraArgs map[string][]string `json:"extra_args"`
+
+	// Firmware and driver combinations the platform team signed off
+	// on. Controllers running anything else are flagged.
+	ApprovedCombinations []Combination `json:"approved_combinations"`
+
+	// Overrides of the default health rules, by rule name.
+	HealthRules map[string]HealthRuleOverride `json:"health_rules"`
+
+	// Commands and webhooks to run when findings change, see Hook.
+	Hooks []Hook `json:"hooks"`
+
+	// Labels added to every metric, over those of --labels.
+	Labels map[string]string `json:"labels"`
+
+	// Replace --collect-events, --collect-termlog and --events-filter
+	// when set.
+	CollectEvents  *bool   `json:"collect_events"`
+	CollectTermLog *bool   `json:"collect_termlog"`
+	EventsFilter   *string `json:"events_filter"`
+
+	// Replaces --interval of a collector running with it, e.g. "120s".
+	Interval string `json:"interval"`
+}
+
+// Combination is an approved firmware and driver pair. An empty field
+// matches anything, so a combination can be limited to one model or
+// leave the driver open.
+type Combination struct {
+	Model    string `json:"model"`
+	Firmware string `json:"firmware"`
+	Driver   string `json:"driver"`
+}
+
+func (c Combination) Matches(controller *ControllerState) bool {
+	return (c.Model == "" || c.Model == controller.Model) &&
+		(c.Firmware == "" || c.Firmware == controller.FirmwareVersion) &&
+		(c.Driver == "" || c.Driver == controller.DriverVersion)
+}
+
+// isApprovedCombination reports whether the controller runs one of the
+// approved combinations.
+func isApprovedCombination(controller *ControllerState, approved []Combination) bool {
+	for _, combination := range approved {
+		if combination.Matches(controller) {
+			return true
+		}
+	}
+	return false
+}
+
+var config Config
+
+// liveConfig is the config in effect for collections, which in HTTP mode
+// run while the file is reloaded.
+var liveConfig atomic.Pointer[Config]
+
+// configLabels returns --labels with the labels of the config file over
+// them.
+func configLabels() map[string]string {
+	labels := map[string]string{}
+	for name, value := range staticLabels {
+		labels[name] = value
+	}
+	if loaded := liveConfig.Load(); loaded != nil {
+		for name, value := range loaded.Labels {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+func eventsEnabled() bool {
+	if loaded := liveConfig.Load(); loaded != nil && loaded.CollectEvents != nil {
+		return *loaded.CollectEvents
+	}
+	return *collectEvents
+}
+
+func termLogEnabled() bool {
+	if loaded := liveConfig.Load(); loaded != nil && loaded.CollectTermLog != nil {
+		return *loaded.CollectTermLog
+	}
+	return *collectTermLog
+}
+
+func eventsFilterArgs() []string {
+	if loaded := liveConfig.Load(); loaded != nil && loaded.EventsFilter != nil {
+		return strings.Fields(*loaded.EventsFilter)
+	}
+	return strings.Fields(*eventsFilter)
+}
+
+// collectionInterval returns the interval of the config file in place of
+// the one of --interval. Without --interval the collector runs once and
+// the config file can't change that.
+func collectionInterval(flagInterval time.Duration) time.Duration {
+	if loaded := liveConfig.Load(); loaded != nil && loaded.Interval != "" && flagInterval > 0 {
+		interval, _ := time.ParseDuration(loaded.Interval)
+		return interval
+	}
+	return flagInterval
+}
+
+func loadConfig(path string) (Config, error) {
+
+	var loaded Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return loaded, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&loaded); err != nil {
+		return loaded, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return loaded, nil
+}
+
+// applyConfig loads the config file and puts it into effect, with its
+// health rules over the default ones.
+func applyConfig(path string) error {
+
+	loaded, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	rules, err := mergeHealthRules(mustParseHealthRules(defaultHealthRulesJSON), loaded.HealthRules)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateHooks(loaded.Hooks); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name := range loaded.Labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("%s: labels: %q is not a valid label name", path, name)
+		}
+	}
+	if loaded.Interval != "" {
+		if interval, err := time.ParseDuration(loaded.Interval); err != nil || interval <= 0 {
+			return fmt.Errorf("%s: interval: %q is not a duration like 60s", path, loaded.Interval)
+		}
+	}
+
+	config, healthRules = loaded, rules
+	liveConfig.Store(&loaded)
+	return nil
+}
+
+// reloadSignal returns the SIGHUP that asks a running collector to read
+// --config again, instead of ending it.
+func reloadSignal() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	return signals
+}
+
+// reloadConfig applies --config again. A file that doesn't load keeps the
+// running config. Everything but extra_args takes effect with the next
+// collection; the extra_args are set up with the sources at start and
+// need a restart.
+func reloadConfig(path string) {
+
+	if path == "" {
+		log.Print("Nothing to reload without --config")
+		return
+	}
+
+	extraArgs := config.ExtraArgs
+	if err := applyConfig(path); err != nil {
+		log.Printf("Keeping the previous config: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(extraArgs, config.ExtraArgs) {
+		log.Print("extra_args changed, restart the collector to apply them")
+	}
+	config.ExtraArgs = extraArgs
+	live := config
+	liveConfig.Store(&live)
+	log.Printf("Reloaded %s", path)
+}
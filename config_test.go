@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// restoreConfig puts the config in effect before the test back.
+func restoreConfig(t *testing.T) {
+	previous, rules, live := config, healthRules, liveConfig.Load()
+	t.Cleanup(func() {
+		config, healthRules = previous, rules
+		liveConfig.Store(live)
+	})
+}
+
+func TestApplyConfig(t *testing.T) {
+
+	restoreConfig(t)
+
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"empty", `{}`, true},
+		{"full", `{"extra_args": {"all": ["nolog"]}, "labels": {"site": "ams1"}, "interval": "120s",
+			"health_rules": {"pd_media_errors": {"severity": "warn"}}, "hooks": [{"exec": ["true"]}]}`, true},
+		{"not json", `extra_args: {}`, false},
+		{"unknown key", `{"labelz": {}}`, false},
+		{"bad label", `{"labels": {"site-name": "ams1"}}`, false},
+		{"reserved label", `{"labels": {"__name__": "x"}}`, false},
+		{"bad interval", `{"interval": "120"}`, false},
+		{"negative interval", `{"interval": "-1m"}`, false},
+		{"unknown rule", `{"health_rules": {"pd_on_fire": {"severity": "crit"}}}`, false},
+		{"bad hook", `{"hooks": [{}]}`, false},
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	for _, test := range tests {
+		if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfig(path); (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+
+	if err := applyConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("a missing file applied")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+
+	restoreConfig(t)
+	defer func(labels map[string]string) { staticLabels = labels }(staticLabels)
+	staticLabels = map[string]string{"site": "flag", "rack": "r1"}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"extra_args": {"all": ["nolog"]}, "labels": {"site": "ams1"}, "interval": "120s"}`)
+	if err := applyConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		labels   map[string]string
+		interval time.Duration
+		events   bool
+		filter   []string
+	}{
+		{"changed", `{"extra_args": {"all": ["other"]}, "labels": {"site": "ams2"}, "interval": "30s",
+			"collect_events": true, "events_filter": "type=latest=50"}`,
+			map[string]string{"site": "ams2", "rack": "r1"}, 30 * time.Second, true, []string{"type=latest=50"}},
+		{"keys removed", `{}`,
+			map[string]string{"site": "flag", "rack": "r1"}, time.Minute, false, []string{"type=sincereboot"}},
+		// A broken file keeps what is running.
+		{"broken", `{"labels": {"site-name": "x"}, "interval": "5s"}`,
+			map[string]string{"site": "flag", "rack": "r1"}, time.Minute, false, []string{"type=sincereboot"}},
+	}
+
+	for _, test := range tests {
+		write(test.data)
+		reloadConfig(path)
+		if labels := configLabels(); !reflect.DeepEqual(labels, test.labels) {
+			t.Errorf("%s: got labels %v, want %v", test.name, labels, test.labels)
+		}
+		if interval := collectionInterval(time.Minute); interval != test.interval {
+			t.Errorf("%s: got interval %v, want %v", test.name, interval, test.interval)
+		}
+		if events := eventsEnabled(); events != test.events {
+			t.Errorf("%s: got events enabled %v, want %v", test.name, events, test.events)
+		}
+		if filter := eventsFilterArgs(); !reflect.DeepEqual(filter, test.filter) {
+			t.Errorf("%s: got events filter %q, want %q", test.name, filter, test.filter)
+		}
+		// Sources are set up with the extra_args at start.
+		if extraArgs := liveConfig.Load().ExtraArgs; !reflect.DeepEqual(extraArgs, map[string][]string{"all": {"nolog"}}) {
+			t.Errorf("%s: extra_args changed to %v", test.name, extraArgs)
+		}
+	}
+
+	// Without --interval there's no loop the config could change.
+	if interval := collectionInterval(0); interval != 0 {
+		t.Errorf("got interval %v without --interval", interval)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+func init() {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+
+	RegisterSubcommand("diff", &Subcommand{
+		Flags: flags,
+		Run: func(Source) error {
+			if flags.NArg() != 2 {
+				return errors.New("diff needs two snapshots, e.g. diff before.tar.gz after.tar.gz")
+			}
+			before, err := readSnapshotModel(flags.Arg(0))
+			if err != nil {
+				return err
+			}
+			after, err := readSnapshotModel(flags.Arg(1))
+			if err != nil {
+				return err
+			}
+			changes := diffSystems(before, after)
+			if len(changes) == 0 {
+				fmt.Println("No changes")
+			}
+			for _, change := range changes {
+				fmt.Println(change)
+			}
+			return nil
+		},
+		NoSource: true,
+	})
+}
+
+// readSnapshotModel returns the model of an archive written by snapshot,
+// or of a model.json taken out of one.
+func readSnapshotModel(name string) (*System, error) {
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		archive := tar.NewReader(gz)
+		data = nil
+		for {
+			header, err := archive.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if path.Base(header.Name) == "model.json" {
+				if data, err = io.ReadAll(archive); err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+				break
+			}
+		}
+		if data == nil {
+			return nil, fmt.Errorf("%s has no model.json, the collection of the snapshot failed", name)
+		}
+	}
+
+	system := &System{}
+	if err := json.Unmarshal(data, system); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return system, nil
+}
+
+// diffSystems lists what changed between two collections: objects that
+// came or went, changed states and firmware, replaced drives and error
+// counters that went up. Each line starts with the storcli address of
+// the object.
+func diffSystems(before *System, after *System) []string {
+
+	var changes []string
+	changed := func(object string, what string, old string, new string) {
+		if old != new {
+			changes = append(changes, fmt.Sprintf("%s: %s %s -> %s", object, what, quoteEmpty(old), quoteEmpty(new)))
+		}
+	}
+	counter := func(object string, what string, old float64, new float64) {
+		if old != new {
+			changes = append(changes, fmt.Sprintf("%s: %s %s -> %s (%+g)", object, what, formatCount(old), formatCount(new), new-old))
+		}
+	}
+
+	controllersBefore := map[string]*ControllerState{}
+	for _, controller := range before.Controllers {
+		controllersBefore[controllerObject(controller)] = controller
+	}
+	controllersAfter := map[string]*ControllerState{}
+	for _, controller := range after.Controllers {
+		controllersAfter[controllerObject(controller)] = controller
+	}
+
+	for _, object := range unionKeys(controllersBefore, controllersAfter) {
+		old, new := controllersBefore[object], controllersAfter[object]
+		switch {
+		case old == nil:
+			changes = append(changes, fmt.Sprintf("%s: added %s", object, new.Model))
+			continue
+		case new == nil:
+			changes = append(changes, fmt.Sprintf("%s: removed %s", object, old.Model))
+			continue
+		}
+		changed(object, "serial", old.Serial, new.Serial)
+		changed(object, "status", old.Status, new.Status)
+		changed(object, "firmware", old.FirmwareVersion, new.FirmwareVersion)
+		changed(object, "driver", old.DriverVersion, new.DriverVersion)
+		changed(object, "BBU status", strconv.Itoa(old.BBUStatus), strconv.Itoa(new.BBUStatus))
+
+		virtualDrivesBefore := map[string]VirtualDriveState{}
+		for _, virtualDrive := range old.VirtualDrives {
+			virtualDrivesBefore[virtualDriveObject(old, virtualDrive)] = virtualDrive
+		}
+		virtualDrivesAfter := map[string]VirtualDriveState{}
+		for _, virtualDrive := range new.VirtualDrives {
+			virtualDrivesAfter[virtualDriveObject(new, virtualDrive)] = virtualDrive
+		}
+		for _, object := range unionKeys(virtualDrivesBefore, virtualDrivesAfter) {
+			oldVD, inBefore := virtualDrivesBefore[object]
+			newVD, inAfter := virtualDrivesAfter[object]
+			switch {
+			case !inBefore:
+				changes = append(changes, fmt.Sprintf("%s: added %s %s", object, newVD.Type, newVD.Name))
+				continue
+			case !inAfter:
+				changes = append(changes, fmt.Sprintf("%s: removed %s %s", object, oldVD.Type, oldVD.Name))
+				continue
+			}
+			changed(object, "state", oldVD.State, newVD.State)
+			changed(object, "name", oldVD.Name, newVD.Name)
+			changed(object, "cache", oldVD.Cache, newVD.Cache)
+		}
+
+		drivesBefore := map[string]*PhysicalDriveState{}
+		for _, drive := range old.PhysicalDrives {
+			drivesBefore[physicalDriveObject(old, drive)] = drive
+		}
+		drivesAfter := map[string]*PhysicalDriveState{}
+		for _, drive := range new.PhysicalDrives {
+			drivesAfter[physicalDriveObject(new, drive)] = drive
+		}
+		for _, object := range unionKeys(drivesBefore, drivesAfter) {
+			oldDrive, newDrive := drivesBefore[object], drivesAfter[object]
+			switch {
+			case oldDrive == nil:
+				changes = append(changes, fmt.Sprintf("%s: added %s %s", object, newDrive.Model, newDrive.Serial))
+				continue
+			case newDrive == nil:
+				changes = append(changes, fmt.Sprintf("%s: removed %s %s", object, oldDrive.Model, oldDrive.Serial))
+				continue
+			}
+			changed(object, "state", oldDrive.State, newDrive.State)
+			changed(object, "drive group", oldDrive.DriveGroup, newDrive.DriveGroup)
+			if oldDrive.Serial != newDrive.Serial {
+				// Another drive in the slot, its counters start over.
+				changes = append(changes, fmt.Sprintf("%s: replaced %s %s with %s %s", object, oldDrive.Model, oldDrive.Serial, newDrive.Model, newDrive.Serial))
+				continue
+			}
+			changed(object, "firmware", oldDrive.Firmware, newDrive.Firmware)
+			changed(object, "SMART alert", strconv.FormatBool(oldDrive.SmartAlerted), strconv.FormatBool(newDrive.SmartAlerted))
+			counter(object, "media errors", oldDrive.MediaErrors, newDrive.MediaErrors)
+			counter(object, "other errors", oldDrive.OtherErrors, newDrive.OtherErrors)
+			counter(object, "predictive failures", oldDrive.PredictiveErrors, newDrive.PredictiveErrors)
+			counter(object, "shield counter", oldDrive.ShieldCounter, newDrive.ShieldCounter)
+		}
+	}
+
+	return changes
+}
+
+func quoteEmpty(s string) string {
+	if s == "" {
+		return `""`
+	}
+	return s
+}
+
+func formatCount(count float64) string {
+	return strconv.FormatFloat(count, 'f', -1, 64)
+}
+
+// unionKeys returns the keys of both maps, in storcli address order.
+func unionKeys[V any](a map[string]V, b map[string]V) []string {
+
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]V{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessObject(keys[i], keys[j])
+	})
+	return keys
+}
+
+// lessObject orders storcli addresses like /c0/e32/s10 with the numbers
+// compared as numbers, so s2 comes before s10.
+func lessObject(a string, b string) bool {
+	for a != "" && b != "" {
+		i, j := 0, 0
+		for i < len(a) && a[i] >= '0' && a[i] <= '9' {
+			i++
+		}
+		for j < len(b) && b[j] >= '0' && b[j] <= '9' {
+			j++
+		}
+		if i > 0 && j > 0 {
+			x, _ := strconv.Atoi(a[:i])
+			y, _ := strconv.Atoi(b[:j])
+			if x != y {
+				return x < y
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var dropToUser = flag.String("drop-to-user", "", "Switch to this user once storcli is found. A helper process keeps running storcli as root, everything else runs as the user.")
+
+// Kept for as long as the process runs, the helper exits once its stdin
+// is garbage collected and closed.
+var privilegedHelper *exec.Cmd
+
+// dropPrivileges starts a helper that keeps running storcli with the
+// current privileges and then switches to the user. The returned source
+// queries the helper.
+func dropPrivileges(name string) (Source, error) {
+
+	account, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, _ := strconv.Atoi(account.Uid)
+	gid, _ := strconv.Atoi(account.Gid)
+	group, err := user.LookupGroupId(account.Gid)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "storcli-collector")
+	if err != nil {
+		return nil, err
+	}
+	socket := filepath.Join(dir, "helper.sock")
+	if err := os.Chown(dir, -1, gid); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	// The helper gets the same flags, so storcli runs the same way.
+	args := append([]string{"helper", "-socket", socket, "-socket-group", group.Name, "-exit-with-parent"}, os.Args[1:]...)
+	helper := exec.Command(executable, args...)
+	helper.Stderr = os.Stderr
+	// The helper exits when this pipe closes, which happens however
+	// this process exits.
+	if _, err := helper.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err := helper.Start(); err != nil {
+		return nil, err
+	}
+	privilegedHelper = helper
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("helper didn't start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := setIDs(uid, gid); err != nil {
+		return nil, fmt.Errorf("switching to %s: %w", name, err)
+	}
+
+	return HelperSource{Socket: socket}, nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// setIDs switches every thread of the process to the user and group and
+// drops supplementary groups.
+func setIDs(uid int, gid int) error {
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(uid)
+}
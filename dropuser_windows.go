@@ -0,0 +1,7 @@
+package main
+
+import "errors"
+
+func setIDs(uid int, gid int) error {
+	return errors.New("--drop-to-user is not supported on Windows")
+}
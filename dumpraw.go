@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var dumpRawDir = flag.String("dump-raw-dir", "", "Save the raw output of every storcli command to a directory per collection under this one, named after its start time. For reproducing parsing bugs.")
+var dumpRawKeep = flag.Int("dump-raw-keep", 100, "Keep only the directories of this many collections under --dump-raw-dir, per host, deleting the oldest. 0 keeps all of them.")
+
+const dumpDirLayout = "20060102T150405.000Z"
+
+// RawDumpSource saves the output of every query of one collection as
+// it comes from the source, in files named like those of --spool-dir.
+type RawDumpSource struct {
+	Source Source
+	Dir    string
+
+	once sync.Once
+}
+
+// dumpRaw wraps the source of one collection for --dump-raw-dir, or
+// returns it unchanged without it.
+func dumpRaw(source Source, host string) Source {
+	if *dumpRawDir == "" {
+		return source
+	}
+	parent := filepath.Join(*dumpRawDir, host)
+	if *dumpRawKeep > 0 {
+		pruneDumps(parent, *dumpRawKeep-1)
+	}
+	dir := filepath.Join(parent, time.Now().UTC().Format(dumpDirLayout))
+	return &RawDumpSource{Source: source, Dir: dir}
+}
+
+// pruneDumps deletes the oldest collection directories under parent until
+// keep are left. Other files and directories are never touched.
+func pruneDumps(parent string, keep int) {
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+	var dumps []string
+	for _, entry := range entries {
+		if _, err := time.Parse(dumpDirLayout, entry.Name()); entry.IsDir() && err == nil {
+			dumps = append(dumps, entry.Name())
+		}
+	}
+	// The names sort by time.
+	sort.Strings(dumps)
+	for len(dumps) > keep {
+		if err := os.RemoveAll(filepath.Join(parent, dumps[0])); err != nil {
+			log.Printf("Could not delete old raw output: %v", err)
+			return
+		}
+		dumps = dumps[1:]
+	}
+}
+
+func (d *RawDumpSource) Query(args ...string) ([]byte, error) {
+
+	data, err := d.Source.Query(args...)
+	if file := d.create(args); file != nil {
+		if _, writeErr := file.Write(data); writeErr != nil {
+			log.Printf("Could not save raw output: %v", writeErr)
+		}
+		file.Close()
+	}
+	return data, err
+}
+
+// QueryStream saves the output while it is being read.
+func (d *RawDumpSource) QueryStream(args ...string) (io.ReadCloser, error) {
+
+	output, err := queryStream(d.Source, args...)
+	if err != nil {
+		return nil, err
+	}
+	file := d.create(args)
+	if file == nil {
+		return output, nil
+	}
+	return &dumpedOutput{ReadCloser: output, file: file}, nil
+}
+
+// create opens the file for the output of a query. Failing to save is
+// logged, once per collection, and doesn't fail the collection.
+func (d *RawDumpSource) create(args []string) *os.File {
+
+	err := os.MkdirAll(d.Dir, 0755)
+	if err == nil {
+		var file *os.File
+		if file, err = os.Create(filepath.Join(d.Dir, spoolFileName(args))); err == nil {
+			return file
+		}
+	}
+	d.once.Do(func() {
+		log.Printf("Could not save raw output: %v", err)
+	})
+	return nil
+}
+
+type dumpedOutput struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (o *dumpedOutput) Read(p []byte) (int, error) {
+	n, err := o.ReadCloser.Read(p)
+	o.file.Write(p[:n])
+	return n, err
+}
+
+// Close saves what the parser left unread, e.g. a trailing newline,
+// so the file holds the output exactly.
+func (o *dumpedOutput) Close() error {
+	io.Copy(o.file, o.ReadCloser)
+	o.file.Close()
+	return o.ReadCloser.Close()
+}
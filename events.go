@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var collectEvents = flag.Bool("collect-events", false, "Collect the controller event log. Adds one storcli call per controller.")
+var eventsFilter = flag.String("events-filter", "type=sincereboot", "Which events to read, e.g. type=sincereboot or type=latest=100.")
+
+// Event is a single entry of the controller event log.
+type Event struct {
+	Sequence int64     `json:"sequence"`
+	Time     time.Time `json:"time"`
+	HasTime  bool      `json:"has_time"`
+	// Time shifted by the controller clock skew, so it lines up with
+	// the host's logs. Equal to Time when the skew is unknown.
+	CorrectedTime time.Time `json:"corrected_time"`
+	Class         int       `json:"class"`
+	Code          string    `json:"code"`
+	Description   string    `json:"description"`
+}
+
+// Event classes as defined by the MegaRAID firmware.
+var eventSeverities = map[int]string{
+	-2: "debug",
+	-1: "progress",
+	0:  "info",
+	1:  "warning",
+	2:  "critical",
+	3:  "fatal",
+	4:  "dead",
+}
+
+func (e Event) Severity() string {
+	if severity, ok := eventSeverities[e.Class]; ok {
+		return severity
+	}
+	return "unknown"
+}
+
+// correctEventTimes shifts the event times by the skew measured between
+// the controller and system clocks.
+func correctEventTimes(controller *ControllerState) {
+	for i := range controller.Events {
+		event := &controller.Events[i]
+		event.CorrectedTime = event.Time
+		if event.HasTime && controller.HasTimeDifference {
+			event.CorrectedTime = event.Time.Add(time.Duration(controller.TimeDifference) * time.Second)
+		}
+	}
+}
+
+func queryEvents(source Source, controllerIndex int) ([]byte, error) {
+	args := []string{"/c" + strconv.Itoa(controllerIndex), "show", "events"}
+	args = append(args, eventsFilterArgs()...)
+	return source.Query(args...)
+}
+
+// parseEvents reads the plain text event log. The JSON variant of this
+// command only wraps the same text lines, so there's nothing to gain
+// from asking for it.
+func parseEvents(data []byte) []Event {
+
+	var events []Event
+	var current *Event
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if key == "seqNum" {
+			events = append(events, Event{Class: -3})
+			current = &events[len(events)-1]
+			current.Sequence, _ = strconv.ParseInt(value, 0, 64)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch key {
+		case "Time":
+			eventTime, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", value, storcliLocation)
+			if err == nil {
+				current.Time = eventTime
+				current.HasTime = true
+			}
+		case "Code":
+			current.Code = value
+		case "Class":
+			current.Class, _ = strconv.Atoi(value)
+		case "Event Description":
+			current.Description = value
+		}
+	}
+
+	return events
+}
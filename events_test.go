@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const testEventLog = `Controller = 0
+Status = Success
+Description = None
+
+
+seqNum: 0x00001a2b
+Seconds since power on: 12
+Code: 0x0000001e
+Class: 0
+Locale: 0x20
+Event Description: Event log cleared
+Event Data:
+===========
+None
+
+
+seqNum: 0x00001a2c
+Time: Fri Oct 16 03:04:05 2026
+
+Code: 0x00000072
+Class: 1
+Locale: 0x02
+Event Description: State change on PD 01(e0x20/s1) from ONLINE(18) to FAILED(11)
+Event Data:
+===========
+Device ID: 1
+
+
+seqNum: 42
+Time: Fri Oct  2 23:00:00 2026
+Code: 0x0000010e
+Class: 3
+Event Description: Controller encountered a fatal error and was reset
+`
+
+func TestParseEvents(t *testing.T) {
+
+	defer func(location *time.Location) { storcliLocation = location }(storcliLocation)
+	storcliLocation = time.UTC
+
+	want := []Event{
+		{Sequence: 0x1a2b, Class: 0, Code: "0x0000001e", Description: "Event log cleared"},
+		{Sequence: 0x1a2c, Class: 1, Code: "0x00000072", HasTime: true, Time: time.Date(2026, 10, 16, 3, 4, 5, 0, time.UTC),
+			Description: "State change on PD 01(e0x20/s1) from ONLINE(18) to FAILED(11)"},
+		{Sequence: 42, Class: 3, Code: "0x0000010e", HasTime: true, Time: time.Date(2026, 10, 2, 23, 0, 0, 0, time.UTC),
+			Description: "Controller encountered a fatal error and was reset"},
+	}
+
+	events := parseEvents([]byte(testEventLog))
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, event := range events {
+		if event != want[i] {
+			t.Errorf("event %d: got %+v, want %+v", i, event, want[i])
+		}
+	}
+
+	if events := parseEvents([]byte("Controller = 0\nStatus = Success\n")); len(events) != 0 {
+		t.Errorf("got %d events from an empty log", len(events))
+	}
+}
+
+func TestEventSeverity(t *testing.T) {
+
+	tests := []struct {
+		class    int
+		severity string
+	}{
+		{-2, "debug"},
+		{-1, "progress"},
+		{0, "info"},
+		{1, "warning"},
+		{2, "critical"},
+		{3, "fatal"},
+		{4, "dead"},
+		{-3, "unknown"},
+		{5, "unknown"},
+	}
+
+	for _, test := range tests {
+		if severity := (Event{Class: test.class}).Severity(); severity != test.severity {
+			t.Errorf("class %d: got %q, want %q", test.class, severity, test.severity)
+		}
+	}
+}
+
+func TestHandleEventsCounts(t *testing.T) {
+
+	events := []Event{{Sequence: 1, Class: 0}, {Sequence: 2, Class: 1}, {Sequence: 3, Class: 1}}
+	tests := []struct {
+		name        string
+		eventCounts map[string]float64
+		want        map[string]float64
+	}{
+		// Without a state file the log itself is counted.
+		{"log", nil, map[string]float64{"info": 1, "warning": 2, "critical": 0, "dead": 0}},
+		{"tracked", map[string]float64{"info": 10, "fatal": 1}, map[string]float64{"info": 10, "warning": 0, "fatal": 1}},
+	}
+
+	for _, test := range tests {
+		Counters["ctrl_events"].Reset()
+		handleEvents(events, test.eventCounts, "0")
+		for severity, count := range test.want {
+			var metric dto.Metric
+			Counters["ctrl_events"].With(prometheus.Labels{"controller": "0", "severity": severity}).Write(&metric)
+			if value := metric.GetCounter().GetValue(); value != count {
+				t.Errorf("%s: %s events = %v, want %v", test.name, severity, value, count)
+			}
+		}
+	}
+	Counters["ctrl_events"].Reset()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var update = flag.Bool("update", false, "Write the metrics of every fixture to its metrics.prom instead of comparing them.")
+
+// A fixture is the saved output of a controller and the metrics the
+// collector is expected to export from it in metrics.prom. Fixtures of
+// new hardware keep later changes to the parser from breaking it
+// unnoticed. Run go test -run TestFixtures -update after intended
+// changes to the metrics.
+func TestFixtures(t *testing.T) {
+
+	dir := filepath.Join("testdata", "fixtures")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dates are parsed in the host's time zone, which mustn't change
+	// the result.
+	defer func(location *time.Location) { storcliLocation = location }(storcliLocation)
+	storcliLocation = time.UTC
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fixture := filepath.Join(dir, entry.Name())
+		t.Run(entry.Name(), func(t *testing.T) {
+			output, err := renderFixture(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join(fixture, "metrics.prom")
+			if *update {
+				if err := os.WriteFile(golden, output, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expected, output) {
+				t.Errorf("metrics differ from %s, run go test -run TestFixtures -update if the changes are intended:\n%s", golden, lineDiff(expected, output))
+			}
+		})
+	}
+}
+
+// fixtureSource returns the source that replays a fixture. It holds
+// either the output of the /call commands, as for --from-file, or a
+// storcli or megacli directory of raw output as --dump-raw-dir saves it,
+// for output --from-file can't answer from.
+func fixtureSource(fixture string) (Source, string, error) {
+
+	for _, name := range []string{"storcli", "megacli"} {
+		raw := filepath.Join(fixture, name)
+		if info, err := os.Stat(raw); err == nil && info.IsDir() {
+			return SpoolSource{Dir: raw}, name, nil
+		}
+	}
+
+	drives := filepath.Join(fixture, "drives.json")
+	if _, err := os.Stat(drives); errors.Is(err, os.ErrNotExist) {
+		drives = ""
+	}
+	source, err := NewFileSource(filepath.Join(fixture, "controllers.json"), drives)
+	return source, "storcli", err
+}
+
+// renderFixture runs a fixture through the collection and returns its
+// metrics, without those that depend on the build.
+func renderFixture(fixture string) ([]byte, error) {
+
+	source, backendName, err := fixtureSource(fixture)
+	if err != nil {
+		return nil, err
+	}
+	defer func(name string) { *backend = name }(*backend)
+	*backend = backendName
+
+	system, err := collect(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(system.FailedControllers) > 0 {
+		return nil, fmt.Errorf("controllers %v didn't parse", system.FailedControllers)
+	}
+
+	families, err := gatherMetrics(system)
+	if err != nil {
+		return nil, err
+	}
+	var kept []*dto.MetricFamily
+	for _, family := range families {
+		if family.GetName() != "megaraid_exporter_build_info" {
+			kept = append(kept, family)
+		}
+	}
+	return printMetrics(kept)
+}
+
+// lineDiff lists the lines only one side has, the metrics being sorted
+// the same way on both.
+func lineDiff(expected []byte, actual []byte) string {
+
+	inExpected := map[string]bool{}
+	for _, line := range strings.Split(string(expected), "\n") {
+		inExpected[line] = true
+	}
+	inActual := map[string]bool{}
+	for _, line := range strings.Split(string(actual), "\n") {
+		inActual[line] = true
+	}
+
+	var diff strings.Builder
+	for _, line := range strings.Split(string(expected), "\n") {
+		if !inActual[line] {
+			fmt.Fprintf(&diff, "  - %s\n", line)
+		}
+	}
+	for _, line := range strings.Split(string(actual), "\n") {
+		if !inExpected[line] {
+			fmt.Fprintf(&diff, "  + %s\n", line)
+		}
+	}
+	return diff.String()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Saved output from a user's bug report is parsed exactly like storcli's,
+// without a controller or the binary on the machine reading it.
+var fromFile = flag.String("from-file", "", "Parse the saved output of 'storcli64 /call show all J' from this file instead of running storcli. For debugging output that doesn't parse.")
+var drivesFile = flag.String("drives-file", "", "With --from-file, the saved output of 'storcli64 /call/eall/sall show all J'.")
+
+// FileSource answers the queries of a collection from the output of the
+// /call commands, which hold what the per controller queries return.
+type FileSource struct {
+	// The entries of the Controllers list of /call show all J.
+	Controllers []json.RawMessage
+	Drives      []byte
+}
+
+func NewFileSource(controllersPath string, drivesPath string) (*FileSource, error) {
+
+	controllers, err := os.ReadFile(controllersPath)
+	if err != nil {
+		return nil, err
+	}
+	var drives []byte
+	if drivesPath != "" {
+		if drives, err = os.ReadFile(drivesPath); err != nil {
+			return nil, err
+		}
+	}
+	source, err := parseFileSource(controllers, drives)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", controllersPath, err)
+	}
+	return source, nil
+}
+
+// parseFileSource splits the output of /call show all J into its
+// controllers. drives may be nil.
+func parseFileSource(controllers []byte, drives []byte) (*FileSource, error) {
+
+	data, err := normalizeJSON(controllers)
+	if err != nil {
+		return nil, err
+	}
+	var output struct {
+		Controllers []json.RawMessage `json:"Controllers"`
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, err
+	}
+	if len(output.Controllers) == 0 {
+		return nil, errors.New("no controllers, this should be the output of /call show all J")
+	}
+	return &FileSource{Controllers: output.Controllers, Drives: drives}, nil
+}
+
+func (f *FileSource) Query(args ...string) ([]byte, error) {
+
+	command := strings.Join(args, " ")
+	switch {
+	case command == "show ctrlcount J":
+		return f.controllerCount()
+	case len(args) == 4 && args[1] == "show" && args[2] == "all" && args[3] == "J":
+		index, err := strconv.Atoi(strings.TrimPrefix(args[0], "/c"))
+		if err == nil && index >= 0 && index < len(f.Controllers) {
+			return json.Marshal(map[string]interface{}{"Controllers": f.Controllers[index : index+1]})
+		}
+		if strings.HasSuffix(args[0], "/eALL/sALL") {
+			if f.Drives == nil {
+				return nil, errors.New("the drive details are missing, they are the output of /call/eall/sall show all J")
+			}
+			return f.Drives, nil
+		}
+	}
+	return nil, fmt.Errorf("%s isn't in the saved output", command)
+}
+
+// controllerCount answers show ctrlcount with the status of the first
+// controller, so the CLI version of the saved output is reported.
+func (f *FileSource) controllerCount() ([]byte, error) {
+
+	var first struct {
+		CommandStatus json.RawMessage `json:"Command Status"`
+	}
+	if err := json.Unmarshal(f.Controllers[0], &first); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"Controllers": []interface{}{map[string]interface{}{
+			"Command Status": first.CommandStatus,
+			"Response Data":  map[string]int{"Controller Count": len(f.Controllers)},
+		}},
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// The health rules decide how bad a state of the model is. Every output
+// that judges health uses evaluateHealth, so the severities only have to
+// be changed in one place: the health_rules section of --config.
+
+// Severity of a finding. Rules set to "off" never report anything.
+type Severity int
+
+const (
+	SeverityOK Severity = iota
+	SeverityWarn
+	SeverityCrit
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarn:
+		return "WARN"
+	case SeverityCrit:
+		return "CRIT"
+	}
+	return "OK"
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func parseSeverity(name string) (Severity, error) {
+	switch name {
+	case "off":
+		return SeverityOK, nil
+	case "warn":
+		return SeverityWarn, nil
+	case "crit":
+		return SeverityCrit, nil
+	}
+	return SeverityOK, fmt.Errorf("severity must be off, warn or crit, not %q", name)
+}
+
+// HealthRule configures one of the checks in healthChecks.
+type HealthRule struct {
+	Severity string `json:"severity"`
+	// Only used by rules that compare a value.
+	Threshold float64 `json:"threshold,omitempty"`
+	Help      string  `json:"help,omitempty"`
+}
+
+// HealthRuleOverride is a rule in the health_rules section of --config.
+// Fields left out keep the default, so a threshold of 0 can be told
+// apart from none.
+type HealthRuleOverride struct {
+	Severity  string   `json:"severity,omitempty"`
+	Threshold *float64 `json:"threshold,omitempty"`
+}
+
+// Finding is a rule that matched an object, e.g. a failed drive.
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	// Host, if any, and the storcli address of the object, e.g.
+	// /c0/e252/s4.
+	Host    string `json:"host,omitempty"`
+	Object  string `json:"object"`
+	Message string `json:"message"`
+}
+
+// violation is what a check found, before the rule's severity is known.
+type violation struct {
+	Object  string
+	Message string
+}
+
+//go:embed health_rules.json
+var defaultHealthRulesJSON []byte
+
+var healthRules = mustParseHealthRules(defaultHealthRulesJSON)
+
+func mustParseHealthRules(data []byte) map[string]HealthRule {
+
+	rules := map[string]HealthRule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		panic(err)
+	}
+	for name, rule := range rules {
+		if _, found := healthChecks[name]; !found {
+			panic("no check for health rule " + name)
+		}
+		if _, err := parseSeverity(rule.Severity); err != nil {
+			panic(name + ": " + err.Error())
+		}
+	}
+	return rules
+}
+
+// mergeHealthRules applies the overrides from the config file to the
+// default rules.
+func mergeHealthRules(rules map[string]HealthRule, overrides map[string]HealthRuleOverride) (map[string]HealthRule, error) {
+
+	merged := map[string]HealthRule{}
+	for name, rule := range rules {
+		merged[name] = rule
+	}
+	for name, override := range overrides {
+		rule, found := merged[name]
+		if !found {
+			return nil, fmt.Errorf("health_rules: unknown rule %q", name)
+		}
+		if override.Severity != "" {
+			if _, err := parseSeverity(override.Severity); err != nil {
+				return nil, fmt.Errorf("health_rules: %s: %w", name, err)
+			}
+			rule.Severity = override.Severity
+		}
+		if override.Threshold != nil {
+			rule.Threshold = *override.Threshold
+		}
+		merged[name] = rule
+	}
+
+	return merged, nil
+}
+
+// evaluateHealth runs every enabled rule against the model, worst
+// findings first.
+func evaluateHealth(system *System) []Finding {
+
+	var findings []Finding
+	for name, rule := range healthRules {
+		severity, _ := parseSeverity(rule.Severity)
+		if severity == SeverityOK {
+			continue
+		}
+		for _, found := range healthChecks[name](system, rule) {
+			findings = append(findings, Finding{
+				Rule:     name,
+				Severity: severity,
+				Host:     system.Host,
+				Object:   found.Object,
+				Message:  found.Message,
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return findings[i].Severity > findings[j].Severity
+		}
+		if findings[i].Object != findings[j].Object {
+			return findings[i].Object < findings[j].Object
+		}
+		return findings[i].Rule < findings[j].Rule
+	})
+
+	return findings
+}
+
+// worstSeverity is the severity of the host as a whole.
+func worstSeverity(findings []Finding) Severity {
+	worst := SeverityOK
+	for _, finding := range findings {
+		if finding.Severity > worst {
+			worst = finding.Severity
+		}
+	}
+	return worst
+}
+
+func controllerObject(controller *ControllerState) string {
+	return "/c" + strconv.Itoa(controller.Index)
+}
+
+func virtualDriveObject(controller *ControllerState, virtualDrive VirtualDriveState) string {
+	return controllerObject(controller) + "/v" + virtualDrive.VolumeGroup
+}
+
+// Drives attached to the controller directly have no enclosure, storcli
+// addresses them as /cN/sS.
+func physicalDriveObject(controller *ControllerState, drive *PhysicalDriveState) string {
+	if drive.Enclosure == "" {
+		return controllerObject(controller) + "/s" + drive.Slot
+	}
+	return controllerObject(controller) + "/e" + drive.Enclosure + "/s" + drive.Slot
+}
+
+// physicalDriveObjectOfLabels is physicalDriveObject for the labels of a
+// drive's metric, for outputs that only see the metrics.
+func physicalDriveObjectOfLabels(labels map[string]string) string {
+	index, _ := strconv.Atoi(labels["controller"])
+	return physicalDriveObject(&ControllerState{Index: index}, &PhysicalDriveState{Enclosure: labels["enclosure"], Slot: labels["slot"]})
+}
+
+func checkControllers(test func(*ControllerState) (string, bool)) func(*System, HealthRule) []violation {
+	return func(system *System, rule HealthRule) []violation {
+		var found []violation
+		for _, controller := range system.Controllers {
+			if message, bad := test(controller); bad {
+				found = append(found, violation{controllerObject(controller), message})
+			}
+		}
+		return found
+	}
+}
+
+func checkVirtualDrives(test func(VirtualDriveState) bool) func(*System, HealthRule) []violation {
+	return func(system *System, rule HealthRule) []violation {
+		var found []violation
+		for _, controller := range system.Controllers {
+			for _, virtualDrive := range controller.VirtualDrives {
+				if test(virtualDrive) {
+					found = append(found, violation{
+						virtualDriveObject(controller, virtualDrive),
+						fmt.Sprintf("virtual drive %s is %s", virtualDrive.Name, virtualDrive.State),
+					})
+				}
+			}
+		}
+		return found
+	}
+}
+
+func checkPhysicalDrives(test func(*PhysicalDriveState, HealthRule) (string, bool)) func(*System, HealthRule) []violation {
+	return func(system *System, rule HealthRule) []violation {
+		var found []violation
+		for _, controller := range system.Controllers {
+			for _, drive := range controller.PhysicalDrives {
+				if message, bad := test(drive, rule); bad {
+					found = append(found, violation{physicalDriveObject(controller, drive), message})
+				}
+			}
+		}
+		return found
+	}
+}
+
+// healthChecks implements the rules in health_rules.json.
+var healthChecks = map[string]func(*System, HealthRule) []violation{
+	"collection_failed": func(system *System, rule HealthRule) []violation {
+		var found []violation
+		for _, index := range system.FailedControllers {
+			found = append(found, violation{"/c" + strconv.Itoa(index), "controller output couldn't be read"})
+		}
+		return found
+	},
+	"ctrl_not_healthy": checkControllers(func(controller *ControllerState) (string, bool) {
+		return "controller is " + controller.Status, !controller.Healthy()
+	}),
+	"ctrl_bbu_unhealthy": checkControllers(func(controller *ControllerState) (string, bool) {
+		return fmt.Sprintf("BBU status is %d", controller.BBUStatus),
+			controller.IsMegaraid() && controller.BBUStatus != -1 && !controller.BBUHealthy()
+	}),
+	"ctrl_temperature": func(system *System, rule HealthRule) []violation {
+		return checkControllers(func(controller *ControllerState) (string, bool) {
+			return fmt.Sprintf("controller is at %g°C", controller.Temperature), controller.Temperature >= rule.Threshold
+		})(system, rule)
+	},
+	"ctrl_unapproved_combination": checkControllers(func(controller *ControllerState) (string, bool) {
+		return fmt.Sprintf("firmware %s with driver %s is not approved", controller.FirmwareVersion, controller.DriverVersion),
+			len(config.ApprovedCombinations) > 0 && !isApprovedCombination(controller, config.ApprovedCombinations)
+	}),
+	"vd_degraded": checkVirtualDrives(VirtualDriveState.Degraded),
+	"vd_offline":  checkVirtualDrives(VirtualDriveState.Offline),
+	"vd_not_optimal": checkVirtualDrives(func(virtualDrive VirtualDriveState) bool {
+		return !virtualDrive.Optimal() && !virtualDrive.Degraded() && !virtualDrive.Offline()
+	}),
+	"pd_failed": checkPhysicalDrives(func(drive *PhysicalDriveState, rule HealthRule) (string, bool) {
+		return "drive is " + drive.State, drive.Failed()
+	}),
+	"pd_smart_alert": checkPhysicalDrives(func(drive *PhysicalDriveState, rule HealthRule) (string, bool) {
+		return "drive reports a SMART alert", drive.SmartAlerted
+	}),
+	"pd_predictive_errors": checkPhysicalDrives(func(drive *PhysicalDriveState, rule HealthRule) (string, bool) {
+		return fmt.Sprintf("%g predictive failures", drive.PredictiveErrors), drive.PredictiveErrors >= rule.Threshold
+	}),
+	"pd_media_errors": checkPhysicalDrives(func(drive *PhysicalDriveState, rule HealthRule) (string, bool) {
+		return fmt.Sprintf("%g media errors", drive.MediaErrors), drive.MediaErrors >= rule.Threshold
+	}),
+	"pd_foreign_locked": checkPhysicalDrives(func(drive *PhysicalDriveState, rule HealthRule) (string, bool) {
+		return "foreign drive is locked", drive.ForeignLocked()
+	}),
+}
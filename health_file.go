@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"sort"
+	"strings"
+	"time"
+)
+
+var healthFile = flag.String("health-file", "", "Also write the overall health verdict and its reasons as JSON to this file after every collection, e.g. /run/storcli-health.json, for local automation.")
+
+// HealthDocument is the verdict for schedulers and provisioning checks
+// that want to know whether the RAID is fine without parsing metrics.
+type HealthDocument struct {
+	Generated time.Time `json:"generated"`
+	// ok, degraded with warnings or failed with critical findings.
+	Verdict string         `json:"verdict"`
+	Reasons []HealthReason `json:"reasons"`
+}
+
+type HealthReason struct {
+	Host     string `json:"host,omitempty"`
+	Object   string `json:"object,omitempty"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// The findings of the last collection of every host, so one host being
+// collected doesn't drop the verdict of the others.
+var healthFindings = map[string][]Finding{}
+
+// recordHealth remembers the findings of a collection for the health
+// file.
+func recordHealth(host string, findings []Finding) {
+	healthFindings[host] = findings
+}
+
+// recordHealthFailure counts a collection that failed altogether as a
+// finding of the collection_failed rule, so a stale verdict can't
+// outlive the controller it was about.
+func recordHealthFailure(host string, err error) {
+	severity, _ := parseSeverity(healthRules["collection_failed"].Severity)
+	if severity == SeverityOK {
+		severity = SeverityCrit
+	}
+	healthFindings[host] = []Finding{{
+		Rule:     "collection_failed",
+		Severity: severity,
+		Host:     host,
+		Message:  err.Error(),
+	}}
+}
+
+func buildHealthDocument(now time.Time) HealthDocument {
+
+	var findings []Finding
+	for _, host := range sortedKeys(healthFindings) {
+		findings = append(findings, healthFindings[host]...)
+	}
+
+	document := HealthDocument{Generated: now.UTC(), Reasons: []HealthReason{}}
+	switch worstSeverity(findings) {
+	case SeverityOK:
+		document.Verdict = "ok"
+	case SeverityWarn:
+		document.Verdict = "degraded"
+	default:
+		document.Verdict = "failed"
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity > findings[j].Severity
+	})
+	for _, finding := range findings {
+		document.Reasons = append(document.Reasons, HealthReason{
+			Host:     finding.Host,
+			Object:   finding.Object,
+			Rule:     finding.Rule,
+			Severity: strings.ToLower(finding.Severity.String()),
+			Message:  finding.Message,
+		})
+	}
+	return document
+}
+
+func writeHealthFile(path string) error {
+	data, err := json.MarshalIndent(buildHealthDocument(time.Now()), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
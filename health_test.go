@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func threshold(value float64) *float64 {
+	return &value
+}
+
+func TestMergeHealthRules(t *testing.T) {
+
+	rules := map[string]HealthRule{
+		"ctrl_temperature": {Severity: "warn", Threshold: 95, Help: "temperature"},
+		"pd_failed":        {Severity: "crit", Help: "failed"},
+	}
+
+	tests := []struct {
+		name      string
+		overrides map[string]HealthRuleOverride
+		want      map[string]HealthRule
+		err       bool
+	}{
+		{"none", nil, rules, false},
+		{"severity", map[string]HealthRuleOverride{"pd_failed": {Severity: "warn"}}, map[string]HealthRule{
+			"ctrl_temperature": {Severity: "warn", Threshold: 95, Help: "temperature"},
+			"pd_failed":        {Severity: "warn", Help: "failed"},
+		}, false},
+		{"threshold keeps severity", map[string]HealthRuleOverride{"ctrl_temperature": {Threshold: threshold(80)}}, map[string]HealthRule{
+			"ctrl_temperature": {Severity: "warn", Threshold: 80, Help: "temperature"},
+			"pd_failed":        {Severity: "crit", Help: "failed"},
+		}, false},
+		{"zero threshold", map[string]HealthRuleOverride{"ctrl_temperature": {Threshold: threshold(0)}}, map[string]HealthRule{
+			"ctrl_temperature": {Severity: "warn", Threshold: 0, Help: "temperature"},
+			"pd_failed":        {Severity: "crit", Help: "failed"},
+		}, false},
+		{"off", map[string]HealthRuleOverride{"ctrl_temperature": {Severity: "off"}}, map[string]HealthRule{
+			"ctrl_temperature": {Severity: "off", Threshold: 95, Help: "temperature"},
+			"pd_failed":        {Severity: "crit", Help: "failed"},
+		}, false},
+		{"unknown rule", map[string]HealthRuleOverride{"pd_missing": {Severity: "warn"}}, nil, true},
+		{"bad severity", map[string]HealthRuleOverride{"pd_failed": {Severity: "critical"}}, nil, true},
+	}
+
+	for _, test := range tests {
+		merged, err := mergeHealthRules(rules, test.overrides)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(merged, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, merged, test.want)
+		}
+	}
+
+	if rules["pd_failed"].Severity != "crit" {
+		t.Error("the default rules were changed")
+	}
+
+	// A threshold of 0 in the config file is one, not a missing one.
+	var overrides map[string]HealthRuleOverride
+	if err := json.Unmarshal([]byte(`{"ctrl_temperature": {"threshold": 0}}`), &overrides); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := mergeHealthRules(rules, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold := merged["ctrl_temperature"].Threshold; threshold != 0 {
+		t.Errorf("got threshold %v from the config file, want 0", threshold)
+	}
+}
+
+func TestEvaluateHealth(t *testing.T) {
+
+	defer func(rules map[string]HealthRule) { healthRules = rules }(healthRules)
+
+	system := &System{
+		Host:              "db1",
+		FailedControllers: []int{1},
+		Controllers: []*ControllerState{{
+			Index:       0,
+			Status:      "Degraded",
+			DriverName:  "megaraid_sas",
+			BBUStatus:   0,
+			Temperature: 70,
+			VirtualDrives: []VirtualDriveState{
+				{VolumeGroup: "0", Name: "os", State: "Dgrd"},
+				{VolumeGroup: "1", Name: "data", State: "Optl"},
+			},
+			PhysicalDrives: []*PhysicalDriveState{
+				{Enclosure: "32", Slot: "0", State: "Onln", PredictiveErrors: 2},
+				{Enclosure: "32", Slot: "1", State: "Failed"},
+				{Slot: "2", State: "UGood", MediaErrors: 5},
+			},
+		}},
+	}
+
+	tests := []struct {
+		name      string
+		overrides map[string]HealthRuleOverride
+		want      []Finding
+	}{
+		{"defaults", nil, []Finding{
+			{"ctrl_not_healthy", SeverityCrit, "db1", "/c0", "controller is Degraded"},
+			{"pd_failed", SeverityCrit, "db1", "/c0/e32/s1", "drive is Failed"},
+			{"vd_degraded", SeverityCrit, "db1", "/c0/v0", "virtual drive os is Dgrd"},
+			{"collection_failed", SeverityCrit, "db1", "/c1", "controller output couldn't be read"},
+			{"pd_predictive_errors", SeverityWarn, "db1", "/c0/e32/s0", "2 predictive failures"},
+		}},
+		{"overrides", map[string]HealthRuleOverride{
+			"collection_failed":    {Severity: "off"},
+			"ctrl_not_healthy":     {Severity: "warn"},
+			"ctrl_temperature":     {Threshold: threshold(70)},
+			"pd_predictive_errors": {Threshold: threshold(3)},
+			"pd_media_errors":      {Severity: "crit"},
+		}, []Finding{
+			{"pd_failed", SeverityCrit, "db1", "/c0/e32/s1", "drive is Failed"},
+			{"pd_media_errors", SeverityCrit, "db1", "/c0/s2", "5 media errors"},
+			{"vd_degraded", SeverityCrit, "db1", "/c0/v0", "virtual drive os is Dgrd"},
+			{"ctrl_not_healthy", SeverityWarn, "db1", "/c0", "controller is Degraded"},
+			{"ctrl_temperature", SeverityWarn, "db1", "/c0", "controller is at 70°C"},
+		}},
+	}
+
+	for _, test := range tests {
+		merged, err := mergeHealthRules(mustParseHealthRules(defaultHealthRulesJSON), test.overrides)
+		if err != nil {
+			t.Fatal(err)
+		}
+		healthRules = merged
+		findings := evaluateHealth(system)
+		if !reflect.DeepEqual(findings, test.want) {
+			t.Errorf("%s: got\n%v\nwant\n%v", test.name, findings, test.want)
+		}
+		if worst := worstSeverity(findings); worst != SeverityCrit {
+			t.Errorf("%s: got worst severity %v, want CRIT", test.name, worst)
+		}
+	}
+
+	if worst := worstSeverity(nil); worst != SeverityOK {
+		t.Errorf("no findings: got %v, want OK", worst)
+	}
+}
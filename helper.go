@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// The helper is the only part that needs root. It runs storcli for the
+// exporter, which talks to it over a Unix socket and runs as any user
+// allowed to connect. Only read-only show commands are passed on.
+
+var helperSocket = flag.String("helper-socket", "", "Query storcli through the helper listening on this Unix socket instead of running it, see the helper subcommand.")
+
+func init() {
+	flags := flag.NewFlagSet("helper", flag.ExitOnError)
+	socket := flags.String("socket", "/run/storcli-collector/helper.sock", "Unix socket to listen on.")
+	group := flags.String("socket-group", "", "(Optional) Group that may connect to the socket. Defaults to the helper's own.")
+	exitWithParent := flags.Bool("exit-with-parent", false, "Exit when stdin is closed, see --drop-to-user.")
+
+	RegisterSubcommand("helper", &Subcommand{
+		Flags: flags,
+		Run: func(source Source) error {
+			if *exitWithParent {
+				go func() {
+					io.Copy(io.Discard, os.Stdin)
+					os.Remove(*socket)
+					// The directory --drop-to-user made for it.
+					os.Remove(filepath.Dir(*socket))
+					os.Exit(0)
+				}()
+			}
+			return serveHelper(source, *socket, *group)
+		},
+	})
+}
+
+type helperRequest struct {
+	Args []string `json:"args"`
+}
+
+type helperResponse struct {
+	Output []byte `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Requests are a handful of words, anything bigger isn't one.
+const maxHelperRequest = 64 * 1024
+
+// The commands the collector runs, with /cN standing for any controller.
+// Anything else is refused, show commands included: some take options
+// like logfile= that write wherever they are told to.
+var helperCommands = [][]string{
+	{"show", "ctrlcount", "J"},
+	{"show", "ctrlcount"},
+	{"/cN", "show", "all", "J"},
+	{"/cN", "show", "all"},
+	{"/cN/eALL/sALL", "show", "all", "J"},
+	{"/cN/pALL", "show", "phyerrorcounters", "J"},
+	{"/cN", "show", "securitykey", "J"},
+	{"/cN", "show", "termlog", "type=contents"},
+}
+
+var helperControllerPattern = regexp.MustCompile(`^/c\d+`)
+
+// What --events-filter may pass to /cN show events.
+var helperEventsFilterPattern = regexp.MustCompile(`^(type=(sincereboot|sinceshutdown|includedeleted|latest=\d+)|filter=(info|warning|critical|fatal))$`)
+
+// allowedHelperCommand reports whether args are one of the commands the
+// collector runs.
+func allowedHelperCommand(args []string) bool {
+
+	if len(args) == 0 {
+		return false
+	}
+	args = append([]string{helperControllerPattern.ReplaceAllString(args[0], "/cN")}, args[1:]...)
+
+	for _, command := range helperCommands {
+		if reflect.DeepEqual(args, command) {
+			return true
+		}
+	}
+
+	if len(args) < 3 || !reflect.DeepEqual(args[:3], []string{"/cN", "show", "events"}) {
+		return false
+	}
+	for _, filter := range args[3:] {
+		if !helperEventsFilterPattern.MatchString(filter) {
+			return false
+		}
+	}
+	return true
+}
+
+func serveHelper(source Source, socket string, group string) error {
+
+	// A socket left over from a previous run blocks the address.
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	if err := os.Chmod(socket, 0660); err != nil {
+		return err
+	}
+	if group != "" {
+		found, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gid, _ := strconv.Atoi(found.Gid)
+		if err := os.Chown(socket, -1, gid); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Helper listening on %s", socket)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handleHelperConn(source, conn)
+	}
+}
+
+func handleHelperConn(source Source, conn net.Conn) {
+
+	defer conn.Close()
+
+	var request helperRequest
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := json.NewDecoder(io.LimitReader(conn, maxHelperRequest)).Decode(&request); err != nil {
+		log.Printf("Helper: bad request: %v", err)
+		return
+	}
+
+	var response helperResponse
+	if allowedHelperCommand(request.Args) {
+		data, err := source.Query(request.Args...)
+		response.Output = data
+		if err != nil {
+			response.Error = err.Error()
+		}
+	} else {
+		log.Printf("Helper: refused %q", request.Args)
+		response.Error = "command not allowed"
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		log.Printf("Helper: %v", err)
+	}
+}
+
+// HelperSource queries storcli through the helper.
+type HelperSource struct {
+	Socket string
+}
+
+func (h HelperSource) Query(args ...string) ([]byte, error) {
+
+	conn, err := net.DialTimeout("unix", h.Socket, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(helperRequest{Args: args}); err != nil {
+		return nil, err
+	}
+	var response helperResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("helper: %w", err)
+	}
+	if response.Error != "" {
+		return response.Output, errors.New(response.Error)
+	}
+
+	return response.Output, nil
+}
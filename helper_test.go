@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAllowedHelperCommand(t *testing.T) {
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"show ctrlcount J", true},
+		{"show ctrlcount", true},
+		{"/c0 show all J", true},
+		{"/c12 show all", true},
+		{"/c0/eALL/sALL show all J", true},
+		{"/c0/pALL show phyerrorcounters J", true},
+		{"/c0 show securitykey J", true},
+		{"/c0 show termlog type=contents", true},
+		{"/c0 show events", true},
+		{"/c0 show events type=sincereboot", true},
+		{"/c0 show events type=latest=100", true},
+		{"/c0 show events type=sinceshutdown filter=critical", true},
+
+		{"", false},
+		{"show", false},
+		{"/c0 show", false},
+		{"/call show all J", false},
+		{"/c0/v0 show all J", false},
+		{"/c0 show all J logfile=/etc/cron.d/x", false},
+		{"/c0 show termlog type=contents logfile=/etc/passwd", false},
+		{"/c0 show events type=sincereboot logfile=/root/x", false},
+		{"/c0 show events file=/root/x", false},
+		{"/c0 show events type=latest=x", false},
+		{"/c0 show events filter=debug", false},
+		{"/c0 show termlog", false},
+		{"/c0 show alilog", false},
+		{"/c0 show securitykey keyid=x", false},
+		{"/c0/e32/s0 show all J", false},
+		{"/c0/eALL/sALL start locate", false},
+		{"/c0 set time=systemtime", false},
+		{"/c0 delete events", false},
+		{"/c0x show all J", false},
+	}
+
+	for _, test := range tests {
+		if allowed := allowedHelperCommand(strings.Fields(test.command)); allowed != test.allowed {
+			t.Errorf("%q: got %v, want %v", test.command, allowed, test.allowed)
+		}
+	}
+
+	// Words must match whole, not joined up.
+	if allowedHelperCommand([]string{"/c0", "show all", "J"}) {
+		t.Error(`"show all" as one word was allowed`)
+	}
+}
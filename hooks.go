@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hooks react to findings of the health rules as soon as a collection
+// sees them, without waiting for Prometheus to evaluate its alerts.
+// They are set up in the hooks section of --config.
+
+// Hook runs a command or posts to a URL for every finding that is
+// raised, changes severity or is resolved.
+type Hook struct {
+	// Command and its arguments. The event is passed as JSON on stdin
+	// and in STORCLI_* environment variables.
+	Exec []string `json:"exec,omitempty"`
+	// URL the event is POSTed to as JSON.
+	Webhook string            `json:"webhook,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Only these events, all if empty.
+	Events []string `json:"events,omitempty"`
+	// Only findings of these rules, all if empty.
+	Rules []string `json:"rules,omitempty"`
+	// warn or crit, warn if empty.
+	MinSeverity string `json:"min_severity,omitempty"`
+	// Give up on the hook after this long, e.g. 30s. Defaults to 10s.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// HookEvent is something that changed between two collections.
+type HookEvent struct {
+	// raised, changed or resolved for findings, error_count for drive
+	// error counters that went up and controller_event for new entries
+	// in the controller event log.
+	Event string `json:"event"`
+	Time  string `json:"time"`
+	Host  string `json:"host,omitempty"`
+	// Storcli address, e.g. /c0/e252/s4.
+	Object string `json:"object"`
+	// Only set for findings.
+	Rule     string `json:"rule,omitempty"`
+	Severity string `json:"severity"`
+	// Only set for changed events.
+	PreviousSeverity string `json:"previous_severity,omitempty"`
+	Message          string `json:"message"`
+}
+
+// FindingRecord is a finding remembered in the state for the next
+// collection.
+type FindingRecord struct {
+	Host     string `json:"host,omitempty"`
+	Object   string `json:"object"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func findingKey(host string, object string, rule string) string {
+	return host + object + " " + rule
+}
+
+var hookEvents = map[string]bool{"raised": true, "changed": true, "resolved": true, "error_count": true, "controller_event": true}
+
+// validateHooks checks the hooks section of the config file.
+func validateHooks(hooks []Hook) error {
+	for i, hook := range hooks {
+		if (len(hook.Exec) == 0) == (hook.Webhook == "") {
+			return fmt.Errorf("hooks[%d]: needs either exec or webhook", i)
+		}
+		if hook.MinSeverity != "" && hook.MinSeverity != "warn" && hook.MinSeverity != "crit" {
+			return fmt.Errorf("hooks[%d]: min_severity must be warn or crit, not %q", i, hook.MinSeverity)
+		}
+		for _, event := range hook.Events {
+			if !hookEvents[event] {
+				return fmt.Errorf("hooks[%d]: unknown event %q", i, event)
+			}
+		}
+		for _, rule := range hook.Rules {
+			if _, found := healthChecks[rule]; !found {
+				return fmt.Errorf("hooks[%d]: unknown rule %q", i, rule)
+			}
+		}
+		if hook.Timeout != "" {
+			if _, err := time.ParseDuration(hook.Timeout); err != nil {
+				return fmt.Errorf("hooks[%d]: timeout: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// trackFindings compares the findings with those of the previous
+// collection of the same host and returns what changed.
+func (s *State) trackFindings(system *System, findings []Finding, now time.Time) []HookEvent {
+
+	var events []HookEvent
+	current := map[string]bool{}
+	for _, finding := range findings {
+		key := findingKey(finding.Host, finding.Object, finding.Rule)
+		current[key] = true
+		event := HookEvent{
+			Time:     now.UTC().Format(time.RFC3339),
+			Host:     finding.Host,
+			Object:   finding.Object,
+			Rule:     finding.Rule,
+			Severity: strings.ToLower(finding.Severity.String()),
+			Message:  finding.Message,
+		}
+
+		previous, seen := s.Findings[key]
+		switch {
+		case !seen:
+			event.Event = "raised"
+			events = append(events, event)
+		case previous.Severity != event.Severity:
+			event.Event = "changed"
+			event.PreviousSeverity = previous.Severity
+			events = append(events, event)
+		}
+		s.Findings[key] = &FindingRecord{finding.Host, finding.Object, finding.Rule, event.Severity, finding.Message}
+	}
+
+	// A controller that couldn't be read this time hasn't recovered.
+	failed := map[string]bool{}
+	for _, index := range system.FailedControllers {
+		failed["/c"+strconv.Itoa(index)] = true
+	}
+
+	for key, record := range s.Findings {
+		if record.Host != system.Host || current[key] {
+			continue
+		}
+		if controller, _, _ := strings.Cut(strings.TrimPrefix(record.Object, "/"), "/"); failed["/"+controller] {
+			continue
+		}
+		events = append(events, HookEvent{
+			Event:    "resolved",
+			Time:     now.UTC().Format(time.RFC3339),
+			Host:     record.Host,
+			Object:   record.Object,
+			Rule:     record.Rule,
+			Severity: record.Severity,
+			Message:  record.Message,
+		})
+		delete(s.Findings, key)
+	}
+
+	return events
+}
+
+// Hooks run one after the other on a worker of their own, so a webhook
+// that hangs holds up neither the collection nor, in HTTP mode, every
+// scrape. Events that don't fit into the queue are dropped and logged.
+const hookQueueSize = 256
+
+type hookJob struct {
+	hook  Hook
+	event HookEvent
+}
+
+var hookQueue struct {
+	start   sync.Once
+	jobs    chan hookJob
+	pending sync.WaitGroup
+}
+
+// runHooks queues every event for the hooks that want it. A hook that
+// fails is logged and doesn't fail the collection.
+func runHooks(hooks []Hook, events []HookEvent) {
+	for _, event := range events {
+		for _, hook := range hooks {
+			if !hook.wants(event) {
+				continue
+			}
+			hookQueue.start.Do(startHookWorker)
+			hookQueue.pending.Add(1)
+			select {
+			case hookQueue.jobs <- hookJob{hook, event}:
+			default:
+				hookQueue.pending.Done()
+				log.Printf("Hook queue is full, dropping %s %s %s", event.Event, event.Object, event.Rule)
+			}
+		}
+	}
+}
+
+func startHookWorker() {
+	hookQueue.jobs = make(chan hookJob, hookQueueSize)
+	go func() {
+		for job := range hookQueue.jobs {
+			if err := job.hook.run(job.event); err != nil {
+				log.Printf("Hook for %s %s %s: %v", job.event.Event, job.event.Object, job.event.Rule, err)
+			}
+			hookQueue.pending.Done()
+		}
+	}()
+}
+
+// waitForHooks returns once the queued hooks ran, for one-shot runs that
+// would otherwise exit before them.
+func waitForHooks() {
+	hookQueue.pending.Wait()
+}
+
+func (h Hook) wants(event HookEvent) bool {
+
+	// A resolved or downgraded finding matters to a hook that heard
+	// about it at its previous severity.
+	severity := event.Severity
+	if event.PreviousSeverity == "crit" {
+		severity = "crit"
+	}
+	if h.MinSeverity == "crit" && severity != "crit" {
+		return false
+	}
+	if len(h.Events) > 0 {
+		wanted := false
+		for _, name := range h.Events {
+			wanted = wanted || name == event.Event
+		}
+		if !wanted {
+			return false
+		}
+	}
+	if len(h.Rules) == 0 {
+		return true
+	}
+	for _, rule := range h.Rules {
+		if rule == event.Rule {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Hook) run(event HookEvent) error {
+
+	timeout := 10 * time.Second
+	if h.Timeout != "" {
+		timeout, _ = time.ParseDuration(h.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if len(h.Exec) > 0 {
+		cmd := exec.CommandContext(ctx, h.Exec[0], h.Exec[1:]...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Env = append(os.Environ(),
+			"STORCLI_EVENT="+event.Event,
+			"STORCLI_HOST="+event.Host,
+			"STORCLI_OBJECT="+event.Object,
+			"STORCLI_RULE="+event.Rule,
+			"STORCLI_SEVERITY="+event.Severity,
+			"STORCLI_MESSAGE="+event.Message,
+		)
+		output, err := cmd.CombinedOutput()
+		if err != nil && len(output) > 0 {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+		}
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "storcli-collector/"+Version)
+	for name, value := range h.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTrackFindings(t *testing.T) {
+
+	failedDrive := Finding{Rule: "pd_failed", Severity: SeverityCrit, Object: "/c0/e32/s1", Message: "drive is Failed"}
+	hotController := Finding{Rule: "ctrl_temperature", Severity: SeverityWarn, Object: "/c1", Message: "controller is at 96°C"}
+	hotterController := Finding{Rule: "ctrl_temperature", Severity: SeverityCrit, Object: "/c1", Message: "controller is at 105°C"}
+
+	tests := []struct {
+		name     string
+		host     string
+		failed   []int
+		findings []Finding
+		events   []string
+	}{
+		{"first findings", "", nil, []Finding{failedDrive, hotController}, []string{"raised /c0/e32/s1 crit", "raised /c1 warn"}},
+		{"unchanged", "", nil, []Finding{failedDrive, hotController}, nil},
+		{"severity went up", "", nil, []Finding{failedDrive, hotterController}, []string{"changed /c1 crit"}},
+		{"other host", "db2", nil, nil, nil},
+		{"controller not read", "", []int{0}, []Finding{hotterController}, nil},
+		{"resolved", "", nil, nil, []string{"resolved /c0/e32/s1 crit", "resolved /c1 crit"}},
+		{"nothing left", "", nil, nil, nil},
+	}
+
+	s := newState()
+	for _, test := range tests {
+		for i := range test.findings {
+			test.findings[i].Host = test.host
+		}
+		system := &System{Host: test.host, FailedControllers: test.failed}
+		var events []string
+		for _, event := range s.trackFindings(system, test.findings, time.Now()) {
+			events = append(events, event.Event+" "+event.Object+" "+event.Severity)
+		}
+		sort.Strings(events)
+		if len(events) != len(test.events) {
+			t.Errorf("%s: got events %q, want %q", test.name, events, test.events)
+			continue
+		}
+		for i := range events {
+			if events[i] != test.events[i] {
+				t.Errorf("%s: got events %q, want %q", test.name, events, test.events)
+				break
+			}
+		}
+	}
+}
+
+func TestHookWants(t *testing.T) {
+
+	raisedWarn := HookEvent{Event: "raised", Rule: "pd_smart_alert", Severity: "warn"}
+	raisedCrit := HookEvent{Event: "raised", Rule: "pd_failed", Severity: "crit"}
+	downgraded := HookEvent{Event: "changed", Rule: "pd_failed", Severity: "warn", PreviousSeverity: "crit"}
+	errorCount := HookEvent{Event: "error_count", Severity: "warn"}
+
+	tests := []struct {
+		name  string
+		hook  Hook
+		event HookEvent
+		wants bool
+	}{
+		{"everything", Hook{}, raisedWarn, true},
+		{"crit only", Hook{MinSeverity: "crit"}, raisedWarn, false},
+		{"crit only, crit", Hook{MinSeverity: "crit"}, raisedCrit, true},
+		{"crit only, was crit", Hook{MinSeverity: "crit"}, downgraded, true},
+		{"event", Hook{Events: []string{"raised"}}, raisedWarn, true},
+		{"other event", Hook{Events: []string{"resolved"}}, raisedWarn, false},
+		{"rule", Hook{Rules: []string{"pd_failed"}}, raisedCrit, true},
+		{"other rule", Hook{Rules: []string{"pd_failed"}}, raisedWarn, false},
+		{"rules don't match error counts", Hook{Rules: []string{"pd_failed"}}, errorCount, false},
+	}
+
+	for _, test := range tests {
+		if wants := test.hook.wants(test.event); wants != test.wants {
+			t.Errorf("%s: got %v, want %v", test.name, wants, test.wants)
+		}
+	}
+}
+
+func TestValidateHooks(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		hook  Hook
+		valid bool
+	}{
+		{"exec", Hook{Exec: []string{"/usr/local/bin/page"}}, true},
+		{"webhook", Hook{Webhook: "https://hooks.example.com/raid", Events: []string{"raised", "resolved"}, MinSeverity: "crit", Timeout: "30s"}, true},
+		{"neither", Hook{}, false},
+		{"both", Hook{Exec: []string{"true"}, Webhook: "https://hooks.example.com/raid"}, false},
+		{"bad severity", Hook{Exec: []string{"true"}, MinSeverity: "critical"}, false},
+		{"unknown event", Hook{Exec: []string{"true"}, Events: []string{"fixed"}}, false},
+		{"unknown rule", Hook{Exec: []string{"true"}, Rules: []string{"pd_on_fire"}}, false},
+		{"bad timeout", Hook{Exec: []string{"true"}, Timeout: "30"}, false},
+	}
+
+	for _, test := range tests {
+		if err := validateHooks([]Hook{test.hook}); (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+
+	var received HookEvent
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Token")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	event := HookEvent{Event: "raised", Object: "/c0/e32/s1", Rule: "pd_failed", Severity: "crit", Message: "drive is Failed"}
+	hook := Hook{Webhook: server.URL, Headers: map[string]string{"X-Token": "secret"}}
+	if err := hook.run(event); err != nil {
+		t.Fatal(err)
+	}
+	if received != event || header != "secret" {
+		t.Errorf("got %+v with token %q, want %+v", received, header, event)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such channel", http.StatusNotFound)
+	}))
+	defer failing.Close()
+	if err := (Hook{Webhook: failing.URL}).run(event); err == nil {
+		t.Error("a 404 response wasn't an error")
+	}
+}
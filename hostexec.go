@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os/exec"
+	"path/filepath"
+)
+
+var hostRoot = flag.String("host-root", "", "Run storcli chrooted into the host's root filesystem mounted at this path, e.g. /host when running in a container.")
+var hostNsenter = flag.Bool("host-nsenter", false, "Run storcli in the host's namespaces with nsenter. The container needs the host PID namespace and to be privileged.")
+var useSudo = flag.Bool("use-sudo", false, "Run storcli with sudo, so only storcli runs as root. Needs a NOPASSWD rule for it.")
+var sudoPath = flag.String("sudo-path", "sudo", "sudo binary for --use-sudo, searched in PATH unless absolute.")
+
+// storcliWrapper returns the command storcli is started with, to elevate
+// it or to reach the host from inside a container. storcli paths stay
+// the host's.
+func storcliWrapper() ([]string, error) {
+
+	if *hostRoot != "" && *hostNsenter {
+		return nil, errors.New("--host-root and --host-nsenter can't be combined")
+	}
+
+	var wrapper []string
+	if *useSudo {
+		// -n fails instead of waiting for a password nobody types.
+		wrapper = append(wrapper, *sudoPath, "-n")
+	}
+	switch {
+	case *hostRoot != "":
+		wrapper = append(wrapper, "chroot", *hostRoot)
+	case *hostNsenter:
+		wrapper = append(wrapper, "nsenter", "--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid", "--")
+	}
+	if len(wrapper) == 0 {
+		return nil, nil
+	}
+
+	path, err := exec.LookPath(wrapper[0])
+	if err != nil {
+		return nil, err
+	}
+	wrapper[0] = path
+	return wrapper, nil
+}
+
+// hostPath is where a path of the host is visible to the collector.
+func hostPath(path string) string {
+	switch {
+	case *hostRoot != "":
+		return filepath.Join(*hostRoot, path)
+	case *hostNsenter:
+		// The root of PID 1 is the host's.
+		return filepath.Join("/proc/1/root", path)
+	}
+	return path
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var listenAddress = flag.String("listen-address", "", "Serve metrics over HTTP on this address, e.g. :9911, collecting on every scrape.")
+var listenUnix = flag.String("listen-unix", "", "Serve metrics over HTTP on this Unix socket instead of TCP, e.g. /run/storcli-collector.sock, for a reverse proxy in front.")
+var minCollectionInterval = flag.Duration("min-collection-interval", 0, "In HTTP mode, run storcli at most this often, e.g. 30s. Scrapes arriving sooner get the result of the previous collection.")
+var debugToken = flag.String("debug-token", "", "Bearer token required for /debug/ endpoints. They are disabled when empty.")
+var enablePprof = flag.Bool("enable-pprof", false, "Serve the Go profiler at /debug/pprof/ in HTTP mode, to look into CPU and memory use on large hosts. Needs --debug-token.")
+
+// Exporter collects a target on every scrape, one collection at a time.
+type Exporter struct {
+	Target Target
+
+	mu             sync.Mutex
+	lastTranscript *Transcript
+
+	// What /healthz reports and the refresher serves, kept apart from
+	// mu, which is held for as long as a collection runs.
+	statusMu       sync.Mutex
+	collecting     time.Time
+	lastCollection time.Time
+	lastFamilies   []*dto.MetricFamily
+	lastError      error
+
+	flightMu sync.Mutex
+	inFlight *flight
+}
+
+// flight is a running collection that scrapes arriving meanwhile wait
+// for, instead of queueing another one.
+type flight struct {
+	done     chan struct{}
+	families []*dto.MetricFamily
+	err      error
+}
+
+// The metric vectors and the drive state are shared by all targets.
+var gatherMu sync.Mutex
+
+// Gather collects the target, or shares the collection that is already
+// running when several Prometheus servers scrape at the same time, so
+// the controller sees one set of storcli commands.
+func (e *Exporter) Gather() ([]*dto.MetricFamily, error) {
+
+	e.flightMu.Lock()
+	if running := e.inFlight; running != nil {
+		e.flightMu.Unlock()
+		<-running.done
+		return running.families, running.err
+	}
+	current := &flight{done: make(chan struct{})}
+	e.inFlight = current
+	e.flightMu.Unlock()
+
+	current.families, current.err = e.gatherOnce()
+
+	e.flightMu.Lock()
+	e.inFlight = nil
+	e.flightMu.Unlock()
+	close(current.done)
+
+	return current.families, current.err
+}
+
+// Latest returns what the last collection gathered without waiting for
+// the one that is running, for scrapes in refresher mode. Only before
+// the first collection finished does it wait for it.
+func (e *Exporter) Latest() ([]*dto.MetricFamily, error) {
+	e.statusMu.Lock()
+	lastCollection, lastFamilies, lastError := e.lastCollection, e.lastFamilies, e.lastError
+	e.statusMu.Unlock()
+	if lastCollection.IsZero() {
+		return e.Gather()
+	}
+	return lastFamilies, lastError
+}
+
+// refresh collects the target every --interval until shutdown, so
+// scrapes never wait for storcli.
+func (e *Exporter) refresh(interval time.Duration, jitter time.Duration) {
+	for {
+		if _, err := e.Gather(); err != nil {
+			log.Print(err)
+		}
+		select {
+		case <-time.After(nextInterval(collectionInterval(interval), jitter)):
+		case <-shutdownContext.Done():
+			return
+		}
+	}
+}
+
+func (e *Exporter) gatherOnce() ([]*dto.MetricFamily, error) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Scrape storms from several Prometheus servers or someone
+	// hammering refresh don't reach the controller firmware.
+	e.statusMu.Lock()
+	lastCollection, lastFamilies, lastError := e.lastCollection, e.lastFamilies, e.lastError
+	e.statusMu.Unlock()
+	if *minCollectionInterval > 0 && !lastCollection.IsZero() && time.Since(lastCollection) < *minCollectionInterval {
+		return lastFamilies, lastError
+	}
+
+	defer beginCollection()()
+
+	e.statusMu.Lock()
+	e.collecting = time.Now()
+	e.statusMu.Unlock()
+
+	families, err := e.gather()
+
+	e.statusMu.Lock()
+	e.collecting = time.Time{}
+	e.lastCollection = time.Now()
+	e.lastFamilies = families
+	e.lastError = err
+	e.statusMu.Unlock()
+
+	return families, err
+}
+
+func (e *Exporter) gather() ([]*dto.MetricFamily, error) {
+
+	recorder := NewRecordingSource(dumpRaw(e.Target.Source, e.Target.Host))
+	system, err := collect(recorder)
+	transcript := recorder.Finish(err)
+	e.lastTranscript = &transcript
+
+	gatherMu.Lock()
+	defer gatherMu.Unlock()
+	if err != nil {
+		if *healthFile != "" {
+			recordHealthFailure(e.Target.Host, err)
+			if err := writeHealthFile(*healthFile); err != nil {
+				log.Print(err)
+			}
+		}
+		return nil, err
+	}
+	system.Host = e.Target.Host
+
+	applySimulations(system)
+	if err := trackState(system); err != nil {
+		return nil, err
+	}
+	if *healthFile != "" {
+		if err := writeHealthFile(*healthFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return gatherMetrics(system)
+}
+
+func (e *Exporter) serveLastCollection(w http.ResponseWriter, r *http.Request) {
+
+	e.mu.Lock()
+	transcript := e.lastTranscript
+	e.mu.Unlock()
+
+	if transcript == nil {
+		http.Error(w, "No collection has run yet.", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(transcript); err != nil {
+		log.Print(err)
+	}
+}
+
+// requireToken only lets requests with the debug token through, also
+// those of basic auth users of the web config, like the Prometheus
+// scrape account. Behind basic auth the Authorization header is taken,
+// so the token can be sent as X-Debug-Token instead.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bearer := subtle.ConstantTimeCompare([]byte("Bearer "+token), []byte(r.Header.Get("Authorization")))
+		header := subtle.ConstantTimeCompare([]byte(token), []byte(r.Header.Get("X-Debug-Token")))
+		if bearer != 1 && header != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// targetHandler picks the exporter by the target parameter, the way
+// snmp_exporter does. Without one, a single target is served directly.
+func targetHandler(exporters map[string]*Exporter, handler func(*Exporter) http.HandlerFunc) http.HandlerFunc {
+
+	handlers := map[string]http.HandlerFunc{}
+	for host, exporter := range exporters {
+		handlers[host] = handler(exporter)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" && len(handlers) == 1 {
+			for _, serve := range handlers {
+				serve(w, r)
+			}
+			return
+		}
+		if target == "" {
+			http.Error(w, "The target parameter is missing.", http.StatusBadRequest)
+			return
+		}
+		serve, found := handlers[sshHost(target)]
+		if !found {
+			http.Error(w, "Unknown target, it has to be one of --ssh-target.", http.StatusNotFound)
+			return
+		}
+		serve(w, r)
+	}
+}
+
+func serveHTTP(address string, targets []Target, interval time.Duration, jitter time.Duration) error {
+
+	exporters := map[string]*Exporter{}
+	for _, target := range targets {
+		exporters[target.Host] = &Exporter{Target: target}
+	}
+
+	// With --interval a refresher collects in the background and
+	// scrapes get its latest snapshot right away.
+	refresher := interval > 0
+	if refresher {
+		for _, exporter := range exporters {
+			go exporter.refresh(interval, jitter)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", targetHandler(exporters, func(exporter *Exporter) http.HandlerFunc {
+		gather := exporter.Gather
+		if refresher {
+			gather = exporter.Latest
+		}
+		return promhttp.HandlerFor(
+			prometheus.GathererFunc(gather),
+			promhttp.HandlerOpts{ErrorLog: log.Default()},
+		).ServeHTTP
+	}))
+	if *debugToken != "" {
+		mux.HandleFunc("/debug/last-collection", requireToken(*debugToken, targetHandler(exporters, func(exporter *Exporter) http.HandlerFunc {
+			return exporter.serveLastCollection
+		})))
+	}
+
+	if *enablePprof {
+		mux.HandleFunc("/debug/pprof/", requireToken(*debugToken, pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", requireToken(*debugToken, pprof.Cmdline))
+		mux.HandleFunc("/debug/pprof/profile", requireToken(*debugToken, pprof.Profile))
+		mux.HandleFunc("/debug/pprof/symbol", requireToken(*debugToken, pprof.Symbol))
+		mux.HandleFunc("/debug/pprof/trace", requireToken(*debugToken, pprof.Trace))
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		serveHealthz(w, exporters)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		serveLandingPage(w, exporters)
+	})
+
+	var listener net.Listener
+	var err error
+	if *systemdSocket {
+		listener, err = systemdListener()
+	} else if *listenUnix != "" {
+		listener, err = listenUnixSocket(*listenUnix)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range reloadSignal() {
+			gatherMu.Lock()
+			reloadConfig(*configFile)
+			gatherMu.Unlock()
+		}
+	}()
+
+	notifyReady()
+	return serveWithWebConfig(listener, mux, *webConfigFile)
+}
+
+// A collection that runs this long is stuck, even though every storcli
+// command has its own --command-timeout.
+const wedgedAfter = 10 * time.Minute
+
+// serveHealthz tells probes whether the last collection of every target
+// worked and none has been stuck. Before the first scrape it's healthy,
+// so a probe doesn't restart the collector before Prometheus got to it.
+func serveHealthz(w http.ResponseWriter, exporters map[string]*Exporter) {
+
+	healthy := true
+	var lines []string
+	for _, host := range sortedKeys(exporters) {
+		exporter := exporters[host]
+		exporter.statusMu.Lock()
+		collecting, lastCollection, lastError := exporter.collecting, exporter.lastCollection, exporter.lastError
+		exporter.statusMu.Unlock()
+
+		status := "ok"
+		switch {
+		case !collecting.IsZero() && time.Since(collecting) > wedgedAfter:
+			healthy = false
+			status = "collection running since " + collecting.UTC().Format(time.RFC3339)
+		case lastError != nil:
+			healthy = false
+			status = "last collection at " + lastCollection.UTC().Format(time.RFC3339) + " failed: " + lastError.Error()
+		case lastCollection.IsZero():
+			status = "ok, not collected yet"
+		}
+		if host == "" {
+			host = "local"
+		}
+		lines = append(lines, host+": "+status)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprintln(w, strings.Join(lines, "\n"))
+}
+
+func serveLandingPage(w http.ResponseWriter, exporters map[string]*Exporter) {
+
+	var links []string
+	if len(exporters) == 1 {
+		links = append(links, `<li><a href="metrics">Metrics</a></li>`)
+	} else {
+		for _, host := range sortedKeys(exporters) {
+			escaped := html.EscapeString(host)
+			links = append(links, fmt.Sprintf(`<li><a href="metrics?target=%s">Metrics of %s</a></li>`, html.EscapeString(url.QueryEscape(host)), escaped))
+		}
+	}
+	links = append(links, `<li><a href="healthz">Health of the collector</a></li>`)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><title>storcli-collector</title></head>
+<body>
+<h1>storcli-collector</h1>
+<p>Version %s</p>
+<ul>
+%s
+</ul>
+</body>
+</html>
+`, html.EscapeString(Version), strings.Join(links, "\n"))
+}
+
+func listenUnixSocket(socket string) (net.Listener, error) {
+
+	// A socket left over from a previous run blocks the address.
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	// The proxy has to share the collector's group.
+	if err := os.Chmod(socket, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	flags := flag.NewFlagSet("inventory", flag.ExitOnError)
+	output := flags.String("o", "", "CSV file to write. Defaults to standard output.")
+
+	RegisterSubcommand("inventory", &Subcommand{
+		Flags: flags,
+		Run: func(source Source) error {
+			system, err := collect(source)
+			if err != nil {
+				return err
+			}
+			if *sshTarget != "" {
+				system.Host = sshHost(strings.TrimSpace(*sshTarget))
+			}
+			if *output == "" {
+				return writeInventory(os.Stdout, system)
+			}
+			file, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			if err := writeInventory(file, system); err != nil {
+				file.Close()
+				return err
+			}
+			return file.Close()
+		},
+	})
+}
+
+var inventoryHeader = []string{
+	"host", "controller", "controller_model", "controller_serial",
+	"enclosure", "slot", "model", "serial", "firmware", "size", "media", "interface", "state",
+}
+
+// writeInventory lists every physical drive as a CSV row, for asset
+// management rather than monitoring.
+func writeInventory(w io.Writer, system *System) error {
+
+	host := system.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	serial := func(serial string) string {
+		if *anonymizeSerials {
+			return anonymizeSerial(serial)
+		}
+		return serial
+	}
+
+	out := csv.NewWriter(w)
+	out.Write(inventoryHeader)
+	for _, controller := range system.Controllers {
+		for _, drive := range controller.PhysicalDrives {
+			out.Write([]string{
+				host,
+				strconv.Itoa(controller.Index),
+				controller.Model,
+				serial(controller.Serial),
+				drive.Enclosure,
+				drive.Slot,
+				drive.Model,
+				serial(drive.Serial),
+				drive.Firmware,
+				drive.Size,
+				drive.Media,
+				drive.Interface,
+				drive.State,
+			})
+		}
+	}
+	out.Flush()
+
+	return out.Error()
+}
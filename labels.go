@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+var labelsFlag = flag.String("labels", "", "Add these labels to every metric, e.g. datacenter=ams1,rack=r12.")
+var addHostnameLabel = flag.Bool("add-hostname-label", false, "Add a hostname label with the name of this machine to every metric, for output files that are aggregated centrally.")
+
+// Parsed from --labels, plus the hostname label if enabled.
+var staticLabels = map[string]string{}
+
+func parseLabels(labels string) (map[string]string, error) {
+
+	parsed := map[string]string{}
+	for _, pair := range strings.Split(labels, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("--labels: %q is not name=value with a valid label name", pair)
+		}
+		parsed[name] = strings.TrimSpace(value)
+	}
+
+	return parsed, nil
+}
+
+// withLabels adds the labels to every sample. A label the metric already
+// has keeps its value.
+func withLabels(families []*dto.MetricFamily, labels map[string]string) {
+
+	if len(labels) == 0 {
+		return
+	}
+
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			existing := map[string]bool{}
+			for _, label := range metric.Label {
+				existing[label.GetName()] = true
+			}
+			for name, value := range labels {
+				if existing[name] {
+					continue
+				}
+				name, value := name, value
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+			}
+			sort.Slice(metric.Label, func(i, j int) bool {
+				return metric.Label[i].GetName() < metric.Label[j].GetName()
+			})
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var legacyNames = flag.Bool("legacy-names", false, "Also expose the metrics renamed in 0.2 under their old names and units, until dashboards are updated. This will be removed.")
+
+// legacyName is the name a metric had before it followed the naming
+// conventions, and what to multiply its value by to get the old unit.
+type legacyName struct {
+	Name  string
+	Scale float64
+}
+
+// By current name, without the namespace.
+var legacyMetricNames = map[string]legacyName{
+	"controller_temperature_celsius":     {"temperature", 1},
+	"controller_healthy":                 {"healthy", 1},
+	"controller_degraded":                {"degraded", 1},
+	"controller_failed":                  {"failed", 1},
+	"controller_time_difference_seconds": {"time_difference", 1},
+	"controller_ports":                   {"ports", 1},
+	"bbu_temperature_celsius":            {"bbu_temperature", 1},
+	"cv_temperature_celsius":             {"cv_temperature", 1},
+	"pd_link_speed_bits_per_second":      {"pd_link_speed_gbps", 1e-9},
+	"pd_device_speed_bits_per_second":    {"pd_device_speed_gbps", 1e-9},
+	"pd_media_errors_total":              {"pd_media_errors", 1},
+	"pd_other_errors_total":              {"pd_other_errors", 1},
+	"pd_crc_errors_total":                {"pd_crc_errors", 1},
+	"pd_predictive_errors_total":         {"pd_predictive_errors", 1},
+	"pd_shield_counter_total":            {"pd_shield_counter", 1},
+}
+
+// withLegacyNames appends a copy of every renamed family under its old
+// name with --legacy-names. The copies are gauges, as all metrics were
+// before, also those that are counters now.
+func withLegacyNames(families []*dto.MetricFamily) []*dto.MetricFamily {
+
+	if !*legacyNames {
+		return families
+	}
+
+	var legacy []*dto.MetricFamily
+	for _, family := range families {
+		old, renamed := legacyMetricNames[family.GetName()[len(Namespace)+1:]]
+		if !renamed {
+			continue
+		}
+		name := Namespace + "_" + old.Name
+		copied := &dto.MetricFamily{
+			Name: &name,
+			Help: family.Help,
+			Type: dto.MetricType_GAUGE.Enum(),
+		}
+		for _, metric := range family.Metric {
+			value := metric.GetGauge().GetValue()
+			if metric.Counter != nil {
+				value = metric.GetCounter().GetValue()
+			}
+			value *= old.Scale
+			copied.Metric = append(copied.Metric, &dto.Metric{
+				Label: metric.Label,
+				Gauge: &dto.Gauge{Value: &value},
+			})
+		}
+		legacy = append(legacy, copied)
+	}
+
+	return append(families, legacy...)
+}
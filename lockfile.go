@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var storcliLockFile = flag.String("storcli-lock-file", "", "(Optional) Lock this file around every storcli command, e.g. /var/lock/storcli.lock, so storcli never runs alongside other tools that lock it too. Commands of the collector then run one at a time.")
+var lockFilePath = flag.String("lock-file", "", "(Optional) Lock this file while running and give up if another collector holds it, so overlapping cron runs don't write the same output. It contains the PID of the holder.")
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// The lock is held for as long as the file is open, so it's kept here
+// for the life of the process.
+var heldLockFile *os.File
+
+// acquireLockFile takes --lock-file or fails right away.
+func acquireLockFile(path string) error {
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(file, false); err != nil {
+		holder, _ := os.ReadFile(path)
+		file.Close()
+		if errors.Is(err, errLocked) && len(holder) > 0 {
+			return fmt.Errorf("%s: collector %s is still running", path, holder)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Only the holder writes, so the PID is never mixed up.
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		file.Close()
+		return err
+	}
+
+	heldLockFile = file
+	return nil
+}
+
+// Time spent waiting for --storcli-lock-file since the start, see
+// collect.
+var storcliLockWaited struct {
+	sync.Mutex
+	total time.Duration
+}
+
+func storcliLockWaitTotal() time.Duration {
+	storcliLockWaited.Lock()
+	defer storcliLockWaited.Unlock()
+	return storcliLockWaited.total
+}
+
+// lockStorcli waits for the advisory lock on path, until the context of
+// the command ends, and returns the function that releases it.
+func lockStorcli(ctx context.Context, path string) (func(), error) {
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Polled rather than blocking, so the command timeout and a
+	// shutdown still end the wait.
+	start := time.Now()
+	for {
+		err = lockFile(file, false)
+		if !errors.Is(err, errLocked) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("waiting for %s: %w", path, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+			continue
+		}
+		break
+	}
+
+	storcliLockWaited.Lock()
+	storcliLockWaited.total += time.Since(start)
+	storcliLockWaited.Unlock()
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// Closing the file releases the lock.
+	return func() { file.Close() }, nil
+}
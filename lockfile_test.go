@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireLockFile(t *testing.T) {
+
+	defer func(held *os.File) { heldLockFile = held }(heldLockFile)
+
+	path := filepath.Join(t.TempDir(), "collector.lock")
+	// A PID left behind by a collector that exited is overwritten.
+	if err := os.WriteFile(path, []byte("999999999"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := acquireLockFile(path); err != nil {
+		t.Fatal(err)
+	}
+	first := heldLockFile
+	pid := strconv.Itoa(os.Getpid())
+	if holder, _ := os.ReadFile(path); string(holder) != pid {
+		t.Errorf("lock file contains %q, want the PID %s", holder, pid)
+	}
+
+	err := acquireLockFile(path)
+	if err == nil || !strings.Contains(err.Error(), "collector "+pid+" is still running") {
+		t.Errorf("second lock: got %v, want the holder's PID", err)
+	}
+	if heldLockFile != first {
+		t.Error("a failed lock replaced the held one")
+	}
+
+	first.Close()
+	if err := acquireLockFile(path); err != nil {
+		t.Errorf("lock after release: %v", err)
+	}
+	heldLockFile.Close()
+
+	if err := acquireLockFile(filepath.Join(t.TempDir(), "missing", "collector.lock")); err == nil {
+		t.Error("locked a file in a missing directory")
+	}
+}
+
+func TestLockStorcli(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "storcli.lock")
+
+	release, err := lockStorcli(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waited := storcliLockWaitTotal()
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	if _, err := lockStorcli(ctx, path); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("while held: got %v, want the deadline", err)
+	}
+	if wait := storcliLockWaitTotal() - waited; wait < 200*time.Millisecond {
+		t.Errorf("waited %v, want the time until the deadline", wait)
+	}
+
+	release()
+	release, err = lockStorcli(context.Background(), path)
+	if err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+	release()
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The 9260 series and older controllers can only be managed with
+// MegaCLI. Its output is mapped into the same model as storcli's, so the
+// metrics don't depend on the backend.
+
+var backend = flag.String("backend", "auto", "Tool to query the controllers with: storcli, megacli, or auto to use MegaCLI only when storcli isn't installed.")
+var megacliPath = flag.String("megacli-path", "/opt/MegaRAID/MegaCli/MegaCli64", "Absolute path to the MegaCLI binary, used with --backend=megacli.")
+
+// Where MegaCLI ends up besides --megacli-path.
+var megacliLocations = []string{
+	"/opt/MegaRAID/MegaCli/MegaCli64",
+	"/opt/MegaRAID/MegaCli/MegaCli",
+	"/usr/sbin/MegaCli64",
+	"/usr/sbin/megacli",
+	"/usr/local/sbin/MegaCli64",
+}
+
+// findBackend resolves --backend and returns the binary to run.
+func findBackend(storcliPath string, dontFailover bool) (string, error) {
+
+	if *backend == "megacli" {
+		return findMegaCLI(*megacliPath)
+	}
+
+	path, err := findStorcli(storcliPath, dontFailover)
+	if *backend == "auto" {
+		if err == nil {
+			*backend = "storcli"
+		} else if megacli, megacliErr := findMegaCLI(*megacliPath); megacliErr == nil {
+			log.Printf("storcli not found, using MegaCLI at %s", megacli)
+			*backend = "megacli"
+			return megacli, nil
+		}
+	}
+
+	return path, err
+}
+
+func collectMegaCLI(source Source) (*System, error) {
+
+	data, cmdErr := source.Query("-adpCount", "-NoLog")
+	count, err := parseMegaCLIAdapterCount(data)
+	if err != nil {
+		return nil, wrapCommandError(err, cmdErr)
+	}
+
+	// MegaCLI is old enough that nobody runs it on hosts with many
+	// controllers, so they are queried one after the other.
+	system := &System{}
+	for i := 0; i < count; i++ {
+		controller, err := collectMegaCLIAdapter(source, i)
+		if err != nil {
+			log.Printf("Could not collect controller %d: %v", i, err)
+			system.FailedControllers = append(system.FailedControllers, i)
+			continue
+		}
+		system.Controllers = append(system.Controllers, controller)
+	}
+
+	if len(system.Controllers) == 0 {
+		return nil, errors.New("Could not collect any controller.")
+	}
+
+	return system, nil
+}
+
+var adapterCountPattern = regexp.MustCompile(`Controller Count:\s*(\d+)`)
+
+func parseMegaCLIAdapterCount(data []byte) (int, error) {
+	match := adapterCountPattern.FindSubmatch(data)
+	if match == nil {
+		return 0, errors.New("Could not find controllers in output.")
+	}
+	count, _ := strconv.Atoi(string(match[1]))
+	if count == 0 {
+		return 0, errors.New("Could not find controllers in output.")
+	}
+	return count, nil
+}
+
+func collectMegaCLIAdapter(source Source, index int) (*ControllerState, error) {
+
+	adapter := "-a" + strconv.Itoa(index)
+
+	data, cmdErr := source.Query("-AdpAllInfo", adapter, "-NoLog")
+	records := parseMegaCLIRecords(data, "Product Name")
+	if len(records) == 0 {
+		return nil, wrapCommandError(errors.New("Could not find controllers in output."), cmdErr)
+	}
+	info := records[0]
+
+	state := &ControllerState{
+		Index:           index,
+		Model:           info["Product Name"],
+		Serial:          info["Serial No"],
+		FirmwareVersion: info["FW Version"],
+		// MegaCLI only talks to megaraid_sas.
+		DriverName:    "megaraid_sas",
+		DriverVersion: info["Driver Version"],
+		KeyManagement: "none",
+	}
+	state.Ports, _ = strconv.Atoi(info["Number of Backend Port"])
+	state.VirtualDriveCount, _ = strconv.Atoi(info["Virtual Drives"])
+	state.PhysicalDriveCount, _ = strconv.Atoi(info["Disks"])
+	state.DegradedVirtualDrives, _ = strconv.Atoi(info["Degraded"])
+	state.OfflineVirtualDrives, _ = strconv.Atoi(info["Offline"])
+	state.CriticalPhysicalDrives, _ = strconv.Atoi(info["Critical Disks"])
+	state.FailedPhysicalDrives, _ = strconv.Atoi(info["Failed Disks"])
+	if fields := strings.Fields(info["ROC temperature"]); len(fields) > 0 {
+		state.Temperature, _ = strconv.ParseFloat(fields[0], 64)
+	}
+
+	// MegaCLI has no overall controller status, so it is derived from
+	// the device counts.
+	state.Status = "Optimal"
+	if state.DegradedVirtualDrives+state.OfflineVirtualDrives+state.CriticalPhysicalDrives+state.FailedPhysicalDrives > 0 {
+		state.Status = "Degraded"
+	}
+
+	state.BBUStatus = -1
+	if info["BBU"] == "Present" {
+		data, _ := source.Query("-AdpBbuCmd", "-GetBbuStatus", adapter, "-NoLog")
+		bbu := parseMegaCLIRecords(data, "BBU status for Adapter")
+		if len(bbu) > 0 && bbu[0]["Battery State"] == "Optimal" {
+			state.BBUStatus = 0
+		}
+	}
+
+	if state.VirtualDriveCount > 0 {
+		data, cmdErr = source.Query("-LDPDInfo", adapter, "-NoLog")
+		records = parseMegaCLIRecords(data, "Virtual Drive")
+		if len(records) == 0 {
+			return nil, wrapCommandError(errors.New("Could not find virtual drives in output."), cmdErr)
+		}
+		driveGroups := map[string]bool{}
+		for _, record := range records {
+			virtualDrive := newMegaCLIVirtualDrive(record)
+			driveGroups[virtualDrive.DriveGroup] = true
+			state.VirtualDrives = append(state.VirtualDrives, virtualDrive)
+		}
+		state.DriveGroups = len(driveGroups)
+	}
+
+	if state.PhysicalDriveCount > 0 {
+		data, cmdErr = source.Query("-PDList", adapter, "-NoLog")
+		records = parseMegaCLIRecords(data, "Enclosure Device ID")
+		if len(records) == 0 {
+			return nil, wrapCommandError(errors.New("Could not find physical drives in output."), cmdErr)
+		}
+		for _, record := range records {
+			state.PhysicalDrives = append(state.PhysicalDrives, newMegaCLIPhysicalDrive(record))
+		}
+	}
+
+	return state, nil
+}
+
+// parseMegaCLIRecords reads "Key : Value" lines into one map per record.
+// A record starts at every line with startKey. Keys repeat between the
+// sections of a record, so the first value wins.
+func parseMegaCLIRecords(data []byte, startKey string) []map[string]string {
+
+	var records []map[string]string
+	var current map[string]string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if key == startKey {
+			records = append(records, map[string]string{})
+			current = records[len(records)-1]
+		}
+		if current == nil {
+			continue
+		}
+		if _, exists := current[key]; !exists {
+			current[key] = value
+		}
+	}
+
+	return records
+}
+
+// MegaCLI spells out the states storcli abbreviates.
+var megacliVirtualDriveStates = map[string]string{
+	"Optimal":            "Optl",
+	"Degraded":           "Dgrd",
+	"Partially Degraded": "Pdgd",
+	"Offline":            "OfLn",
+}
+
+var megacliPhysicalDriveStates = map[string]string{
+	"Online":             "Onln",
+	"Offline":            "Offln",
+	"Failed":             "Failed",
+	"Rebuild":            "Rbld",
+	"Copyback":           "Cpybck",
+	"Hotspare":           "GHS",
+	"JBOD":               "JBOD",
+	"Unconfigured(good)": "UGood",
+	"Unconfigured(bad)":  "UBad",
+}
+
+var raidLevelPattern = regexp.MustCompile(`Primary-(\d+)`)
+
+func newMegaCLIVirtualDrive(record map[string]string) VirtualDriveState {
+
+	virtualDrive := VirtualDriveState{
+		DriveGroup:  "-1",
+		VolumeGroup: strings.Fields(record["Virtual Drive"] + " -1")[0],
+		Name:        record["Name"],
+		State:       record["State"],
+	}
+	if state, ok := megacliVirtualDriveStates[record["State"]]; ok {
+		virtualDrive.State = state
+	}
+
+	// The drive group of a VD is only found on its drives.
+	if position := record["Drive's position"]; position != "" {
+		group := strings.TrimPrefix(strings.Split(position, ",")[0], "DiskGroup:")
+		virtualDrive.DriveGroup = strings.TrimSpace(group)
+	}
+
+	if match := raidLevelPattern.FindStringSubmatch(record["RAID Level"]); match != nil {
+		virtualDrive.Type = "RAID" + match[1]
+		if spans, _ := strconv.Atoi(record["Span Depth"]); spans > 1 {
+			virtualDrive.Type += "0"
+		}
+	}
+
+	// Rebuild storcli's cache abbreviation, e.g. RWBD: read policy,
+	// write policy, then I/O policy.
+	var read, write, io string
+	for _, policy := range strings.Split(record["Current Cache Policy"], ",") {
+		switch strings.TrimSpace(policy) {
+		case "ReadAhead", "ReadAdaptive":
+			read = "R"
+		case "ReadAheadNone":
+			read = "NR"
+		case "WriteBack":
+			write = "WB"
+		case "Always WriteBack":
+			write = "AWB"
+		case "WriteThrough":
+			write = "WT"
+		case "Direct":
+			io = "D"
+		case "Cached":
+			io = "C"
+		}
+	}
+	cache := read + write + io
+	virtualDrive.Cache = cache
+
+	return virtualDrive
+}
+
+func newMegaCLIPhysicalDrive(record map[string]string) *PhysicalDriveState {
+
+	drive := &PhysicalDriveState{
+		Enclosure:  record["Enclosure Device ID"],
+		Slot:       record["Slot Number"],
+		Interface:  record["PD Type"],
+		DriveGroup: "-",
+		Detailed:   true,
+		Firmware:   record["Device Firmware Level"],
+	}
+	if drive.Enclosure == "N/A" {
+		drive.Enclosure = ""
+	}
+	drive.DID, _ = strconv.Atoi(record["Device Id"])
+	// e.g. "558.375 GB [0x45cd2fb0 Sectors]", the size storcli shows.
+	drive.Size = strings.TrimSpace(strings.Split(record["Coerced Size"], "[")[0])
+
+	switch record["Media Type"] {
+	case "Hard Disk Device":
+		drive.Media = "HDD"
+	case "Solid State Device":
+		drive.Media = "SSD"
+	default:
+		drive.Media = record["Media Type"]
+	}
+
+	// e.g. "Online, Spun Up"
+	firmwareState := strings.TrimSpace(strings.Split(record["Firmware state"], ",")[0])
+	drive.State = firmwareState
+	if state, ok := megacliPhysicalDriveStates[firmwareState]; ok {
+		drive.State = state
+	}
+
+	if position := record["Drive's position"]; position != "" {
+		group := strings.TrimPrefix(strings.Split(position, ",")[0], "DiskGroup:")
+		drive.DriveGroup = strings.TrimSpace(group)
+	}
+	if record["Foreign State"] == "Foreign" {
+		drive.DriveGroup = "F"
+		drive.Foreign = true
+	}
+
+	drive.ShieldCounter, _ = strconv.ParseFloat(record["Shield Counter"], 64)
+	drive.MediaErrors, _ = strconv.ParseFloat(record["Media Error Count"], 64)
+	drive.OtherErrors, _ = strconv.ParseFloat(record["Other Error Count"], 64)
+	drive.PredictiveErrors, _ = strconv.ParseFloat(record["Predictive Failure Count"], 64)
+	drive.SmartAlerted = record["Drive has flagged a S.M.A.R.T alert"] == "Yes"
+	drive.LinkSpeed, _ = strconv.ParseFloat(strings.Split(record["Link Speed"], ".")[0], 64)
+	drive.DeviceSpeed, _ = strconv.ParseFloat(strings.Split(record["Device Speed"], ".")[0], 64)
+
+	drive.SED = SEDState{
+		Capable: record["FDE Capable"] == "Capable",
+		Secured: record["FDE Enable"] == "Enable",
+		Locked:  record["Locked"] == "Locked",
+	}
+
+	drive.Model, drive.Serial = parseInquiryData(record["Inquiry Data"], drive.Firmware)
+
+	return drive
+}
+
+// parseInquiryData splits the raw SCSI inquiry string MegaCLI prints
+// instead of separate model and serial fields. SAS drives report
+// "VENDOR MODEL FIRMWARESERIAL", SATA drives "SERIAL MODEL FIRMWARE".
+func parseInquiryData(inquiry string, firmware string) (model string, serial string) {
+
+	fields := strings.Fields(inquiry)
+	if firmware != "" && len(fields) >= 2 {
+		last := fields[len(fields)-1]
+		switch {
+		case last == firmware:
+			serial = fields[0]
+			fields = fields[1 : len(fields)-1]
+		case strings.HasPrefix(last, firmware):
+			serial = strings.TrimPrefix(last, firmware)
+			fields = fields[1 : len(fields)-1]
+		}
+	}
+
+	return normalizeDriveModel(strings.Join(fields, " ")), serial
+}
+
+// findMegaCLI returns the MegaCLI binary to run.
+func findMegaCLI(path string) (string, error) {
+
+	if _, err := os.Stat(hostPath(path)); err == nil {
+		return path, nil
+	}
+	for _, executable := range megacliLocations {
+		if _, err := os.Stat(hostPath(executable)); err == nil {
+			return executable, nil
+		}
+	}
+
+	return "", fmt.Errorf("MegaCLI not found at %s", path)
+}
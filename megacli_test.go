@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestParseInquiryData(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		inquiry  string
+		firmware string
+		model    string
+		serial   string
+	}{
+		{
+			name:     "SAS, serial after the firmware",
+			inquiry:  "SEAGATE ST600MM0006     0004S0M1ZXK5",
+			firmware: "0004",
+			model:    "ST600MM0006",
+			serial:   "S0M1ZXK5",
+		},
+		{
+			name:     "SATA, serial first",
+			inquiry:  "S3Z9NB0K123456A     SAMSUNG_MZ7LH480  HXT7404Q",
+			firmware: "HXT7404Q",
+			model:    "SAMSUNG_MZ7LH480",
+			serial:   "S3Z9NB0K123456A",
+		},
+		{
+			name:     "SATA, model of several words joined like storcli's",
+			inquiry:  "BTYF12345678480BGN  INTEL SSDSC2KG480G8                      XCV10132",
+			firmware: "XCV10132",
+			model:    "INTELSSDSC2KG480G8",
+			serial:   "BTYF12345678480BGN",
+		},
+		{
+			name:     "unknown firmware",
+			inquiry:  "SEAGATE ST600MM0006 0004S0M1ZXK5",
+			firmware: "",
+			model:    "SEAGATEST600MM00060004S0M1ZXK5",
+		},
+		{
+			name:     "firmware not found",
+			inquiry:  "SEAGATE ST600MM0006 0004S0M1ZXK5",
+			firmware: "0005",
+			model:    "SEAGATEST600MM00060004S0M1ZXK5",
+		},
+		{
+			name:     "empty",
+			firmware: "0004",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model, serial := parseInquiryData(test.inquiry, test.firmware)
+			if model != test.model || serial != test.serial {
+				t.Errorf("got %q, %q, want %q, %q", model, serial, test.model, test.serial)
+			}
+		})
+	}
+}
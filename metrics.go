@@ -0,0 +1,1075 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var Metrics = map[string]*prometheus.GaugeVec{
+	"build_info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "exporter_build_info",
+			Help:      "MegaRAID collector version it was built from",
+		},
+		[]string{"version", "revision", "goversion"},
+	),
+	"storcli_version": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "storcli_version_info",
+			Help:      "MegaRAID storcli version that was run",
+		},
+		[]string{"version", "path"},
+	),
+	"storcli_lock_wait": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "storcli_lock_wait_seconds",
+			Help:      "MegaRAID collection time spent waiting for the storcli lock file",
+		},
+		[]string{},
+	),
+	"cache_age": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "cache_age_seconds",
+			Help:      "MegaRAID age of the oldest cached drive details in the collection, see --cache-ttl",
+		},
+		[]string{},
+	),
+	"spool_mtime": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "spool_mtime_seconds",
+			Help:      "MegaRAID modification time of the oldest --spool-dir file the collection read",
+		},
+		[]string{},
+	),
+	"ctrl_collection_failed": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_collection_failed",
+			Help:      "MegaRAID controller output could not be collected",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_info",
+			Help:      "MegaRAID controller info",
+		},
+		[]string{"controller", "model", "serial", "fwversion"},
+	),
+	"ctrl_unsupported_combo": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_unsupported_combo",
+			Help:      "MegaRAID controller firmware and driver combination not on the approved list",
+		},
+		[]string{"controller", "model", "fwversion", "driver", "driverversion"},
+	),
+	"ctrl_temperature": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_temperature_celsius",
+			Help:      "MegaRAID controller temperature in Celsius",
+		},
+		[]string{"controller"},
+	),
+	// The TTY log is a ring buffer without sequence numbers, so lines
+	// can't be told apart between collections and aren't counted up.
+	"ctrl_termlog_errors": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "termlog_error_lines",
+			Help:      "MegaRAID controller firmware error lines currently in the TTY log",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_healthy": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_healthy",
+			Help:      "MegaRAID controller healthy",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_degraded": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_degraded",
+			Help:      "MegaRAID controller degraded",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_failed": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_failed",
+			Help:      "MegaRAID controller failed",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_time_difference": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_time_difference_seconds",
+			Help:      "MegaRAID controller clock behind the system clock in seconds",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_security_supported": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "security_supported",
+			Help:      "MegaRAID controller supports drive security",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_security_enabled": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "security_enabled",
+			Help:      "MegaRAID controller drive security enabled",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_key_management": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "key_management_info",
+			Help:      "MegaRAID controller security key management mode",
+		},
+		[]string{"controller", "mode"},
+	),
+	"ctrl_last_event": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "last_event_timestamp_seconds",
+			Help:      "MegaRAID controller time of the most recent event by severity",
+		},
+		[]string{"controller", "severity"},
+	),
+	"ctrl_last_event_corrected": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "last_event_corrected_timestamp_seconds",
+			Help:      "MegaRAID controller time of the most recent event by severity, adjusted for controller clock skew",
+		},
+		[]string{"controller", "severity"},
+	),
+	"bbu_healthy": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "battery_backup_healthy",
+			Help:      "MegaRAID battery backup healthy",
+		},
+		[]string{"controller"},
+	),
+	"bbu_temperature": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "bbu_temperature_celsius",
+			Help:      "MegaRAID battery backup temperature in Celsius",
+		},
+		[]string{"controller", "bbuidx"},
+	),
+	"cv_temperature": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "cv_temperature_celsius",
+			Help:      "MegaRAID CacheVault temperature in Celsius",
+		},
+		[]string{"controller", "cvidx"},
+	),
+	"ctrl_sched_patrol_read": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "scheduled_patrol_read",
+			Help:      "MegaRAID scheduled patrol read",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_scheduled_task_enabled": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "scheduled_task_enabled",
+			Help:      "MegaRAID scheduled task is enabled",
+		},
+		[]string{"controller", "task"},
+	),
+	"ctrl_scheduled_task_interval": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "scheduled_task_interval_seconds",
+			Help:      "MegaRAID scheduled task reoccurrence",
+		},
+		[]string{"controller", "task"},
+	),
+	"ctrl_scheduled_task_next_run": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "scheduled_task_next_run_timestamp_seconds",
+			Help:      "MegaRAID scheduled task next launch",
+		},
+		[]string{"controller", "task"},
+	),
+	"summary_healthy": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "summary_healthy",
+			Help:      "MegaRAID all controllers collected and optimal",
+		},
+		[]string{},
+	),
+	"summary_drive_failed": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "summary_drive_failed",
+			Help:      "MegaRAID any physical drive failed",
+		},
+		[]string{},
+	),
+	"summary_vd_degraded": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "summary_vd_degraded",
+			Help:      "MegaRAID any virtual drive not optimal",
+		},
+		[]string{},
+	),
+	"summary_attention": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "summary_attention",
+			Help:      "MegaRAID anything on the host needs attention",
+		},
+		[]string{},
+	),
+	"health_finding": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "health_finding",
+			Help:      "MegaRAID health rule that matched an object",
+		},
+		[]string{"object", "rule", "severity"},
+	),
+	"ctrl_phy_errors": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "phy_errors",
+			Help:      "MegaRAID HBA PHY error counter",
+		},
+		[]string{"controller", "phy", "type"},
+	),
+	"simulation_active": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "simulation_active",
+			Help:      "MegaRAID metrics include simulated failures",
+		},
+		[]string{},
+	),
+	"ctrl_unsupported_driver": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_unsupported_driver",
+			Help:      "MegaRAID controller driver only gets a subset of the metrics",
+		},
+		[]string{"controller", "driver"},
+	),
+	"ctrl_ports": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "controller_ports",
+			Help:      "MegaRAID ports",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_physical_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "physical_drives",
+			Help:      "MegaRAID physical drives",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_drive_groups": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "drive_groups",
+			Help:      "MegaRAID drive groups",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_virtual_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "virtual_drives",
+			Help:      "MegaRAID virtual drives",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_degraded_virtual_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "degraded_virtual_drives",
+			Help:      "MegaRAID virtual drives degraded, as counted by the controller",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_offline_virtual_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "offline_virtual_drives",
+			Help:      "MegaRAID virtual drives offline, as counted by the controller",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_failed_physical_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "failed_physical_drives",
+			Help:      "MegaRAID physical drives failed, as counted by the controller",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_critical_physical_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "critical_physical_drives",
+			Help:      "MegaRAID critical physical drives, as counted by the controller",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_sed_capable_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "sed_capable_drives",
+			Help:      "MegaRAID self-encrypting capable physical drives",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_secured_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "secured_drives",
+			Help:      "MegaRAID secured physical drives",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_locked_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "locked_drives",
+			Help:      "MegaRAID locked physical drives",
+		},
+		[]string{"controller"},
+	),
+	"ctrl_locked_foreign_drives": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "locked_foreign_drives",
+			Help:      "MegaRAID security locked physical drives of foreign configurations",
+		},
+		[]string{"controller"},
+	),
+	"vd_info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "vd_info",
+			Help:      "MegaRAID virtual drive info",
+		},
+		[]string{"controller", "DG", "VG", "name", "cache", "type", "state"},
+	),
+	"pd_in_shield_state": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_in_shield_state",
+			Help:      "MegaRAID physical drive shielded for diagnostics",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_smart_alerted": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_smart_alerted",
+			Help:      "MegaRAID physical drive SMART alerted",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_link_speed": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_link_speed_bits_per_second",
+			Help:      "MegaRAID physical drive link speed in bits per second",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_device_speed": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_device_speed_bits_per_second",
+			Help:      "MegaRAID physical drive device speed in bits per second",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_commissioned_spare": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_commissioned_spare",
+			Help:      "MegaRAID physical drive commissioned spare",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_emergency_spare": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_emergency_spare",
+			Help:      "MegaRAID physical drive emergency spare",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_sed_capable": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_sed_capable",
+			Help:      "MegaRAID physical drive self-encrypting capable",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_secured": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_secured",
+			Help:      "MegaRAID physical drive secured",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_locked": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_locked",
+			Help:      "MegaRAID physical drive locked",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_foreign_locked": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_foreign_locked",
+			Help:      "MegaRAID physical drive of a foreign configuration security locked",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_locate_active": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_locate_active",
+			Help:      "MegaRAID physical drive locate LED active",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_certified": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_certified",
+			Help:      "MegaRAID physical drive vendor certified",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_last_patrol_read": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_last_patrol_read_timestamp_seconds",
+			Help:      "MegaRAID physical drive last patrol read completion",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_info": prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "pd_info",
+			Help:      "MegaRAID physical drive info",
+		},
+		[]string{
+			"controller",
+			"enclosure",
+			"slot",
+			"disk_id",
+			"interface",
+			"media",
+			"model",
+			"DG",
+			"state",
+			"firmware",
+			"serial",
+		},
+	),
+}
+
+// Counters holds the metrics that only ever go up.
+var Counters = map[string]*prometheus.CounterVec{
+	"ctrl_events": prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "events_total",
+			Help:      "MegaRAID controller events by severity",
+		},
+		[]string{"controller", "severity"},
+	),
+	"pd_shield_counter": prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "pd_shield_counter_total",
+			Help:      "MegaRAID physical drive times shielded for diagnostics",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_media_errors": prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "pd_media_errors_total",
+			Help:      "MegaRAID physical drive media errors",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_other_errors": prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "pd_other_errors_total",
+			Help:      "MegaRAID physical drive other errors",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_crc_errors": prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "pd_crc_errors_total",
+			Help:      "MegaRAID physical drive interface CRC errors",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_predictive_errors": prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "pd_predictive_errors_total",
+			Help:      "MegaRAID physical drive predictive errors",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+	"pd_firmware_changed": prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "pd_firmware_changed_total",
+			Help:      "MegaRAID physical drive firmware changes since the drive was first seen",
+		},
+		[]string{"controller", "enclosure", "slot"},
+	),
+}
+
+// gatherMetrics turns the normalized model into metric samples.
+func gatherMetrics(system *System) ([]*dto.MetricFamily, error) {
+
+	reg := prometheus.NewRegistry()
+	// Every collection starts from empty vectors, so pulled drives,
+	// deleted VDs and controllers that failed to collect disappear
+	// instead of repeating their last values. The vectors are shared
+	// by all hosts as well.
+	for _, v := range Metrics {
+		v.Reset()
+		reg.MustRegister(v)
+	}
+	// Counters are set from the totals storcli reports, not
+	// incremented, so they start over on every collection too.
+	for _, v := range Counters {
+		v.Reset()
+		reg.MustRegister(v)
+	}
+
+	Metrics["build_info"].With(prometheus.Labels{
+		"version":   Version,
+		"revision":  buildRevision(),
+		"goversion": runtime.Version(),
+	}).Set(1)
+
+	// Set here, after the reset, so fake findings can always be told
+	// apart from real ones.
+	if len(simulations) > 0 {
+		Metrics["simulation_active"].With(prometheus.Labels{}).Set(1)
+	}
+
+	// Parsing problems tend to come with particular storcli versions.
+	if system.StorcliVersion != "" {
+		Metrics["storcli_version"].With(prometheus.Labels{
+			"version": system.StorcliVersion,
+			"path":    StorcliPath,
+		}).Set(1)
+	}
+
+	if *cacheTTL > 0 {
+		Metrics["cache_age"].With(prometheus.Labels{}).Set(takeCacheAge(system.Host).Seconds())
+	}
+
+	if *spoolDir != "" && !*spoolWrite {
+		if mtime, found := takeSpoolMtime(); found {
+			Metrics["spool_mtime"].With(prometheus.Labels{}).Set(float64(mtime.Unix()))
+		}
+	}
+
+	// Remote hosts and the helper run storcli without this process
+	// locking it.
+	if *storcliLockFile != "" && system.Host == "" && *helperSocket == "" && *dropToUser == "" {
+		Metrics["storcli_lock_wait"].With(prometheus.Labels{}).Set(system.StorcliLockWait)
+	}
+
+	for _, index := range system.FailedControllers {
+		Metrics["ctrl_collection_failed"].With(prometheus.Labels{
+			"controller": strconv.Itoa(index),
+		}).Set(1)
+	}
+
+	for _, controller := range system.Controllers {
+		Metrics["ctrl_collection_failed"].With(prometheus.Labels{
+			"controller": strconv.Itoa(controller.Index),
+		}).Set(0)
+		handleCommonController(controller)
+		// Other drivers get whatever storcli reports for them, which
+		// is usually the drive list.
+		if controller.IsMegaraid() {
+			handleMegaraidController(controller)
+		} else {
+			handleHBAController(controller)
+		}
+	}
+
+	handleSummary(system)
+
+	families, err := reg.Gather()
+	if err != nil {
+		return nil, err
+	}
+	labels := configLabels()
+	if system.Host != "" {
+		labels["host"] = system.Host
+	}
+	withLabels(families, labels)
+	withAnonymizedSerials(families)
+	return withCompatPrefix(withLegacyNames(families)), nil
+}
+
+// handleSummary rolls the health of the whole host up into a handful of
+// series, see --outfile-summary.
+func handleSummary(system *System) {
+
+	healthy := len(system.FailedControllers) == 0
+	var driveFailed, vdDegraded bool
+	for _, controller := range system.Controllers {
+		if !controller.Healthy() {
+			healthy = false
+		}
+		for _, virtualDrive := range controller.VirtualDrives {
+			if !virtualDrive.Optimal() {
+				vdDegraded = true
+			}
+		}
+		for _, physicalDrive := range controller.PhysicalDrives {
+			if physicalDrive.Failed() {
+				driveFailed = true
+			}
+		}
+	}
+
+	Metrics["summary_healthy"].With(prometheus.Labels{}).Set(boolToFloat(healthy))
+	Metrics["summary_drive_failed"].With(prometheus.Labels{}).Set(boolToFloat(driveFailed))
+	Metrics["summary_vd_degraded"].With(prometheus.Labels{}).Set(boolToFloat(vdDegraded))
+	// Whether something deserves a look is up to the health rules.
+	findings := evaluateHealth(system)
+	attention := worstSeverity(findings) > SeverityOK
+	Metrics["summary_attention"].With(prometheus.Labels{}).Set(boolToFloat(attention))
+	for _, finding := range findings {
+		Metrics["health_finding"].With(prometheus.Labels{
+			"object":   finding.Object,
+			"rule":     finding.Rule,
+			"severity": strings.ToLower(finding.Severity.String()),
+		}).Set(1)
+	}
+}
+
+func handleCommonController(controller *ControllerState) {
+
+	controllerIndex := strconv.Itoa(controller.Index)
+
+	Metrics["ctrl_info"].With(prometheus.Labels{
+		"controller": controllerIndex,
+		"model":      controller.Model,
+		"serial":     controller.Serial,
+		"fwversion":  controller.FirmwareVersion,
+	}).Set(1)
+
+	Metrics["ctrl_temperature"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(controller.Temperature)
+
+	Metrics["ctrl_unsupported_driver"].With(prometheus.Labels{
+		"controller": controllerIndex,
+		"driver":     controller.DriverName,
+	}).Set(boolToFloat(!controller.IsMegaraid() && !controller.IsHBA()))
+
+	if len(config.ApprovedCombinations) > 0 {
+		Metrics["ctrl_unsupported_combo"].With(prometheus.Labels{
+			"controller":    controllerIndex,
+			"model":         controller.Model,
+			"fwversion":     controller.FirmwareVersion,
+			"driver":        controller.DriverName,
+			"driverversion": controller.DriverVersion,
+		}).Set(boolToFloat(!isApprovedCombination(controller, config.ApprovedCombinations)))
+	}
+
+}
+
+func handleMegaraidController(controller *ControllerState) {
+
+	controllerIndex := strconv.Itoa(controller.Index)
+
+	Metrics["bbu_healthy"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(boolToFloat(controller.BBUHealthy()))
+
+	var controllerStatusDegraded float64
+	var controllerStatusFailed float64
+	var controllerStatusOptimal float64
+
+	switch controller.Status {
+	case "Degraded":
+		controllerStatusDegraded = 1
+	case "Failed":
+		controllerStatusFailed = 1
+	case "Optimal":
+		controllerStatusOptimal = 1
+	}
+
+	Metrics["ctrl_degraded"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(controllerStatusDegraded)
+	Metrics["ctrl_failed"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(controllerStatusFailed)
+	Metrics["ctrl_healthy"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(controllerStatusOptimal)
+
+	Metrics["ctrl_security_supported"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(boolToFloat(controller.SecuritySupported))
+	Metrics["ctrl_security_enabled"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(boolToFloat(controller.SecurityEnabled))
+	Metrics["ctrl_key_management"].With(prometheus.Labels{
+		"controller": controllerIndex,
+		"mode":       controller.KeyManagement,
+	}).Set(1)
+
+	if controller.EventsCollected {
+		handleEvents(controller.Events, controller.EventCounts, controllerIndex)
+	}
+
+	if controller.TermLogCollected {
+		Metrics["ctrl_termlog_errors"].With(prometheus.Labels{
+			"controller": controllerIndex,
+		}).Set(float64(controller.TermLogErrors))
+	}
+
+	Metrics["ctrl_ports"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(float64(controller.Ports))
+
+	var scheduledPatrolRead float64
+	if strings.Contains(controller.PatrolReadReoccurrence, "hrs") {
+		scheduledPatrolRead = 1
+	}
+	Metrics["ctrl_sched_patrol_read"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(scheduledPatrolRead)
+
+	for _, task := range controller.ScheduledTasks {
+		labels := prometheus.Labels{
+			"controller": controllerIndex,
+			"task":       task.Name,
+		}
+		Metrics["ctrl_scheduled_task_enabled"].With(labels).Set(boolToFloat(task.Enabled))
+		Metrics["ctrl_scheduled_task_interval"].With(labels).Set(task.IntervalSeconds)
+		if task.HasNextRun {
+			Metrics["ctrl_scheduled_task_next_run"].With(labels).Set(float64(task.NextRun.Unix()))
+		}
+	}
+
+	for cvidx, temperature := range controller.CacheVaultTemperatures {
+		Metrics["cv_temperature"].With(prometheus.Labels{
+			"controller": controllerIndex,
+			"cvidx":      strconv.Itoa(cvidx),
+		}).Set(temperature)
+	}
+
+	for bbuidx, temperature := range controller.BBUTemperatures {
+		Metrics["bbu_temperature"].With(prometheus.Labels{
+			"controller": controllerIndex,
+			"bbuidx":     strconv.Itoa(bbuidx),
+		}).Set(temperature)
+	}
+
+	if controller.HasTimeDifference {
+		Metrics["ctrl_time_difference"].With(prometheus.Labels{
+			"controller": controllerIndex,
+		}).Set(controller.TimeDifference)
+	}
+
+	if controller.DriveGroups > 0 {
+		Metrics["ctrl_drive_groups"].With(prometheus.Labels{
+			"controller": controllerIndex,
+		}).Set(float64(controller.DriveGroups))
+		Metrics["ctrl_virtual_drives"].With(prometheus.Labels{
+			"controller": controllerIndex,
+		}).Set(float64(controller.VirtualDriveCount))
+
+		for _, virtualDrive := range controller.VirtualDrives {
+			Metrics["vd_info"].With(prometheus.Labels{
+				"controller": controllerIndex,
+				"DG":         virtualDrive.DriveGroup,
+				"VG":         virtualDrive.VolumeGroup,
+				"name":       virtualDrive.Name,
+				"cache":      virtualDrive.Cache,
+				"type":       virtualDrive.Type,
+				"state":      virtualDrive.State,
+			}).Set(1)
+		}
+	}
+
+	// The controller's own counts make health visible without a series
+	// per drive.
+	Metrics["ctrl_degraded_virtual_drives"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(float64(controller.DegradedVirtualDrives))
+	Metrics["ctrl_offline_virtual_drives"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(float64(controller.OfflineVirtualDrives))
+
+	handlePhysicalDrives(controller, controllerIndex)
+}
+
+// handleHBAController exports what an HBA in IT mode has to offer. There
+// are no virtual drives, so the drives and PHYs are all there is. It is
+// also the best effort for drivers nobody has sent output of yet.
+func handleHBAController(controller *ControllerState) {
+
+	controllerIndex := strconv.Itoa(controller.Index)
+
+	for _, phy := range controller.PhyErrors {
+		Metrics["ctrl_phy_errors"].With(prometheus.Labels{
+			"controller": controllerIndex,
+			"phy":        strconv.Itoa(phy.Phy),
+			"type":       phy.Type,
+		}).Set(phy.Count)
+	}
+
+	handlePhysicalDrives(controller, controllerIndex)
+}
+
+func handlePhysicalDrives(controller *ControllerState, controllerIndex string) {
+
+	Metrics["ctrl_physical_drives"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(float64(controller.PhysicalDriveCount))
+
+	Metrics["ctrl_failed_physical_drives"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(float64(controller.FailedPhysicalDrives))
+	Metrics["ctrl_critical_physical_drives"].With(prometheus.Labels{
+		"controller": controllerIndex,
+	}).Set(float64(controller.CriticalPhysicalDrives))
+
+	if controller.PhysicalDriveCount > 0 {
+		var sedCapable, secured, locked, foreignLocked float64
+		for _, physicalDrive := range controller.PhysicalDrives {
+			Metrics["pd_in_shield_state"].With(prometheus.Labels{
+				"controller": controllerIndex,
+				"enclosure":  physicalDrive.Enclosure,
+				"slot":       physicalDrive.Slot,
+			}).Set(boolToFloat(physicalDrive.InShieldState()))
+
+			// The state is known even when the details are not, e.g.
+			// with the plain text fallback.
+			if !physicalDrive.Detailed {
+				createInfoMetricOfPhysicalDrive(physicalDrive, controllerIndex)
+				continue
+			}
+			createMetricsOfPhysicalDrive(physicalDrive, controllerIndex)
+			if physicalDrive.SED.Capable {
+				sedCapable++
+			}
+			if physicalDrive.SED.Secured {
+				secured++
+			}
+			if physicalDrive.SED.Locked {
+				locked++
+			}
+			if physicalDrive.ForeignLocked() {
+				foreignLocked++
+			}
+		}
+		Metrics["ctrl_sed_capable_drives"].With(prometheus.Labels{
+			"controller": controllerIndex,
+		}).Set(sedCapable)
+		Metrics["ctrl_secured_drives"].With(prometheus.Labels{
+			"controller": controllerIndex,
+		}).Set(secured)
+		Metrics["ctrl_locked_drives"].With(prometheus.Labels{
+			"controller": controllerIndex,
+		}).Set(locked)
+		Metrics["ctrl_locked_foreign_drives"].With(prometheus.Labels{
+			"controller": controllerIndex,
+		}).Set(foreignLocked)
+	}
+}
+
+// handleEvents exports the event counts kept by trackState, which only
+// count every event once however often it's read, and the times of the
+// newest events in the log. Renderings of a single collection without
+// trackState, like snapshot, count the events in the log.
+func handleEvents(events []Event, eventCounts map[string]float64, controllerIndex string) {
+
+	// Every severity is exported so a missing series means the
+	// collector didn't run, not that nothing happened.
+	counts := map[string]float64{}
+	for _, severity := range eventSeverities {
+		counts[severity] = 0
+	}
+	for severity, count := range eventCounts {
+		counts[severity] = count
+	}
+	lastSeen := map[string]Event{}
+	for _, event := range events {
+		if eventCounts == nil {
+			counts[event.Severity()]++
+		}
+		// Events logged before the controller clock was set only
+		// carry seconds since power on and can't be placed in time.
+		if event.HasTime && event.Time.After(lastSeen[event.Severity()].Time) {
+			lastSeen[event.Severity()] = event
+		}
+	}
+
+	for severity, count := range counts {
+		Counters["ctrl_events"].With(prometheus.Labels{
+			"controller": controllerIndex,
+			"severity":   severity,
+		}).Add(count)
+	}
+	for severity, event := range lastSeen {
+		Metrics["ctrl_last_event"].With(prometheus.Labels{
+			"controller": controllerIndex,
+			"severity":   severity,
+		}).Set(float64(event.Time.Unix()))
+		Metrics["ctrl_last_event_corrected"].With(prometheus.Labels{
+			"controller": controllerIndex,
+			"severity":   severity,
+		}).Set(float64(event.CorrectedTime.Unix()))
+	}
+}
+
+func createMetricsOfPhysicalDrive(physicalDrive *PhysicalDriveState, controllerIndex string) {
+
+	labels := prometheus.Labels{
+		"controller": controllerIndex,
+		"enclosure":  physicalDrive.Enclosure,
+		"slot":       physicalDrive.Slot,
+	}
+
+	// The firmware keeps these counts for the life of the drive.
+	addCount(Counters["pd_shield_counter"].With(labels), physicalDrive.ShieldCounter)
+	addCount(Counters["pd_media_errors"].With(labels), physicalDrive.MediaErrors)
+	addCount(Counters["pd_other_errors"].With(labels), physicalDrive.OtherErrors)
+	if physicalDrive.HasCRCErrors {
+		addCount(Counters["pd_crc_errors"].With(labels), physicalDrive.CRCErrors)
+	}
+	addCount(Counters["pd_predictive_errors"].With(labels), physicalDrive.PredictiveErrors)
+	Metrics["pd_smart_alerted"].With(labels).Set(boolToFloat(physicalDrive.SmartAlerted))
+	Metrics["pd_link_speed"].With(labels).Set(physicalDrive.LinkSpeed * 1e9)
+	Metrics["pd_device_speed"].With(labels).Set(physicalDrive.DeviceSpeed * 1e9)
+	Metrics["pd_commissioned_spare"].With(labels).Set(boolToFloat(physicalDrive.CommissionedSpare))
+	Metrics["pd_emergency_spare"].With(labels).Set(boolToFloat(physicalDrive.EmergencySpare))
+	Metrics["pd_sed_capable"].With(labels).Set(boolToFloat(physicalDrive.SED.Capable))
+	Metrics["pd_secured"].With(labels).Set(boolToFloat(physicalDrive.SED.Secured))
+	Metrics["pd_locked"].With(labels).Set(boolToFloat(physicalDrive.SED.Locked))
+	Metrics["pd_foreign_locked"].With(labels).Set(boolToFloat(physicalDrive.ForeignLocked()))
+
+	if physicalDrive.HasLocateStatus {
+		Metrics["pd_locate_active"].With(labels).Set(boolToFloat(physicalDrive.LocateActive))
+	}
+	Counters["pd_firmware_changed"].With(labels).Add(float64(physicalDrive.FirmwareChanges))
+
+	if physicalDrive.HasCertified {
+		Metrics["pd_certified"].With(labels).Set(boolToFloat(physicalDrive.Certified))
+	}
+
+	if physicalDrive.HasLastPatrolRead {
+		Metrics["pd_last_patrol_read"].With(labels).Set(float64(physicalDrive.LastPatrolRead.Unix()))
+	}
+
+	createInfoMetricOfPhysicalDrive(physicalDrive, controllerIndex)
+}
+
+func createInfoMetricOfPhysicalDrive(physicalDrive *PhysicalDriveState, controllerIndex string) {
+	Metrics["pd_info"].With(prometheus.Labels{
+		"controller": controllerIndex,
+		"enclosure":  physicalDrive.Enclosure,
+		"slot":       physicalDrive.Slot,
+		"disk_id":    strconv.Itoa(physicalDrive.DID),
+		"interface":  physicalDrive.Interface,
+		"media":      physicalDrive.Media,
+		"model":      physicalDrive.Model,
+		"DG":         physicalDrive.DriveGroup,
+		"state":      physicalDrive.State,
+		"firmware":   physicalDrive.Firmware,
+		"serial":     physicalDrive.Serial,
+	}).Set(1)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// addCount sets a counter to a count storcli reports. Add panics on
+// negative values, which broken firmware output shouldn't be able to
+// cause, so those are left at zero.
+func addCount(counter prometheus.Counter, count float64) {
+	if count > 0 {
+		counter.Add(count)
+	}
+}
+
+// buildRevision is the commit the collector was built from. Plain go
+// builds of a checkout record it themselves.
+func buildRevision() string {
+	if Revision != "" {
+		return Revision
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeSource = flag.String("time-source", "storcli", "Clock the controller time is compared against: storcli, the system time storcli reports, or host, the current time of this host.")
+
+// System is the normalized view of everything storcli reported. It is
+// built from the raw JSON once and then handed to the metric stage.
+type System struct {
+	// Set when collected from another host, see --ssh-target.
+	Host string `json:"host,omitempty"`
+	// e.g. 007.1017.0000.0000, if storcli printed it.
+	StorcliVersion string             `json:"storcli_version,omitempty"`
+	Controllers    []*ControllerState `json:"controllers"`
+	// Controllers whose output couldn't be read.
+	FailedControllers []int `json:"failed_controllers"`
+	// Seconds the collection waited for --storcli-lock-file.
+	StorcliLockWait float64 `json:"storcli_lock_wait_seconds,omitempty"`
+}
+
+type ControllerState struct {
+	Index           int    `json:"index"`
+	Model           string `json:"model"`
+	Serial          string `json:"serial"`
+	FirmwareVersion string `json:"firmware_version"`
+	DriverName      string `json:"driver_name"`
+	DriverVersion   string `json:"driver_version"`
+	Status          string `json:"status"`
+	// -1 when storcli reports no BBU.
+	BBUStatus   int     `json:"bbu_status"`
+	Temperature float64 `json:"temperature"`
+	Ports       int     `json:"ports"`
+
+	SecuritySupported bool   `json:"security_supported"`
+	SecurityEnabled   bool   `json:"security_enabled"`
+	KeyManagement     string `json:"key_management"`
+
+	PatrolReadReoccurrence string          `json:"patrol_read_reoccurrence"`
+	ScheduledTasks         []ScheduledTask `json:"scheduled_tasks"`
+
+	// Seconds the controller clock is behind the system clock.
+	TimeDifference    float64 `json:"time_difference"`
+	HasTimeDifference bool    `json:"has_time_difference"`
+
+	CacheVaultTemperatures []float64 `json:"cache_vault_temperatures"`
+	BBUTemperatures        []float64 `json:"bbu_temperatures"`
+
+	// Only set when event collection is enabled.
+	Events          []Event `json:"events"`
+	EventsCollected bool    `json:"events_collected"`
+	// Events by severity counted over all collections, set by
+	// trackState.
+	EventCounts map[string]float64 `json:"event_counts,omitempty"`
+
+	// Only set when termlog collection is enabled.
+	TermLogErrors    int  `json:"termlog_errors"`
+	TermLogCollected bool `json:"termlog_collected"`
+
+	// Only collected for HBAs.
+	PhyErrors []PhyErrorCount `json:"phy_errors"`
+
+	DriveGroups        int `json:"drive_groups"`
+	VirtualDriveCount  int `json:"virtual_drive_count"`
+	PhysicalDriveCount int `json:"physical_drive_count"`
+	// From the controller's summary, so they are known even when the
+	// drive lists are not.
+	DegradedVirtualDrives  int                   `json:"degraded_virtual_drives"`
+	OfflineVirtualDrives   int                   `json:"offline_virtual_drives"`
+	CriticalPhysicalDrives int                   `json:"critical_physical_drives"`
+	FailedPhysicalDrives   int                   `json:"failed_physical_drives"`
+	VirtualDrives          []VirtualDriveState   `json:"virtual_drives"`
+	PhysicalDrives         []*PhysicalDriveState `json:"physical_drives"`
+}
+
+// PhyErrorCount is one of the link error counters of an HBA PHY.
+type PhyErrorCount struct {
+	Phy int `json:"phy"`
+	// e.g. invalid_dword, running_disparity
+	Type  string  `json:"type"`
+	Count float64 `json:"count"`
+}
+
+type VirtualDriveState struct {
+	DriveGroup  string `json:"drive_group"`
+	VolumeGroup string `json:"volume_group"`
+	Name        string `json:"name"`
+	Cache       string `json:"cache"`
+	Type        string `json:"type"`
+	State       string `json:"state"`
+}
+
+type PhysicalDriveState struct {
+	Enclosure string `json:"enclosure"`
+	Slot      string `json:"slot"`
+	DID       int    `json:"did"`
+	Interface string `json:"interface"`
+	Media     string `json:"media"`
+	// Capacity as storcli prints it, e.g. 558.375 GB.
+	Size       string `json:"size"`
+	Model      string `json:"model"`
+	DriveGroup string `json:"drive_group"`
+	State      string `json:"state"`
+	// Part of a foreign configuration imported from another controller.
+	Foreign bool `json:"foreign"`
+
+	// False when storcli had no detailed information for the drive,
+	// in which case none of the fields below are set.
+	Detailed bool `json:"detailed"`
+
+	Firmware string `json:"firmware"`
+	Serial   string `json:"serial"`
+	// How often the firmware changed since the drive was first seen.
+	FirmwareChanges int     `json:"firmware_changes"`
+	ShieldCounter   float64 `json:"shield_counter"`
+	MediaErrors     float64 `json:"media_errors"`
+	OtherErrors     float64 `json:"other_errors"`
+	// Interface CRC errors, which point at cabling or the backplane
+	// rather than the drive. Only some firmware counts them separately.
+	CRCErrors         float64  `json:"crc_errors"`
+	HasCRCErrors      bool     `json:"has_crc_errors"`
+	PredictiveErrors  float64  `json:"predictive_errors"`
+	SmartAlerted      bool     `json:"smart_alerted"`
+	LinkSpeed         float64  `json:"link_speed"`
+	DeviceSpeed       float64  `json:"device_speed"`
+	CommissionedSpare bool     `json:"commissioned_spare"`
+	EmergencySpare    bool     `json:"emergency_spare"`
+	SED               SEDState `json:"sed"`
+
+	LocateActive    bool `json:"locate_active"`
+	HasLocateStatus bool `json:"has_locate_status"`
+
+	Certified    bool `json:"certified"`
+	HasCertified bool `json:"has_certified"`
+
+	// Only reported by some firmware.
+	LastPatrolRead    time.Time `json:"last_patrol_read"`
+	HasLastPatrolRead bool      `json:"has_last_patrol_read"`
+}
+
+// SEDState is the self-encrypting drive status of a physical drive.
+type SEDState struct {
+	Capable bool `json:"capable"`
+	Secured bool `json:"secured"`
+	Locked  bool `json:"locked"`
+}
+
+// ForeignLocked reports whether the drive belongs to a foreign
+// configuration and is locked with a key this controller doesn't hold.
+// These look present but can't be imported until the key is supplied.
+func (d *PhysicalDriveState) ForeignLocked() bool {
+	return d.Foreign && d.SED.Locked
+}
+
+// InShieldState reports whether the firmware has shielded the drive to
+// run diagnostics on it, shown as UGShld, HSPShld or CFShld.
+func (d *PhysicalDriveState) InShieldState() bool {
+	return strings.Contains(d.State, "Shld")
+}
+
+// Failed reports whether the drive is out of service.
+func (d *PhysicalDriveState) Failed() bool {
+	switch d.State {
+	case "Failed", "UBad", "Offln":
+		return true
+	}
+	return false
+}
+
+// Optimal reports whether the virtual drive is online with full
+// redundancy.
+func (v VirtualDriveState) Optimal() bool {
+	return v.State == "Optl"
+}
+
+// Degraded reports whether the virtual drive lost some redundancy.
+func (v VirtualDriveState) Degraded() bool {
+	return v.State == "Dgrd" || v.State == "Pdgd"
+}
+
+func (v VirtualDriveState) Offline() bool {
+	return v.State == "OfLn"
+}
+
+// Critical reports whether the drive is predicted to fail soon.
+func (d *PhysicalDriveState) Critical() bool {
+	return d.SmartAlerted || d.PredictiveErrors > 0
+}
+
+// IsMegaraid reports whether the controller is driven by megaraid_sas,
+// or mrsas on FreeBSD, the only drivers the detailed metrics are known
+// to work with.
+func (c *ControllerState) IsMegaraid() bool {
+	return c.DriverName == "megaraid_sas" || c.DriverName == "mrsas"
+}
+
+// Healthy reports whether the controller reports no problems. HBAs say
+// OK where RAID controllers say Optimal.
+func (c *ControllerState) Healthy() bool {
+	return c.Status == "Optimal" || (c.IsHBA() && c.Status == "OK")
+}
+
+// BBUHealthy reports whether the BBU status is one of the good ones.
+// Status 8 is a learn cycle and 4096 a CacheVault without issues.
+func (c *ControllerState) BBUHealthy() bool {
+	return c.BBUStatus == 0 || c.BBUStatus == 8 || c.BBUStatus == 4096
+}
+
+// IsHBA reports whether the controller is an HBA in IT mode, which
+// passes drives through without RAID.
+func (c *ControllerState) IsHBA() bool {
+	return strings.HasPrefix(c.DriverName, "mpt")
+}
+
+func newControllerState(controller Controller) *ControllerState {
+
+	data := controller.ResponseData
+	state := &ControllerState{
+		Index:              data.Basics.Controller.Value,
+		Model:              data.Basics.Model,
+		Serial:             data.Basics.SerialNumber,
+		FirmwareVersion:    data.Version.FirmwareVersion,
+		DriverName:         data.Version.DriverName,
+		DriverVersion:      data.Version.DriverVersion,
+		Status:             data.Status.ControllerStatus,
+		BBUStatus:          data.Status.BBUStatus.Value,
+		Ports:              data.HwCfg.BackendPortCount.Value,
+		DriveGroups:        data.DriveGroups.Value,
+		VirtualDriveCount:  data.VirtualDrives.Value,
+		PhysicalDriveCount: data.PhysicalDrives.Value,
+
+		DegradedVirtualDrives:  data.DevicePresent.Degraded.Value,
+		OfflineVirtualDrives:   data.DevicePresent.Offline.Value,
+		CriticalPhysicalDrives: data.DevicePresent.CriticalDisks.Value,
+		FailedPhysicalDrives:   data.DevicePresent.FailedDisks.Value,
+	}
+
+	state.PatrolReadReoccurrence, _ = data.ScheduledTasks["Patrol Read Reoccurrence"].(string)
+	state.ScheduledTasks = parseScheduledTasks(data.ScheduledTasks)
+
+	if !data.Status.BBUStatus.Valid {
+		state.BBUStatus = -1
+	}
+
+	if data.HwCfg.ROCTempCelsius.Value > 0 {
+		state.Temperature = float64(data.HwCfg.ROCTempCelsius.Value)
+	}
+
+	state.SecuritySupported = data.SupportedAdapterOperations.SupportSecurity == "Yes"
+	state.SecurityEnabled = data.Status.SecurityKeyAssigned == "Yes" || data.Status.LockKeyAssigned == "Yes"
+
+	// Which key source is active is only in show securitykey, which
+	// collectController reads. Supporting EKM doesn't mean using it.
+	state.KeyManagement = "none"
+	if state.SecurityEnabled {
+		state.KeyManagement = "unknown"
+	}
+
+	for _, cvinfo := range data.CachevaultInfo {
+		tempString := strings.Replace(cvinfo.Temp, "C", "", 1)
+		temperature, _ := strconv.ParseFloat(tempString, 64)
+		state.CacheVaultTemperatures = append(state.CacheVaultTemperatures, temperature)
+	}
+
+	for _, bbuinfo := range data.BBUInfo {
+		tempString := strings.Replace(bbuinfo.Temp, "C", "", 1)
+		temperature, _ := strconv.ParseFloat(tempString, 64)
+		state.BBUTemperatures = append(state.BBUTemperatures, temperature)
+	}
+
+	timefmt := "01/02/2006, 15:04:05"
+
+	// Some firmware reports a stale system time, which makes the skew
+	// against it meaningless. Both dates are in storcli's time zone.
+	if *timeSource == "host" {
+		if data.Basics.ControllerDate != "" {
+			controllerDateTime, err := time.ParseInLocation(timefmt, data.Basics.ControllerDate, storcliLocation)
+			if err == nil {
+				state.TimeDifference = float64(time.Now().Unix() - controllerDateTime.Unix())
+				state.HasTimeDifference = true
+			}
+		}
+	} else if data.Basics.ControllerDate != "" && data.Basics.SystemDate != "" {
+		controllerDateTime, conErr := time.ParseInLocation(timefmt, data.Basics.ControllerDate, storcliLocation)
+		systemDateTime, sysErr := time.ParseInLocation(timefmt, data.Basics.SystemDate, storcliLocation)
+		if conErr == nil && sysErr == nil {
+			state.TimeDifference = float64(systemDateTime.Unix() - controllerDateTime.Unix())
+			state.HasTimeDifference = true
+		}
+	}
+
+	for _, virtualDrive := range data.VDList {
+		var driveGroup string = "-1"
+		var volumeGroup string = "-1"
+		if virtualDrive.DG_VD != "" {
+			groups := strings.Split(virtualDrive.DG_VD, "/")
+			driveGroup = groups[0]
+			volumeGroup = groups[1]
+		}
+		state.VirtualDrives = append(state.VirtualDrives, VirtualDriveState{
+			DriveGroup:  driveGroup,
+			VolumeGroup: volumeGroup,
+			Name:        virtualDrive.Name,
+			Cache:       virtualDrive.Cache,
+			Type:        virtualDrive.Type,
+			State:       virtualDrive.State,
+		})
+	}
+
+	return state
+}
+
+// Where firmware reports the interface CRC errors of a drive.
+var crcErrorKeys = []string{
+	"Interface CRC Error Count",
+	"CRC Error Count",
+	"SATA CRC Error Count",
+}
+
+// Where firmware reports the last patrol read of a drive.
+var lastPatrolReadKeys = []string{
+	"Last Patrol Read Completion",
+	"Last Patrol Read Completed",
+	"Last PR Completion",
+}
+
+// driveName splits the EID:Slt of a drive and returns the name storcli
+// gives its detailed information. Drives attached without an enclosure
+// only have a slot, storcli prints their EID:Slt as " :3".
+func driveName(eidSlt string, controllerIndex int) (enclosure string, slot string, name string) {
+	enclosure, slot, found := strings.Cut(eidSlt, ":")
+	if !found {
+		slot = enclosure
+	}
+	enclosure = strings.TrimSpace(enclosure)
+	if !found || enclosure == "" {
+		return "", slot, fmt.Sprintf("Drive /c%d/s%s", controllerIndex, slot)
+	}
+	return enclosure, slot, fmt.Sprintf("Drive /c%d/e%s/s%s", controllerIndex, enclosure, slot)
+}
+
+// normalizeDriveModel removes the spaces storcli pads drive models with,
+// and those between vendor and model, the same way for every backend.
+func normalizeDriveModel(model string) string {
+	return strings.Replace(model, " ", "", -1)
+}
+
+func newPhysicalDriveState(physicalDrive PhysicalDrive, detailedInfoArray map[string]interface{}, controllerIndex int) *PhysicalDriveState {
+
+	enclosure, slot, driveIdentifier := driveName(physicalDrive.EIDSlt, controllerIndex)
+
+	// Because sometimes it's not part of a device group.
+	dgFixed := string(physicalDrive.DG)
+
+	drive := &PhysicalDriveState{
+		Enclosure:  enclosure,
+		Slot:       slot,
+		DID:        physicalDrive.DID.Value,
+		Interface:  physicalDrive.Intf,
+		Media:      physicalDrive.Med,
+		Size:       physicalDrive.Size,
+		Model:      normalizeDriveModel(physicalDrive.Model),
+		DriveGroup: dgFixed,
+		State:      physicalDrive.State,
+		Foreign:    dgFixed == "F",
+	}
+
+	var info map[string]interface{}
+	switch detailedInfoArray[driveIdentifier+" - Detailed Information"].(type) {
+	case map[string]interface{}:
+		info = detailedInfoArray[driveIdentifier+" - Detailed Information"].(map[string]interface{})
+	default:
+		return drive
+	}
+	// NVMe drives and old firmware leave out fields, so nothing is
+	// taken for granted. Missing values stay at zero.
+	details := &driveDetails{}
+	state := details.section(info, driveIdentifier+" State")
+	attributes := details.section(info, driveIdentifier+" Device attributes")
+	settings := details.section(info, driveIdentifier+" Policies/Settings")
+
+	drive.Detailed = true
+	drive.ShieldCounter = details.float(state, "Shield Counter")
+	drive.MediaErrors = details.float(state, "Media Error Count")
+	drive.OtherErrors = details.float(state, "Other Error Count")
+	drive.PredictiveErrors = details.float(state, "Predictive Failure Count")
+	drive.SmartAlerted = details.string(state, "S.M.A.R.T alert flagged by drive") == "Yes"
+
+	linkSpeedAttr := strings.Split(details.string(attributes, "Link Speed"), ".")
+	drive.LinkSpeed, _ = strconv.ParseFloat(linkSpeedAttr[0], 64)
+	deviceSpeedAttr := strings.Split(details.string(attributes, "Device Speed"), ".")
+	drive.DeviceSpeed, _ = strconv.ParseFloat(deviceSpeedAttr[0], 64)
+
+	drive.CommissionedSpare = details.string(settings, "Commissioned Spare") == "Yes"
+	drive.EmergencySpare = details.string(settings, "Emergency Spare") == "Yes"
+
+	// Older firmware doesn't report these at all, so compare
+	// without asserting the type.
+	drive.SED = SEDState{
+		Capable: settings["SED Capable"] == "Yes",
+		Secured: settings["Secured"] == "Yes",
+		Locked:  settings["Locked"] == "Yes",
+	}
+
+	// Only some firmware reports the locate LED, and the key has moved
+	// between sections. Don't export a misleading 0 when it's missing.
+	for _, section := range []map[string]interface{}{state, settings} {
+		if locate, ok := section["Locate"].(string); ok {
+			switch locate {
+			case "On", "Yes", "Active":
+				drive.LocateActive = true
+			}
+			drive.HasLocateStatus = true
+			break
+		}
+	}
+
+	if certified, ok := settings["Certified"].(string); ok {
+		drive.Certified = certified == "Yes"
+		drive.HasCertified = true
+	}
+
+	for _, section := range []map[string]interface{}{state, attributes} {
+		for _, key := range crcErrorKeys {
+			if count, ok := section[key].(float64); ok {
+				drive.CRCErrors = count
+				drive.HasCRCErrors = true
+			}
+		}
+	}
+
+	// A drive that patrol read keeps skipping stops advancing here.
+	for _, section := range []map[string]interface{}{state, attributes, settings} {
+		for _, key := range lastPatrolReadKeys {
+			value, ok := section[key].(string)
+			if !ok {
+				continue
+			}
+			completed, err := time.ParseInLocation("01/02/2006, 15:04:05", value, storcliLocation)
+			if err == nil {
+				drive.LastPatrolRead = completed
+				drive.HasLastPatrolRead = true
+			}
+		}
+	}
+
+	drive.Firmware = strings.Replace(details.string(attributes, "Firmware Revision"), " ", "", -1)
+	drive.Serial = strings.Replace(details.string(attributes, "SN"), " ", "", -1)
+
+	if len(details.missing) > 0 {
+		log.Printf("%s is missing %s", driveIdentifier, strings.Join(details.missing, ", "))
+	}
+
+	return drive
+}
+
+// driveDetails reads the detailed information of a drive and remembers
+// which of the expected fields storcli didn't include.
+type driveDetails struct {
+	missing []string
+}
+
+func (d *driveDetails) section(info map[string]interface{}, key string) map[string]interface{} {
+	section, ok := info[key].(map[string]interface{})
+	if !ok {
+		d.missing = append(d.missing, strconv.Quote(key))
+		return map[string]interface{}{}
+	}
+	return section
+}
+
+func (d *driveDetails) float(section map[string]interface{}, key string) float64 {
+	value, ok := section[key].(float64)
+	if !ok {
+		d.missing = append(d.missing, strconv.Quote(key))
+	}
+	return value
+}
+
+func (d *driveDetails) string(section map[string]interface{}, key string) string {
+	value, ok := section[key].(string)
+	if !ok {
+		d.missing = append(d.missing, strconv.Quote(key))
+	}
+	return value
+}
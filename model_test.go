@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSecurityEnabled(t *testing.T) {
+
+	tests := []struct {
+		status  string
+		enabled bool
+	}{
+		{`"Security Key Assigned": "Yes"`, true},
+		{`"Lock Key Assigned": "Yes"`, true},
+		{`"Lock Key Assigned": "No", "Security Key Assigned": "Yes"`, true},
+		{`"Lock Key Assigned": "Yes", "Security Key Assigned": "No"`, true},
+		{`"Lock Key Assigned": "No", "Security Key Assigned": "No"`, false},
+		{`"Controller Status": "Optimal"`, false},
+	}
+
+	for _, test := range tests {
+		data := fmt.Sprintf(`{"Controllers": [{"Command Status": {"Status": "Success"}, "Response Data": {"Status": {%s}}}]}`, test.status)
+		controllers, err := parseControllers([]byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.status, err)
+		}
+		state := newControllerState(controllers.Controllers[0])
+		if state.SecurityEnabled != test.enabled {
+			t.Errorf("%s: got SecurityEnabled %v, want %v", test.status, state.SecurityEnabled, test.enabled)
+		}
+	}
+}
+
+func TestTimeDifference(t *testing.T) {
+
+	tests := []struct {
+		controller string
+		system     string
+		difference float64
+		found      bool
+	}{
+		{"10/16/2026, 12:00:05", "10/16/2026, 12:00:00", -5, true},
+		{"10/16/2026, 11:59:00", "10/16/2026, 12:00:00", 60, true},
+		{"10/16/2026, 12:00:05", "not a date", 0, false},
+		{"not a date", "10/16/2026, 12:00:00", 0, false},
+		{"10/16/2026, 12:00:05", "", 0, false},
+	}
+
+	for _, test := range tests {
+		data := fmt.Sprintf(`{"Controllers": [{"Command Status": {"Status": "Success"}, "Response Data": {"Basics": {
+			"Current Controller Date/Time": %q, "Current System Date/time": %q}}}]}`, test.controller, test.system)
+		controllers, err := parseControllers([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := newControllerState(controllers.Controllers[0])
+		if state.HasTimeDifference != test.found || state.TimeDifference != test.difference {
+			t.Errorf("controller %q, system %q: got %v, %v, want %v, %v", test.controller, test.system,
+				state.TimeDifference, state.HasTimeDifference, test.difference, test.found)
+		}
+	}
+}
+
+func TestDriveName(t *testing.T) {
+
+	tests := []struct {
+		eidSlt    string
+		enclosure string
+		slot      string
+		name      string
+	}{
+		{"252:3", "252", "3", "Drive /c0/e252/s3"},
+		{" :3", "", "3", "Drive /c0/s3"},
+		{":3", "", "3", "Drive /c0/s3"},
+		{"3", "", "3", "Drive /c0/s3"},
+	}
+
+	for _, test := range tests {
+		enclosure, slot, name := driveName(test.eidSlt, 0)
+		if enclosure != test.enclosure || slot != test.slot || name != test.name {
+			t.Errorf("%q: got %q, %q, %q, want %q, %q, %q", test.eidSlt, enclosure, slot, name,
+				test.enclosure, test.slot, test.name)
+		}
+	}
+}
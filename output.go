@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Writer delivers a gathered set of metric families to an output.
+// Writers register themselves from init() and declare their own flags,
+// so a new output only needs its own file.
+type Writer interface {
+	// Enabled reports whether the writer was requested on the command line.
+	Enabled() bool
+	Write(families []*dto.MetricFamily) error
+}
+
+// DefaultWriter is used when no other writer is enabled.
+const DefaultWriter = "stdout"
+
+var writers = map[string]Writer{}
+
+func RegisterWriter(name string, writer Writer) {
+	if _, exists := writers[name]; exists {
+		panic(fmt.Sprintf("writer %q registered twice", name))
+	}
+	writers[name] = writer
+}
+
+// enabledWriters returns the writers requested on the command line in
+// name order, falling back to DefaultWriter.
+func enabledWriters() []Writer {
+	var names []string
+	for name, writer := range writers {
+		if writer.Enabled() {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, DefaultWriter)
+	}
+	sort.Strings(names)
+
+	var enabled []Writer
+	for _, name := range names {
+		enabled = append(enabled, writers[name])
+	}
+	return enabled
+}
+
+// Encoder renders metric families in an output format. Writers decide
+// where the result goes, encoders decide what it looks like.
+type Encoder func(w io.Writer, families []*dto.MetricFamily) error
+
+var OutputEncoder Encoder = encodeOpenMetrics
+
+var outputFormat = flag.String("format", DefaultFormat, "Output format of standard output and files: "+DefaultFormat+" or one of the others in the README.")
+
+// DefaultFormat is what node_exporter's textfile collector reads.
+const DefaultFormat = "openmetrics"
+
+var encoders = map[string]Encoder{
+	DefaultFormat: encodeOpenMetrics,
+}
+
+func RegisterEncoder(name string, encoder Encoder) {
+	if _, exists := encoders[name]; exists {
+		panic(fmt.Sprintf("encoder %q registered twice", name))
+	}
+	encoders[name] = encoder
+}
+
+// selectEncoder sets OutputEncoder from --format.
+func selectEncoder() error {
+	encoder, found := encoders[*outputFormat]
+	if !found {
+		return fmt.Errorf("unknown --format %q", *outputFormat)
+	}
+	OutputEncoder = encoder
+	return nil
+}
+
+// isExposition reports whether the output is the Prometheus text format,
+// which the textfile collector reads and --self-check understands.
+func isExposition() bool {
+	return *outputFormat == DefaultFormat
+}
+
+// The old gauge names of --legacy-names, like megaraid_pd_media_errors,
+// are the family names OpenMetrics gives the counters that replaced
+// them. With them the classic text format is written instead, where
+// both fit and which the textfile collector reads as well.
+func encodeOpenMetrics(w io.Writer, families []*dto.MetricFamily) error {
+	for _, metric := range families {
+		var err error
+		if *legacyNames {
+			_, err = expfmt.MetricFamilyToText(w, metric)
+		} else {
+			_, err = expfmt.MetricFamilyToOpenMetrics(w, metric)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printMetrics(families []*dto.MetricFamily) ([]byte, error) {
+
+	buf := new(bytes.Buffer)
+	if err := OutputEncoder(buf, families); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
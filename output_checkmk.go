@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func init() {
+	RegisterEncoder("checkmk", encodeCheckmk)
+}
+
+// checkmkService is a controller, VD or drive as a Checkmk service.
+type checkmkService struct {
+	Name     string
+	Text     string
+	Severity Severity
+	Rules    []string
+}
+
+// encodeCheckmk writes a Checkmk local check line per controller, VD and
+// drive. Their state is the worst health finding about them, taken from
+// megaraid_health_finding so it matches every other output.
+func encodeCheckmk(w io.Writer, families []*dto.MetricFamily) error {
+
+	services := map[string]*checkmkService{}
+	service := func(object string) *checkmkService {
+		if services[object] == nil {
+			services[object] = &checkmkService{Name: "RAID " + checkmkKind(object) + " " + object}
+		}
+		return services[object]
+	}
+
+	for _, family := range families {
+		name := strings.TrimPrefix(family.GetName(), Namespace+"_")
+		for _, sample := range family.Metric {
+			labels := map[string]string{}
+			for _, label := range sample.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			controller := "/c" + labels["controller"]
+
+			switch name {
+			case "controller_info":
+				service(controller).Text = fmt.Sprintf("%s, firmware %s", labels["model"], labels["fwversion"])
+			case "vd_info":
+				service(controller + "/v" + labels["VG"]).Text = fmt.Sprintf("%s, %s, %s", labels["name"], labels["type"], labels["state"])
+			case "pd_info":
+				service(physicalDriveObjectOfLabels(labels)).Text = fmt.Sprintf("%s, %s", labels["model"], labels["state"])
+			case "health_finding":
+				found := service(labels["object"])
+				severity, _ := parseSeverity(labels["severity"])
+				if severity > found.Severity {
+					found.Severity = severity
+				}
+				found.Rules = append(found.Rules, labels["rule"])
+			}
+		}
+	}
+
+	objects := make([]string, 0, len(services))
+	for object := range services {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+
+	for _, object := range objects {
+		found := services[object]
+		text := found.Text
+		if len(found.Rules) > 0 {
+			sort.Strings(found.Rules)
+			text = strings.TrimPrefix(text+", "+strings.Join(found.Rules, ", "), ", ")
+		}
+		if _, err := fmt.Fprintf(w, "%d \"%s\" - %s\n", found.Severity, found.Name, text); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkmkKind names the type of object a storcli address like /c0/v1
+// points at, by its last part. Drives without an enclosure are /cN/sS.
+func checkmkKind(object string) string {
+	switch {
+	case strings.HasPrefix(path.Base(object), "v"):
+		return "VD"
+	case strings.HasPrefix(path.Base(object), "s"):
+		return "PD"
+	}
+	return "Controller"
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestCheckmkKind(t *testing.T) {
+
+	tests := map[string]string{
+		"/c0":         "Controller",
+		"/c0/v1":      "VD",
+		"/c0/e252/s4": "PD",
+		"/c0/s3":      "PD",
+		"/c1/e8/s12":  "PD",
+	}
+	for object, kind := range tests {
+		if got := checkmkKind(object); got != kind {
+			t.Errorf("checkmkKind(%q) = %q, want %q", object, got, kind)
+		}
+	}
+}
+
+func TestPhysicalDriveObjectOfLabels(t *testing.T) {
+
+	tests := []struct {
+		labels map[string]string
+		object string
+	}{
+		{map[string]string{"controller": "0", "enclosure": "252", "slot": "4"}, "/c0/e252/s4"},
+		{map[string]string{"controller": "1", "enclosure": "", "slot": "3"}, "/c1/s3"},
+	}
+	for _, test := range tests {
+		if got := physicalDriveObjectOfLabels(test.labels); got != test.object {
+			t.Errorf("physicalDriveObjectOfLabels(%v) = %q, want %q", test.labels, got, test.object)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func init() {
+	RegisterEncoder("collectd", encodeCollectd)
+}
+
+// Slashes separate the parts of an identifier and a dash the plugin or
+// type from its instance.
+var collectdUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.]`)
+
+// encodeCollectd writes PUTVAL commands for collectd's exec plugin. The
+// plugin is megaraid with the controller as instance, e.g.
+// host/megaraid-c0/gauge-pd_temperature_celsius_252_4. Counters use the
+// derive type so collectd turns them into rates.
+func encodeCollectd(w io.Writer, families []*dto.MetricFamily) error {
+
+	host := collectdHostname()
+	interval := ""
+	if seconds, found := collectdIntervalSeconds(); found {
+		interval = " interval=" + strconv.FormatFloat(seconds, 'f', -1, 64)
+	}
+	timestamp := time.Now().Unix()
+
+	for _, family := range families {
+		kind := "gauge"
+		if family.GetType() == dto.MetricType_COUNTER {
+			kind = "derive"
+		}
+		name := strings.TrimPrefix(family.GetName(), Namespace+"_")
+
+		for _, sample := range family.Metric {
+			value := sampleValue(sample)
+			if value == nil {
+				continue
+			}
+
+			plugin := "megaraid"
+			instance := []string{collectdUnsafe.ReplaceAllString(name, "_")}
+			for _, label := range sample.Label {
+				if label.GetName() == "controller" {
+					plugin += "-c" + collectdUnsafe.ReplaceAllString(label.GetValue(), "_")
+					continue
+				}
+				if label.GetValue() != "" {
+					instance = append(instance, collectdUnsafe.ReplaceAllString(label.GetValue(), "_"))
+				}
+			}
+
+			formatted := strconv.FormatFloat(*value, 'g', -1, 64)
+			if kind == "derive" {
+				formatted = strconv.FormatInt(int64(*value), 10)
+			}
+			_, err := fmt.Fprintf(w, "PUTVAL \"%s/%s/%s-%s\"%s %d:%s\n", host, plugin, kind, strings.Join(instance, "_"), interval, timestamp, formatted)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// collectdHostname is the host collectd passes to exec plugins, or the
+// local one when run by hand.
+func collectdHostname() string {
+	host := os.Getenv("COLLECTD_HOSTNAME")
+	if host == "" {
+		var err error
+		if host, err = os.Hostname(); err != nil {
+			host = "localhost"
+		}
+	}
+	return strings.NewReplacer("/", "_", `"`, "_").Replace(host)
+}
+
+// collectdIntervalSeconds is the interval collectd passes to exec
+// plugins.
+func collectdIntervalSeconds() (float64, bool) {
+	seconds, err := strconv.ParseFloat(os.Getenv("COLLECTD_INTERVAL"), 64)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	return seconds, true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestEncodeCollectd(t *testing.T) {
+
+	tests := []struct {
+		hostname string
+		interval string
+		want     string
+	}{
+		{"db1", "60", `PUTVAL "db1/megaraid-c0/gauge-controller_info_PERC_H730P_Mini" interval=60 T:1
+PUTVAL "db1/megaraid-c0/gauge-temperature" interval=60 T:52.5
+PUTVAL "db1/megaraid-c0/derive-pd_media_errors_total_252_4" interval=60 T:3
+PUTVAL "db1/megaraid-c0/derive-pd_media_errors_total_3" interval=60 T:0
+`},
+		// Slashes and quotes would break the identifier.
+		{`db/"1"`, "", `PUTVAL "db__1_/megaraid-c0/gauge-controller_info_PERC_H730P_Mini" T:1
+PUTVAL "db__1_/megaraid-c0/gauge-temperature" T:52.5
+PUTVAL "db__1_/megaraid-c0/derive-pd_media_errors_total_252_4" T:3
+PUTVAL "db__1_/megaraid-c0/derive-pd_media_errors_total_3" T:0
+`},
+	}
+
+	timestamps := regexp.MustCompile(` \d{10}:`)
+	for _, test := range tests {
+		t.Setenv("COLLECTD_HOSTNAME", test.hostname)
+		t.Setenv("COLLECTD_INTERVAL", test.interval)
+
+		var buf bytes.Buffer
+		if err := encodeCollectd(&buf, testFamilies()); err != nil {
+			t.Fatal(err)
+		}
+		if got := timestamps.ReplaceAllString(buf.String(), " T:"); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.hostname, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultTextfileName is used when --outfile points at a directory, such
+// as the node_exporter textfile collector directory.
+const DefaultTextfileName = "megaraid.prom"
+
+type FileWriter struct {
+	path *string
+}
+
+func init() {
+	RegisterWriter("file", FileWriter{
+		path: flag.String("outfile", "", "Text file to write output to, or a directory to write "+DefaultTextfileName+" into. Defaults to standard output."),
+	})
+}
+
+func (w FileWriter) Enabled() bool {
+	return *w.path != ""
+}
+
+func (w FileWriter) Write(families []*dto.MetricFamily) error {
+
+	path := *w.path
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, DefaultTextfileName)
+	}
+	if isExposition() && !strings.HasSuffix(path, ".prom") {
+		log.Printf("%s doesn't end in .prom, node_exporter's textfile collector will ignore it", path)
+	}
+
+	mtime, err := textfileMtime(time.Now())
+	if err != nil {
+		return err
+	}
+	families = append(families, mtime)
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+
+	output, err := printMetrics(families)
+	if err != nil {
+		return err
+	}
+	if *selfCheck && isExposition() {
+		if err := checkExposition(output); err != nil {
+			return fmt.Errorf("self-check failed, not writing %s: %w", path, err)
+		}
+	}
+	return writeFileAtomic(path, output, 0644)
+}
+
+// textfileMtime records when the file was written, so stale output from a
+// broken cron job can be alerted on.
+func textfileMtime(now time.Time) (*dto.MetricFamily, error) {
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "textfile_mtime_seconds",
+		Help:      "Unix time the MegaRAID textfile was written",
+	})
+	gauge.Set(float64(now.Unix()))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(gauge)
+	families, err := reg.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	return families[0], nil
+}
+
+// writeFileAtomic writes to a temporary file next to path and renames it
+// into place, so readers see either the old or the new file but never a
+// partial one. The temporary name doesn't end in .prom, which keeps the
+// textfile collector from picking it up.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
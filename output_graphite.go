@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var graphitePrefix = flag.String("graphite-prefix", "hw.megaraid", "First path components of the Graphite metrics.")
+
+type GraphiteWriter struct {
+	address *string
+}
+
+func init() {
+	RegisterEncoder("graphite", encodeGraphite)
+	RegisterWriter("graphite", GraphiteWriter{
+		address: flag.String("graphite-address", "", "Send metrics to this Carbon plaintext receiver, e.g. carbon:2003, whatever --format is."),
+	})
+}
+
+// Anything else would start a new path component or break the line.
+var graphiteUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// graphitePath turns a series into a dotted path: the prefix, the metric
+// name without the namespace, then name and value of every label,
+// sorted by name.
+func graphitePath(name string, labels []*dto.LabelPair) string {
+
+	parts := []string{*graphitePrefix, graphiteUnsafe.ReplaceAllString(strings.TrimPrefix(name, Namespace+"_"), "_")}
+
+	sorted := append([]*dto.LabelPair{}, labels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetName() < sorted[j].GetName()
+	})
+	for _, label := range sorted {
+		value := label.GetValue()
+		if value == "" {
+			value = "none"
+		}
+		parts = append(parts, label.GetName(), graphiteUnsafe.ReplaceAllString(value, "_"))
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func encodeGraphite(w io.Writer, families []*dto.MetricFamily) error {
+
+	timestamp := time.Now().Unix()
+	for _, family := range families {
+		for _, sample := range family.Metric {
+			value := sampleValue(sample)
+			if value == nil {
+				continue
+			}
+			_, err := fmt.Fprintf(w, "%s %s %d\n", graphitePath(family.GetName(), sample.Label), strconv.FormatFloat(*value, 'g', -1, 64), timestamp)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w GraphiteWriter) Enabled() bool {
+	return *w.address != ""
+}
+
+func (w GraphiteWriter) Write(families []*dto.MetricFamily) error {
+
+	var buf bytes.Buffer
+	if err := encodeGraphite(&buf, families); err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", *w.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
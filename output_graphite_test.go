@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEncodeGraphite(t *testing.T) {
+
+	var buf bytes.Buffer
+	if err := encodeGraphite(&buf, testFamilies()); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamps := regexp.MustCompile(`(?m) \d{10}$`)
+	want := `hw.megaraid.controller_info.controller.0.model.PERC_H730P_Mini 1 T
+hw.megaraid.temperature.controller.0 52.5 T
+hw.megaraid.pd_media_errors_total.controller.0.enclosure.252.slot.4 3 T
+hw.megaraid.pd_media_errors_total.controller.0.enclosure.none.slot.3 0 T
+`
+	if got := timestamps.ReplaceAllString(buf.String(), " T"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGraphitePath(t *testing.T) {
+
+	defer func(prefix string) { *graphitePrefix = prefix }(*graphitePrefix)
+	*graphitePrefix = "servers.db1.raid"
+
+	label := func(name, value string) *dto.LabelPair {
+		return &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)}
+	}
+	tests := []struct {
+		name   string
+		labels []*dto.LabelPair
+		path   string
+	}{
+		{"megaraid_ctrl_count", nil, "servers.db1.raid.ctrl_count"},
+		{"megaraid_pd_info", []*dto.LabelPair{label("slot", "4"), label("controller", "0")}, "servers.db1.raid.pd_info.controller.0.slot.4"},
+		{"megaraid_vd_info", []*dto.LabelPair{label("name", "os.boot/1")}, "servers.db1.raid.vd_info.name.os_boot_1"},
+		{"megaraid_pd_info", []*dto.LabelPair{label("enclosure", "")}, "servers.db1.raid.pd_info.enclosure.none"},
+	}
+
+	for _, test := range tests {
+		if path := graphitePath(test.name, test.labels); path != test.path {
+			t.Errorf("%s %v: got %q, want %q", test.name, test.labels, path, test.path)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func init() {
+	RegisterEncoder("influx", encodeInflux)
+}
+
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// encodeInflux writes InfluxDB line protocol, as Telegraf's exec input
+// expects it. Every metric is a measurement with its labels as tags and
+// a single value field, all with the same timestamp.
+func encodeInflux(w io.Writer, families []*dto.MetricFamily) error {
+
+	timestamp := time.Now().UnixNano()
+	for _, family := range families {
+		measurement := influxMeasurementEscaper.Replace(family.GetName())
+		for _, sample := range family.Metric {
+			value := sampleValue(sample)
+			if value == nil {
+				continue
+			}
+
+			var tags []string
+			for _, label := range sample.Label {
+				// Line protocol has no empty tag values.
+				if label.GetValue() == "" {
+					continue
+				}
+				tags = append(tags, influxTagEscaper.Replace(label.GetName())+"="+influxTagEscaper.Replace(label.GetValue()))
+			}
+			sort.Strings(tags)
+
+			line := measurement
+			if len(tags) > 0 {
+				line += "," + strings.Join(tags, ",")
+			}
+			_, err := fmt.Fprintf(w, "%s value=%s %d\n", line, strconv.FormatFloat(*value, 'g', -1, 64), timestamp)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestEncodeInflux(t *testing.T) {
+
+	var buf bytes.Buffer
+	if err := encodeInflux(&buf, testFamilies()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every line has the same nanosecond timestamp.
+	timestamps := regexp.MustCompile(`(?m) \d{19}$`)
+	if n := len(timestamps.FindAllString(buf.String(), -1)); n != 4 {
+		t.Errorf("got %d lines with a timestamp, want 4", n)
+	}
+	want := `megaraid_controller_info,controller=0,model=PERC\ H730P\ Mini value=1 T
+megaraid_temperature,controller=0 value=52.5 T
+megaraid_pd_media_errors_total,controller=0,enclosure=252,slot=4 value=3 T
+megaraid_pd_media_errors_total,controller=0,slot=3 value=0 T
+`
+	if got := timestamps.ReplaceAllString(buf.String(), " T"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInfluxEscaping(t *testing.T) {
+
+	tests := []struct {
+		tag     string
+		escaped string
+	}{
+		{"os", "os"},
+		{"os boot", `os\ boot`},
+		{"a,b", `a\,b`},
+		{"a=b", `a\=b`},
+	}
+
+	for _, test := range tests {
+		if escaped := influxTagEscaper.Replace(test.tag); escaped != test.escaped {
+			t.Errorf("%q: got %q, want %q", test.tag, escaped, test.escaped)
+		}
+	}
+	if escaped := influxMeasurementEscaper.Replace("a b,c=d"); escaped != `a\ b\,c=d` {
+		t.Errorf("measurement escaped as %q", escaped)
+	}
+}
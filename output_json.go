@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func init() {
+	RegisterEncoder("json", encodeJSON)
+}
+
+// JSONMetric is a metric family in --format=json, for scripts and
+// configuration management that would rather not parse the text format.
+type JSONMetric struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Samples []JSONSample `json:"samples"`
+}
+
+type JSONSample struct {
+	Labels map[string]string `json:"labels"`
+	// Null for NaN and infinite values, which JSON can't express.
+	Value *float64 `json:"value"`
+}
+
+func encodeJSON(w io.Writer, families []*dto.MetricFamily) error {
+
+	metrics := []JSONMetric{}
+	for _, family := range families {
+		metric := JSONMetric{
+			Name:    family.GetName(),
+			Help:    family.GetHelp(),
+			Type:    strings.ToLower(family.GetType().String()),
+			Samples: []JSONSample{},
+		}
+		for _, sample := range family.Metric {
+			labels := map[string]string{}
+			for _, label := range sample.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			metric.Samples = append(metric.Samples, JSONSample{
+				Labels: labels,
+				Value:  sampleValue(sample),
+			})
+		}
+		metrics = append(metrics, metric)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(metrics)
+}
+
+// sampleValue is the value of a gauge or counter, the only types the
+// collector exports.
+func sampleValue(sample *dto.Metric) *float64 {
+	value := sample.GetGauge().GetValue()
+	if sample.Counter != nil {
+		value = sample.GetCounter().GetValue()
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return &value
+}
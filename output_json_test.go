@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// testFamilies are metrics as gatherMetrics returns them, with what the
+// encoders have to take care of: an info metric, a counter, label values
+// with spaces or none at all and a value that isn't a number.
+func testFamilies() []*dto.MetricFamily {
+
+	labels := func(pairs ...string) []*dto.LabelPair {
+		var labels []*dto.LabelPair
+		for i := 0; i < len(pairs); i += 2 {
+			labels = append(labels, &dto.LabelPair{Name: proto.String(pairs[i]), Value: proto.String(pairs[i+1])})
+		}
+		return labels
+	}
+	gauge := func(value float64, pairs ...string) *dto.Metric {
+		return &dto.Metric{Label: labels(pairs...), Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	}
+	counter := func(value float64, pairs ...string) *dto.Metric {
+		return &dto.Metric{Label: labels(pairs...), Counter: &dto.Counter{Value: proto.Float64(value)}}
+	}
+
+	return []*dto.MetricFamily{
+		{
+			Name:   proto.String("megaraid_controller_info"),
+			Help:   proto.String("MegaRAID controller info"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{gauge(1, "controller", "0", "model", "PERC H730P Mini")},
+		},
+		{
+			Name: proto.String("megaraid_temperature"),
+			Help: proto.String("MegaRAID controller temperature"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				gauge(52.5, "controller", "0"),
+				gauge(math.NaN(), "controller", "1"),
+			},
+		},
+		{
+			Name: proto.String("megaraid_pd_media_errors_total"),
+			Help: proto.String("MegaRAID physical drive media errors"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				counter(3, "controller", "0", "enclosure", "252", "slot", "4"),
+				counter(0, "controller", "0", "enclosure", "", "slot", "3"),
+			},
+		},
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, testFamilies()); err != nil {
+		t.Fatal(err)
+	}
+	var metrics []JSONMetric
+	if err := json.Unmarshal(buf.Bytes(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	value := func(v float64) *float64 { return &v }
+	want := []JSONMetric{
+		{"megaraid_controller_info", "MegaRAID controller info", "gauge", []JSONSample{
+			{map[string]string{"controller": "0", "model": "PERC H730P Mini"}, value(1)},
+		}},
+		{"megaraid_temperature", "MegaRAID controller temperature", "gauge", []JSONSample{
+			{map[string]string{"controller": "0"}, value(52.5)},
+			// NaN has no JSON representation.
+			{map[string]string{"controller": "1"}, nil},
+		}},
+		{"megaraid_pd_media_errors_total", "MegaRAID physical drive media errors", "counter", []JSONSample{
+			{map[string]string{"controller": "0", "enclosure": "252", "slot": "4"}, value(3)},
+			{map[string]string{"controller": "0", "enclosure": "", "slot": "3"}, value(0)},
+		}},
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("got\n%s\nwant\n%+v", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := encodeJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("no metrics encoded as %q, want an empty list", buf.String())
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var mqttTopicPrefix = flag.String("mqtt-topic-prefix", "", "Topic the messages of --mqtt-broker are published under. Defaults to megaraid/ and the hostname.")
+var mqttUsername = flag.String("mqtt-username", "", "User for --mqtt-broker.")
+var mqttPasswordFile = flag.String("mqtt-password-file", "", "File with the password of --mqtt-username.")
+var mqttCAFile = flag.String("mqtt-ca-file", "", "CA certificates to verify a mqtts:// --mqtt-broker with, instead of the system's.")
+
+type MQTTWriter struct {
+	broker *string
+}
+
+func init() {
+	RegisterWriter("mqtt", MQTTWriter{
+		broker: flag.String("mqtt-broker", "", "Publish health and metrics as retained messages to this MQTT broker, e.g. tcp://broker:1883 or mqtts://broker:8883, whatever --format is."),
+	})
+}
+
+func (w MQTTWriter) Enabled() bool {
+	return *w.broker != ""
+}
+
+// mqttMessage is a retained message, published with QoS 1.
+type mqttMessage struct {
+	Topic   string
+	Payload string
+}
+
+func (w MQTTWriter) Write(families []*dto.MetricFamily) error {
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	prefix := *mqttTopicPrefix
+	if prefix == "" {
+		prefix = "megaraid/" + hostname
+	}
+
+	messages, err := mqttMessages(strings.TrimSuffix(prefix, "/"), families)
+	if err != nil {
+		return err
+	}
+	return mqttPublish(*w.broker, "storcli-collector-"+hostname, messages)
+}
+
+// mqttMessages lays out the metrics as topics: the health of the host
+// and its findings, then every series under the object it belongs to,
+// e.g. megaraid/db1/c0/e252/s4/pd_media_errors_total. Info metrics are
+// a JSON object of their labels.
+func mqttMessages(prefix string, families []*dto.MetricFamily) ([]mqttMessage, error) {
+
+	type finding struct {
+		Object   string `json:"object"`
+		Rule     string `json:"rule"`
+		Severity string `json:"severity"`
+	}
+	findings := []finding{}
+	worst := SeverityOK
+
+	var messages []mqttMessage
+	for _, family := range families {
+		name := strings.TrimPrefix(family.GetName(), Namespace+"_")
+		for _, sample := range family.Metric {
+			labels := map[string]string{}
+			for _, label := range sample.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if name == "health_finding" {
+				severity, _ := parseSeverity(labels["severity"])
+				if severity > worst {
+					worst = severity
+				}
+				findings = append(findings, finding{labels["object"], labels["rule"], severity.String()})
+				continue
+			}
+
+			topic := []string{prefix}
+			if controller, found := labels["controller"]; found {
+				object := "/c" + controller
+				if vd, found := labels["VG"]; found {
+					object += "/v" + vd
+				} else if _, found := labels["slot"]; found {
+					object = physicalDriveObjectOfLabels(labels)
+				}
+				topic = append(topic, strings.TrimPrefix(object, "/"))
+			}
+			for _, identity := range []string{"controller", "VG", "enclosure", "slot"} {
+				delete(labels, identity)
+			}
+
+			if strings.HasSuffix(name, "_info") {
+				payload, err := json.Marshal(labels)
+				if err != nil {
+					return nil, err
+				}
+				messages = append(messages, mqttMessage{strings.Join(append(topic, name), "/"), string(payload)})
+				continue
+			}
+
+			value := sampleValue(sample)
+			if value == nil {
+				continue
+			}
+			topic = append(topic, name)
+			for _, label := range sample.Label {
+				if _, found := labels[label.GetName()]; found && label.GetValue() != "" {
+					topic = append(topic, mqttTopicLevel(label.GetValue()))
+				}
+			}
+			messages = append(messages, mqttMessage{strings.Join(topic, "/"), strconv.FormatFloat(*value, 'g', -1, 64)})
+		}
+	}
+
+	payload, err := json.Marshal(findings)
+	if err != nil {
+		return nil, err
+	}
+	return append([]mqttMessage{
+		{prefix + "/health", worst.String()},
+		{prefix + "/findings", string(payload)},
+	}, messages...), nil
+}
+
+// Wildcards and separators can't be part of a topic level.
+var mqttTopicEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+func mqttTopicLevel(s string) string {
+	return mqttTopicEscaper.Replace(s)
+}
+
+// mqttPublish sends the messages in one MQTT 3.1.1 session and waits
+// until the broker has acknowledged all of them.
+func mqttPublish(broker string, clientID string, messages []mqttMessage) error {
+
+	conn, err := mqttDial(broker)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	reader := bufio.NewReader(conn)
+
+	password := ""
+	if *mqttPasswordFile != "" {
+		data, err := os.ReadFile(*mqttPasswordFile)
+		if err != nil {
+			return err
+		}
+		password = strings.TrimSpace(string(data))
+	}
+
+	// Protocol name and level, flags and keep alive.
+	flags := byte(0x02) // Clean session.
+	connect := append(mqttString("MQTT"), 4, 0, 0, 60)
+	connect = append(connect, mqttString(clientID)...)
+	if *mqttUsername != "" {
+		flags |= 0x80 | 0x40
+		connect = append(connect, mqttString(*mqttUsername)...)
+		connect = append(connect, mqttString(password)...)
+	}
+	connect[7] = flags
+	if err := mqttWritePacket(conn, 0x10, connect); err != nil {
+		return err
+	}
+	kind, body, err := mqttReadPacket(reader)
+	if err != nil {
+		return err
+	}
+	if kind != 0x20 || len(body) < 2 {
+		return errors.New("mqtt: no CONNACK from the broker")
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("mqtt: broker refused the connection with code %d", body[1])
+	}
+
+	pending := map[uint16]bool{}
+	for i, message := range messages {
+		id := uint16(i%65535 + 1)
+		publish := append(mqttString(message.Topic), byte(id>>8), byte(id))
+		publish = append(publish, message.Payload...)
+		// QoS 1, retained.
+		if err := mqttWritePacket(conn, 0x30|0x02|0x01, publish); err != nil {
+			return err
+		}
+		pending[id] = true
+	}
+	for len(pending) > 0 {
+		kind, body, err := mqttReadPacket(reader)
+		if err != nil {
+			return fmt.Errorf("mqtt: waiting for %d acknowledgements: %w", len(pending), err)
+		}
+		if kind == 0x40 && len(body) >= 2 {
+			delete(pending, binary.BigEndian.Uint16(body))
+		}
+	}
+
+	return mqttWritePacket(conn, 0xe0, nil)
+}
+
+func mqttDial(broker string) (net.Conn, error) {
+
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	parsed, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed.Scheme {
+	case "tcp", "mqtt":
+		address := parsed.Host
+		if parsed.Port() == "" {
+			address = net.JoinHostPort(parsed.Host, "1883")
+		}
+		return net.DialTimeout("tcp", address, 10*time.Second)
+	case "ssl", "tls", "mqtts":
+		address := parsed.Host
+		if parsed.Port() == "" {
+			address = net.JoinHostPort(parsed.Host, "8883")
+		}
+		config := &tls.Config{ServerName: parsed.Hostname()}
+		if *mqttCAFile != "" {
+			data, err := os.ReadFile(*mqttCAFile)
+			if err != nil {
+				return nil, err
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("%s: no certificates found", *mqttCAFile)
+			}
+		}
+		return tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", address, config)
+	}
+	return nil, fmt.Errorf("--mqtt-broker: unknown scheme %q", parsed.Scheme)
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func mqttWritePacket(w io.Writer, header byte, body []byte) error {
+
+	packet := []byte{header}
+	// The remaining length, 7 bits per byte.
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+// mqttReadPacket returns the packet type, without the flags, and body.
+func mqttReadPacket(r *bufio.Reader) (byte, []byte, error) {
+
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(digit&0x7f) << shift
+		if digit&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return 0, nil, errors.New("mqtt: malformed packet length")
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xf0, body, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMQTTPacketLength(t *testing.T) {
+
+	// The examples of the MQTT 3.1.1 specification, section 2.2.3.
+	tests := []struct {
+		length  int
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xff, 0xff, 0x7f}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	}
+
+	for _, test := range tests {
+		body := bytes.Repeat([]byte{'x'}, test.length)
+		var packet bytes.Buffer
+		if err := mqttWritePacket(&packet, 0x32, body); err != nil {
+			t.Fatal(err)
+		}
+		header := packet.Bytes()[:1+len(test.encoded)]
+		if header[0] != 0x32 || !bytes.Equal(header[1:], test.encoded) {
+			t.Errorf("length %d encoded as %x, want 32%x", test.length, header, test.encoded)
+		}
+
+		kind, read, err := mqttReadPacket(bufio.NewReader(&packet))
+		if err != nil {
+			t.Fatalf("length %d: %v", test.length, err)
+		}
+		if kind != 0x30 || !bytes.Equal(read, body) {
+			t.Errorf("length %d read back as type %x with %d bytes", test.length, kind, len(read))
+		}
+	}
+}
+
+func TestMQTTReadPacketMalformed(t *testing.T) {
+
+	for _, data := range [][]byte{
+		{0x30, 0x80, 0x80, 0x80, 0x80, 0x01},
+		{0x30, 0x05, 'a'},
+		{0x30},
+	} {
+		if _, _, err := mqttReadPacket(bufio.NewReader(bytes.NewReader(data))); err == nil {
+			t.Errorf("%x read without an error", data)
+		}
+	}
+}
+
+// TestMQTTPublish runs a session against a broker that acknowledges
+// everything and checks the packets it receives.
+func TestMQTTPublish(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	type packet struct {
+		header byte
+		body   []byte
+	}
+	received := make(chan []packet, 1)
+	go func() {
+		var packets []packet
+		defer func() { received <- packets }()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			header, err := reader.Peek(1)
+			if err != nil {
+				return
+			}
+			flags := header[0]
+			kind, body, err := mqttReadPacket(reader)
+			if err != nil {
+				return
+			}
+			packets = append(packets, packet{flags, body})
+			switch kind {
+			case 0x10:
+				mqttWritePacket(conn, 0x20, []byte{0, 0})
+			case 0x30:
+				// The packet ID follows the topic.
+				topicLength := int(body[0])<<8 | int(body[1])
+				mqttWritePacket(conn, 0x40, body[2+topicLength:4+topicLength])
+			case 0xe0:
+				return
+			}
+		}
+	}()
+
+	messages := []mqttMessage{
+		{Topic: "megaraid/db1/health", Payload: "ok"},
+		{Topic: "megaraid/db1/c0/e252/s4/pd_media_errors_total", Payload: "3"},
+	}
+	if err := mqttPublish(listener.Addr().String(), "storcli-collector-db1", messages); err != nil {
+		t.Fatal(err)
+	}
+	packets := <-received
+
+	if len(packets) != 4 {
+		t.Fatalf("broker received %d packets, want CONNECT, 2 PUBLISH and DISCONNECT", len(packets))
+	}
+
+	connect := packets[0]
+	want := append(append(mqttString("MQTT"), 4, 0x02, 0, 60), mqttString("storcli-collector-db1")...)
+	if connect.header != 0x10 || !bytes.Equal(connect.body, want) {
+		t.Errorf("got CONNECT %x %x, want 10 %x", connect.header, connect.body, want)
+	}
+
+	for i, message := range messages {
+		publish := packets[1+i]
+		want := append(mqttString(message.Topic), 0, byte(i+1))
+		want = append(want, message.Payload...)
+		// QoS 1 and retained.
+		if publish.header != 0x33 || !bytes.Equal(publish.body, want) {
+			t.Errorf("got PUBLISH %x %q, want 33 %q", publish.header, publish.body, want)
+		}
+	}
+
+	if disconnect := packets[3]; disconnect.header != 0xe0 || len(disconnect.body) != 0 {
+		t.Errorf("got %x %x, want DISCONNECT", disconnect.header, disconnect.body)
+	}
+}
+
+func TestMQTTMessagesTopics(t *testing.T) {
+
+	gauge := func(name string, labels map[string]string) *dto.MetricFamily {
+		var pairs []*dto.LabelPair
+		for name, value := range labels {
+			pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+		return &dto.MetricFamily{
+			Name:   proto.String(Namespace + "_" + name),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Label: pairs, Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+		}
+	}
+	families := []*dto.MetricFamily{
+		gauge("pd_smart_alerted", map[string]string{"controller": "0", "enclosure": "252", "slot": "4"}),
+		gauge("pd_smart_alerted", map[string]string{"controller": "0", "enclosure": "", "slot": "3"}),
+		gauge("degraded_virtual_drives", map[string]string{"controller": "1"}),
+	}
+
+	messages, err := mqttMessages("megaraid/db1", families)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var topics []string
+	for _, message := range messages[2:] {
+		topics = append(topics, message.Topic)
+	}
+	want := []string{
+		"megaraid/db1/c0/e252/s4/pd_smart_alerted",
+		"megaraid/db1/c0/s3/pd_smart_alerted",
+		"megaraid/db1/c1/degraded_virtual_drives",
+	}
+	if !reflect.DeepEqual(topics, want) {
+		t.Errorf("got topics %q, want %q", topics, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var pushJob = flag.String("push-job", "storcli-collector", "Job label of the metrics pushed with --push-url.")
+var pushInstance = flag.String("push-instance", "", "Instance label of the metrics pushed with --push-url. Defaults to the hostname.")
+
+type PushWriter struct {
+	url *string
+}
+
+func init() {
+	RegisterWriter("push", PushWriter{
+		url: flag.String("push-url", "", "Push metrics to the Prometheus Pushgateway at this URL, e.g. http://pushgateway:9091, whatever --format is."),
+	})
+}
+
+func (w PushWriter) Enabled() bool {
+	return *w.url != ""
+}
+
+// Write replaces the group of this job and instance, so drives that
+// are gone don't linger on the Pushgateway.
+func (w PushWriter) Write(families []*dto.MetricFamily) error {
+
+	instance := *pushInstance
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		instance = hostname
+	}
+
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return families, nil
+	})
+	return push.New(*w.url, *pushJob).
+		Grouping("instance", instance).
+		Gatherer(gatherer).
+		Client(&http.Client{Timeout: 30 * time.Second}).
+		Push()
+}
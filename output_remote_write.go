@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var remoteWriteUsername = flag.String("remote-write-username", "", "Basic auth user for --remote-write-url.")
+var remoteWritePasswordFile = flag.String("remote-write-password-file", "", "File with the basic auth password for --remote-write-url.")
+var remoteWriteBearerTokenFile = flag.String("remote-write-bearer-token-file", "", "File with a bearer token for --remote-write-url.")
+var remoteWriteCAFile = flag.String("remote-write-ca-file", "", "CA certificates to verify the --remote-write-url server with, instead of the system's.")
+var remoteWriteCertFile = flag.String("remote-write-cert-file", "", "Client certificate for --remote-write-url.")
+var remoteWriteKeyFile = flag.String("remote-write-key-file", "", "Key of --remote-write-cert-file.")
+var remoteWriteInstance = flag.String("remote-write-instance", "", "Instance label of the samples sent with --remote-write-url. Defaults to the hostname.")
+
+type RemoteWriteWriter struct {
+	url *string
+}
+
+func init() {
+	RegisterWriter("remote-write", RemoteWriteWriter{
+		url: flag.String("remote-write-url", "", "Send samples to this Prometheus remote_write endpoint, e.g. https://mimir/api/v1/push, whatever --format is."),
+	})
+}
+
+func (w RemoteWriteWriter) Enabled() bool {
+	return *w.url != ""
+}
+
+// Write sends every series with the current time. Nothing scrapes the
+// collector, so job and instance are added here like Prometheus would.
+func (w RemoteWriteWriter) Write(families []*dto.MetricFamily) error {
+
+	instance := *remoteWriteInstance
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		instance = hostname
+	}
+	extra := map[string]string{"job": "storcli-collector", "instance": instance}
+
+	body := snappy.Encode(nil, encodeWriteRequest(families, extra, time.Now().UnixMilli()))
+
+	client, err := remoteWriteClient()
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, *w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	request.Header.Set("User-Agent", "storcli-collector/"+Version)
+	if err := remoteWriteAuth(request); err != nil {
+		return err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("remote write: %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func remoteWriteClient() (*http.Client, error) {
+
+	config := &tls.Config{}
+	if *remoteWriteCAFile != "" {
+		data, err := os.ReadFile(*remoteWriteCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificates found", *remoteWriteCAFile)
+		}
+	}
+	if *remoteWriteCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(*remoteWriteCertFile, *remoteWriteKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+// remoteWriteAuth adds the credentials, read on every write so they can
+// be rotated without a restart.
+func remoteWriteAuth(request *http.Request) error {
+
+	if *remoteWriteUsername != "" && *remoteWriteBearerTokenFile != "" {
+		return errors.New("--remote-write-username and --remote-write-bearer-token-file can't be combined")
+	}
+	if *remoteWriteUsername != "" {
+		password := ""
+		if *remoteWritePasswordFile != "" {
+			data, err := os.ReadFile(*remoteWritePasswordFile)
+			if err != nil {
+				return err
+			}
+			password = strings.TrimSpace(string(data))
+		}
+		request.SetBasicAuth(*remoteWriteUsername, password)
+	}
+	if *remoteWriteBearerTokenFile != "" {
+		data, err := os.ReadFile(*remoteWriteBearerTokenFile)
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// encodeWriteRequest builds the protobuf WriteRequest of remote_write 1.0
+// with protowire instead of pulling in the generated code of the
+// Prometheus server. It only has a few fields:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+//
+// The labels of a series win over extra ones of the same name.
+func encodeWriteRequest(families []*dto.MetricFamily, extra map[string]string, timestamp int64) []byte {
+
+	var request []byte
+	for _, family := range families {
+		for _, sample := range family.Metric {
+			value := sampleValue(sample)
+			if value == nil {
+				continue
+			}
+
+			labels := map[string]string{"__name__": family.GetName()}
+			for name, value := range extra {
+				labels[name] = value
+			}
+			for _, label := range sample.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			for name, value := range labels {
+				// An empty label is the same as none in Prometheus.
+				if value == "" {
+					delete(labels, name)
+				}
+			}
+			// Receivers reject series with unsorted labels.
+			names := make([]string, 0, len(labels))
+			for name := range labels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			var series []byte
+			for _, name := range names {
+				var label []byte
+				label = protowire.AppendTag(label, 1, protowire.BytesType)
+				label = protowire.AppendString(label, name)
+				label = protowire.AppendTag(label, 2, protowire.BytesType)
+				label = protowire.AppendString(label, labels[name])
+				series = protowire.AppendTag(series, 1, protowire.BytesType)
+				series = protowire.AppendBytes(series, label)
+			}
+			var point []byte
+			point = protowire.AppendTag(point, 1, protowire.Fixed64Type)
+			point = protowire.AppendFixed64(point, math.Float64bits(*value))
+			point = protowire.AppendTag(point, 2, protowire.VarintType)
+			point = protowire.AppendVarint(point, uint64(timestamp))
+			series = protowire.AppendTag(series, 2, protowire.BytesType)
+			series = protowire.AppendBytes(series, point)
+
+			request = protowire.AppendTag(request, 1, protowire.BytesType)
+			request = protowire.AppendBytes(request, series)
+		}
+	}
+	return request
+}
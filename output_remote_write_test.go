@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// writeRequestDescriptor describes the WriteRequest of remote_write 1.0,
+// so the encoded requests can be checked with the protobuf decoder.
+func writeRequestDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, repeated bool, message string) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		descriptor := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: kind.Enum(), Label: label.Enum()}
+		if message != "" {
+			descriptor.TypeName = proto.String(".prometheus." + message)
+		}
+		return descriptor
+	}
+	message := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("remote.proto"),
+		Package: proto.String("prometheus"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("WriteRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("timeseries", 1, message, true, "TimeSeries"),
+			}},
+			{Name: proto.String("TimeSeries"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("labels", 1, message, true, "Label"),
+				field("samples", 2, message, true, "Sample"),
+			}},
+			{Name: proto.String("Label"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, false, ""),
+				field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, false, ""),
+			}},
+			{Name: proto.String("Sample"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("value", 1, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, false, ""),
+				field("timestamp", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, false, ""),
+			}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().ByName("WriteRequest")
+}
+
+type decodedSeries struct {
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+func TestEncodeWriteRequestRoundTrip(t *testing.T) {
+
+	families := []*dto.MetricFamily{
+		{
+			Name: proto.String("megaraid_pd_media_errors_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{
+					{Name: proto.String("slot"), Value: proto.String("4")},
+					{Name: proto.String("controller"), Value: proto.String("0")},
+					{Name: proto.String("enclosure"), Value: proto.String("252")},
+				},
+				Counter: &dto.Counter{Value: proto.Float64(3)},
+			}},
+		},
+		{
+			Name: proto.String("megaraid_pd_info"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{
+					{Name: proto.String("serial"), Value: proto.String("")},
+					{Name: proto.String("instance"), Value: proto.String("db1.example.com")},
+				},
+				Gauge: &dto.Gauge{Value: proto.Float64(1)},
+			}},
+		},
+		{
+			Name:   proto.String("megaraid_controller_temperature_celsius"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(math.NaN())}}},
+		},
+	}
+	extra := map[string]string{"instance": "db1", "job": "storcli"}
+	timestamp := int64(1792123456789)
+
+	data := encodeWriteRequest(families, extra, timestamp)
+	request := dynamicpb.NewMessage(writeRequestDescriptor(t))
+	if err := proto.Unmarshal(data, request); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []decodedSeries
+	var labelOrder [][]string
+	timeseries := request.Get(request.Descriptor().Fields().ByName("timeseries")).List()
+	for i := 0; i < timeseries.Len(); i++ {
+		series := timeseries.Get(i).Message()
+		decoded := decodedSeries{Labels: map[string]string{}}
+		var names []string
+		labels := series.Get(series.Descriptor().Fields().ByName("labels")).List()
+		for j := 0; j < labels.Len(); j++ {
+			label := labels.Get(j).Message()
+			name := label.Get(label.Descriptor().Fields().ByName("name")).String()
+			decoded.Labels[name] = label.Get(label.Descriptor().Fields().ByName("value")).String()
+			names = append(names, name)
+		}
+		samples := series.Get(series.Descriptor().Fields().ByName("samples")).List()
+		if samples.Len() != 1 {
+			t.Fatalf("series %d has %d samples", i, samples.Len())
+		}
+		sample := samples.Get(0).Message()
+		decoded.Value = sample.Get(sample.Descriptor().Fields().ByName("value")).Float()
+		decoded.Timestamp = sample.Get(sample.Descriptor().Fields().ByName("timestamp")).Int()
+		got = append(got, decoded)
+		labelOrder = append(labelOrder, names)
+	}
+
+	want := []decodedSeries{
+		{
+			Labels:    map[string]string{"__name__": "megaraid_pd_media_errors_total", "controller": "0", "enclosure": "252", "slot": "4", "instance": "db1", "job": "storcli"},
+			Value:     3,
+			Timestamp: timestamp,
+		},
+		{
+			// The series' own instance wins, the empty serial is dropped.
+			Labels:    map[string]string{"__name__": "megaraid_pd_info", "instance": "db1.example.com", "job": "storcli"},
+			Value:     1,
+			Timestamp: timestamp,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	wantOrder := [][]string{
+		{"__name__", "controller", "enclosure", "instance", "job", "slot"},
+		{"__name__", "instance", "job"},
+	}
+	if !reflect.DeepEqual(labelOrder, wantOrder) {
+		t.Errorf("labels are in the order %v, want them sorted", labelOrder)
+	}
+}
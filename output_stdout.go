@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type StdoutWriter struct{}
+
+func init() {
+	RegisterWriter("stdout", StdoutWriter{})
+}
+
+// Standard output is only ever used as the fallback.
+func (StdoutWriter) Enabled() bool {
+	return false
+}
+
+func (StdoutWriter) Write(families []*dto.MetricFamily) error {
+	output, err := printMetrics(families)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(output)
+	return err
+}
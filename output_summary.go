@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// SummaryWriter writes only the host roll-up metrics, for small
+// Prometheus instances that can't afford a series per drive.
+type SummaryWriter struct {
+	path *string
+}
+
+func init() {
+	RegisterWriter("summary", SummaryWriter{
+		path: flag.String("outfile-summary", "", "Text file to write only the health roll-up metrics to, usually next to --outfile."),
+	})
+}
+
+func (w SummaryWriter) Enabled() bool {
+	return *w.path != ""
+}
+
+func (w SummaryWriter) Write(families []*dto.MetricFamily) error {
+
+	var summary []*dto.MetricFamily
+	for _, family := range families {
+		if strings.HasPrefix(family.GetName(), Namespace+"_summary_") {
+			summary = append(summary, family)
+		}
+	}
+
+	mtime, err := textfileMtime(time.Now())
+	if err != nil {
+		return err
+	}
+	summary = append(summary, mtime)
+
+	output, err := printMetrics(summary)
+	if err != nil {
+		return err
+	}
+	if *selfCheck && isExposition() {
+		if err := checkExposition(output); err != nil {
+			return fmt.Errorf("self-check failed, not writing %s: %w", *w.path, err)
+		}
+	}
+	return writeFileAtomic(*w.path, output, 0644)
+}
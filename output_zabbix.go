@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var zabbixHost = flag.String("zabbix-host", "-", "Host name in --format=zabbix lines. - lets zabbix_sender take it from the agent configuration.")
+
+func init() {
+	RegisterEncoder("zabbix", encodeZabbix)
+}
+
+// Labels that identify a controller, VD or drive. They come first in
+// item keys, in this order, and are the LLD macros.
+var zabbixIdentity = []string{"controller", "VG", "enclosure", "slot"}
+
+var zabbixMacros = map[string]string{
+	"controller": "{#CONTROLLER}",
+	"VG":         "{#VD}",
+	"enclosure":  "{#ENCLOSURE}",
+	"slot":       "{#SLOT}",
+	"model":      "{#MODEL}",
+	"name":       "{#NAME}",
+	"serial":     "{#SERIAL}",
+}
+
+// Discovery rules, by the info metric their objects come from.
+var zabbixDiscovery = map[string]string{
+	"controller_info": "megaraid.controllers.discovery",
+	"vd_info":         "megaraid.vds.discovery",
+	"pd_info":         "megaraid.pds.discovery",
+}
+
+// encodeZabbix writes input for zabbix_sender: low-level discovery JSON
+// for controllers, VDs and drives, then a value for every series. The
+// labels of info metrics become items of their own, e.g. the state of a
+// drive is megaraid.pd.state[0,252,4].
+func encodeZabbix(w io.Writer, families []*dto.MetricFamily) error {
+
+	// Discovery goes first, items of new objects only exist after it.
+	var discoveryLines, lines []string
+	for _, family := range families {
+		name := strings.TrimPrefix(family.GetName(), Namespace+"_")
+
+		if key, found := zabbixDiscovery[name]; found {
+			discovered := []map[string]string{}
+			for _, sample := range family.Metric {
+				macros := map[string]string{}
+				for _, label := range sample.Label {
+					if macro, found := zabbixMacros[label.GetName()]; found {
+						macros[macro] = label.GetValue()
+					}
+				}
+				discovered = append(discovered, macros)
+			}
+			data, err := json.Marshal(discovered)
+			if err != nil {
+				return err
+			}
+			discoveryLines = append(discoveryLines, zabbixLine(key, string(data)))
+		}
+
+		for _, sample := range family.Metric {
+			identity, others := zabbixParams(sample.Label)
+			if object, isInfo := strings.CutSuffix(name, "_info"); isInfo {
+				for _, label := range others {
+					lines = append(lines, zabbixLine(zabbixKey(object+"."+label.GetName(), identity), label.GetValue()))
+				}
+				continue
+			}
+
+			value := sampleValue(sample)
+			if value == nil {
+				continue
+			}
+			for _, label := range others {
+				identity = append(identity, label.GetValue())
+			}
+			lines = append(lines, zabbixLine(zabbixKey(name, identity), strconv.FormatFloat(*value, 'f', -1, 64)))
+		}
+	}
+
+	for _, line := range append(discoveryLines, lines...) {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zabbixParams splits the labels into the values of the identifying ones
+// and the rest, sorted by name.
+func zabbixParams(labels []*dto.LabelPair) ([]string, []*dto.LabelPair) {
+
+	byName := map[string]string{}
+	var others []*dto.LabelPair
+	for _, label := range labels {
+		byName[label.GetName()] = label.GetValue()
+		if !isZabbixIdentity(label.GetName()) {
+			others = append(others, label)
+		}
+	}
+	var identity []string
+	for _, name := range zabbixIdentity {
+		if value, found := byName[name]; found {
+			identity = append(identity, value)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].GetName() < others[j].GetName()
+	})
+
+	return identity, others
+}
+
+func isZabbixIdentity(name string) bool {
+	for _, identity := range zabbixIdentity {
+		if name == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// zabbixKey builds an item key like megaraid.pd_media_errors_total[0,252,4].
+func zabbixKey(name string, params []string) string {
+
+	key := "megaraid." + name
+	if len(params) == 0 {
+		return key
+	}
+	quoted := make([]string, len(params))
+	for i, param := range params {
+		if strings.ContainsAny(param, `,"[] `) {
+			param = `"` + strings.ReplaceAll(param, `"`, `\"`) + `"`
+		}
+		quoted[i] = param
+	}
+	return key + "[" + strings.Join(quoted, ",") + "]"
+}
+
+// zabbixLine is a line of zabbix_sender input: host, key and value, with
+// the key and value quoted.
+func zabbixLine(key string, value string) string {
+	return *zabbixHost + " " + zabbixQuote(key) + " " + zabbixQuote(value)
+}
+
+func zabbixQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
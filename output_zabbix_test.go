@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeZabbix(t *testing.T) {
+
+	var buf bytes.Buffer
+	if err := encodeZabbix(&buf, testFamilies()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `- "megaraid.controllers.discovery" "[{\"{#CONTROLLER}\":\"0\",\"{#MODEL}\":\"PERC H730P Mini\"}]"
+- "megaraid.controller.model[0]" "PERC H730P Mini"
+- "megaraid.temperature[0]" "52.5"
+- "megaraid.pd_media_errors_total[0,252,4]" "3"
+- "megaraid.pd_media_errors_total[0,,3]" "0"
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestZabbixKey(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		params []string
+		key    string
+	}{
+		{"ctrl_count", nil, "megaraid.ctrl_count"},
+		{"pd_temperature_celsius", []string{"0", "252", "4"}, "megaraid.pd_temperature_celsius[0,252,4]"},
+		{"vd.name", []string{"0", "1"}, "megaraid.vd.name[0,1]"},
+		{"vd_info", []string{"0", "os boot"}, `megaraid.vd_info[0,"os boot"]`},
+		{"vd_info", []string{"a,b"}, `megaraid.vd_info["a,b"]`},
+		{"vd_info", []string{`say "hi"`}, `megaraid.vd_info["say \"hi\""]`},
+		{"vd_info", []string{"[x]"}, `megaraid.vd_info["[x]"]`},
+	}
+
+	for _, test := range tests {
+		if key := zabbixKey(test.name, test.params); key != test.key {
+			t.Errorf("%s %q: got %s, want %s", test.name, test.params, key, test.key)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func init() {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	model := flags.Bool("model", false, "Print the normalized model as JSON instead of the metrics.")
+
+	RegisterSubcommand("parse", &Subcommand{
+		Flags: flags,
+		Run: func(Source) error {
+			source, err := readFileSource(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("parsing standard input: %w", err)
+			}
+			return parseSaved(source, *model)
+		},
+		NoSource: true,
+	})
+}
+
+// readFileSource reads the output of /call show all J, optionally
+// followed by that of /call/eall/sall show all J, as printed by
+// running both into the same pipe.
+func readFileSource(r io.Reader) (*FileSource, error) {
+
+	decoder := json.NewDecoder(r)
+	var controllers, drives json.RawMessage
+	if err := decoder.Decode(&controllers); err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(&drives); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return parseFileSource(controllers, drives)
+}
+
+// parseSaved runs saved output through the collection and prints what a
+// collector would export from it, failing if any controller didn't
+// parse.
+func parseSaved(source *FileSource, model bool) error {
+
+	system, err := collect(source)
+	if err != nil {
+		return err
+	}
+
+	var output []byte
+	if model {
+		output, err = json.MarshalIndent(system, "", "  ")
+		output = append(output, '\n')
+	} else {
+		families, gatherErr := gatherMetrics(system)
+		if gatherErr != nil {
+			return gatherErr
+		}
+		output, err = printMetrics(families)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stdout.Write(output); err != nil {
+		return err
+	}
+
+	if len(system.FailedControllers) > 0 {
+		return errors.New("Not every controller could be parsed, see the log above.")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var storcliNice = flag.Int("storcli-nice", 0, "Run storcli with this niceness, 1 to 19, so it yields the CPU to production workloads.")
+var storcliIOnice = flag.String("storcli-ionice", "", "Run storcli in this I/O scheduling class, idle or best-effort:0 to best-effort:7 like ionice. Only on Linux.")
+
+// Priority lowers the scheduling priority of storcli and whatever it
+// starts. The zero value leaves it alone.
+type Priority struct {
+	Nice int
+	// ioprio_set(2) value, class and level.
+	IO int
+}
+
+const (
+	ioprioClassBestEffort = 2
+	ioprioClassIdle       = 3
+	ioprioClassShift      = 13
+)
+
+func parsePriority(nice int, ionice string) (Priority, error) {
+
+	if nice < 0 || nice > 19 {
+		return Priority{}, fmt.Errorf("--storcli-nice must be 1 to 19, storcli can't be given a higher priority than the collector")
+	}
+	priority := Priority{Nice: nice}
+
+	class, level, hasLevel := strings.Cut(ionice, ":")
+	switch class {
+	case "":
+	case "idle":
+		if hasLevel {
+			return Priority{}, errors.New("--storcli-ionice: the idle class has no level")
+		}
+		priority.IO = ioprioClassIdle << ioprioClassShift
+	case "best-effort":
+		number := 4
+		if hasLevel {
+			var err error
+			number, err = strconv.Atoi(level)
+			if err != nil || number < 0 || number > 7 {
+				return Priority{}, fmt.Errorf("--storcli-ionice: best-effort level must be 0 to 7, not %q", level)
+			}
+		}
+		priority.IO = ioprioClassBestEffort<<ioprioClassShift | number
+	default:
+		return Priority{}, fmt.Errorf("--storcli-ionice must be idle or best-effort[:level], not %q", ionice)
+	}
+
+	return priority, nil
+}
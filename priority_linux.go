@@ -0,0 +1,24 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const ioprioWhoProcessGroup = 2
+
+// lowerPriority applies the priority to the process group of storcli,
+// see killProcessGroup, so a wrapper's child gets it too.
+func lowerPriority(pgid int, priority Priority) error {
+
+	if priority.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PGRP, pgid, priority.Nice); err != nil {
+			return err
+		}
+	}
+	if priority.IO != 0 {
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcessGroup, uintptr(pgid), uintptr(priority.IO)); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
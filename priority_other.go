@@ -0,0 +1,24 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// lowerPriority applies the priority to the process group of storcli,
+// see killProcessGroup, so a wrapper's child gets it too.
+func lowerPriority(pgid int, priority Priority) error {
+
+	if priority.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PGRP, pgid, priority.Nice); err != nil {
+			return err
+		}
+	}
+	if priority.IO != 0 {
+		return errors.New("--storcli-ionice is only supported on Linux")
+	}
+	return nil
+}
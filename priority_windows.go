@@ -0,0 +1,7 @@
+package main
+
+import "errors"
+
+func lowerPriority(pgid int, priority Priority) error {
+	return errors.New("--storcli-nice and --storcli-ionice aren't supported on Windows")
+}
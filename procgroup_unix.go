@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup starts the command in a process group of its own and
+// has a cancelled context kill the whole group, so helpers that storcli
+// or a wrapper like sudo started don't linger.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// A root process group can't be signalled by a user that
+		// started it through sudo, which still passes on the kill.
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+import "os/exec"
+
+func killProcessGroup(cmd *exec.Cmd) {}
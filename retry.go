@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var retries = flag.Int("retries", 0, "Retry a failed storcli command this many times.")
+var retryBackoff = flag.Duration("retry-backoff", time.Second, "Wait before the first retry, doubled for every further one.")
+
+// Output of commands that failed because the firmware was busy rather
+// than broken.
+var transientFailurePattern = regexp.MustCompile(`(?i)(busy|semaphore|try again)`)
+
+// RetrySource retries failed queries with exponential backoff, until
+// the collector shuts down.
+type RetrySource struct {
+	Source  Source
+	Retries int
+	Backoff time.Duration
+}
+
+func (r RetrySource) Query(args ...string) ([]byte, error) {
+
+	backoff := r.Backoff
+	for attempt := 0; ; attempt++ {
+		data, err := r.Source.Query(args...)
+		if !isTransientFailure(data, err) || attempt >= r.Retries {
+			return data, err
+		}
+		log.Printf("storcli %s failed, retrying in %s: %v", strings.Join(args, " "), backoff, err)
+		// Shutdown doesn't wait out the backoff.
+		select {
+		case <-time.After(backoff):
+		case <-shutdownContext.Done():
+			return data, err
+		}
+		backoff *= 2
+	}
+}
+
+// Deciding whether to retry needs the whole output, so streaming is only
+// passed through when retries are off.
+func (r RetrySource) QueryStream(args ...string) (io.ReadCloser, error) {
+	if r.Retries > 0 {
+		data, err := r.Query(args...)
+		return bufferedOutput(data, err), nil
+	}
+	return queryStream(r.Source, args...)
+}
+
+// storcli regularly exits non-zero with perfectly good output, so an
+// error alone isn't a reason to run the command again.
+func isTransientFailure(data []byte, err error) bool {
+	if err == nil {
+		return false
+	}
+	return len(data) == 0 || transientFailurePattern.Match(data)
+}
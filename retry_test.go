@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// scriptedSource answers every query with the next of its replies.
+type scriptedSource struct {
+	replies []scriptedReply
+	queries int
+}
+
+type scriptedReply struct {
+	data string
+	err  error
+}
+
+func (s *scriptedSource) Query(args ...string) ([]byte, error) {
+	reply := s.replies[s.queries]
+	s.queries++
+	return []byte(reply.data), reply.err
+}
+
+func TestIsTransientFailure(t *testing.T) {
+
+	failed := errors.New("exit status 1")
+	tests := []struct {
+		data      string
+		err       error
+		transient bool
+	}{
+		{`{"Controllers": []}`, nil, false},
+		{"", nil, false},
+		{"", failed, true},
+		{"Controller is busy, please try again later", failed, true},
+		{"Failed to acquire semaphore", failed, true},
+		{"TRY AGAIN", failed, true},
+		// Non-zero exits with good output are common.
+		{`{"Controllers": [{"Command Status": {"Status": "Failure"}}]}`, failed, false},
+	}
+
+	for _, test := range tests {
+		if transient := isTransientFailure([]byte(test.data), test.err); transient != test.transient {
+			t.Errorf("%q, %v: got %v, want %v", test.data, test.err, transient, test.transient)
+		}
+	}
+}
+
+func TestRetrySource(t *testing.T) {
+
+	busy := scriptedReply{"controller busy", errors.New("exit status 1")}
+	broken := scriptedReply{"Invalid command", errors.New("exit status 255")}
+	good := scriptedReply{"ok", nil}
+
+	tests := []struct {
+		name    string
+		retries int
+		replies []scriptedReply
+		queries int
+		data    string
+		failed  bool
+	}{
+		{"success", 3, []scriptedReply{good}, 1, "ok", false},
+		{"busy then success", 3, []scriptedReply{busy, busy, good}, 3, "ok", false},
+		{"busy throughout", 2, []scriptedReply{busy, busy, busy}, 3, "controller busy", true},
+		{"not transient", 3, []scriptedReply{broken}, 1, "Invalid command", true},
+		{"no retries", 0, []scriptedReply{busy}, 1, "controller busy", true},
+	}
+
+	for _, test := range tests {
+		source := &scriptedSource{replies: test.replies}
+		data, err := RetrySource{Source: source, Retries: test.retries, Backoff: time.Millisecond}.Query("show")
+		if source.queries != test.queries || string(data) != test.data || (err != nil) != test.failed {
+			t.Errorf("%s: got %d queries, %q, %v, want %d queries, %q, failed %v", test.name,
+				source.queries, data, err, test.queries, test.data, test.failed)
+		}
+	}
+}
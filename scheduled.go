@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduledTask is a recurring controller job such as patrol read or
+// consistency check.
+type ScheduledTask struct {
+	// e.g. patrol_read, consistency_check, battery_learning
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Zero when the task is disabled.
+	IntervalSeconds float64   `json:"interval_seconds"`
+	NextRun         time.Time `json:"next_run"`
+	HasNextRun      bool      `json:"has_next_run"`
+}
+
+// Units storcli uses for the reoccurrence of a task.
+var scheduleUnits = map[string]time.Duration{
+	"min":   time.Minute,
+	"mins":  time.Minute,
+	"hr":    time.Hour,
+	"hrs":   time.Hour,
+	"hours": time.Hour,
+	"day":   24 * time.Hour,
+	"days":  24 * time.Hour,
+}
+
+// parseScheduledTasks reads the "Scheduled Tasks" section. Every task has
+// a "<Task> Reoccurrence" key, and a "Next <Task> launch" key whose
+// wording and case only roughly match it, e.g. "Battery learning
+// Reoccurrence" and "Next Battery Learn". OEM firmware adds tasks of its
+// own, so they aren't hardcoded.
+func parseScheduledTasks(section map[string]interface{}) []ScheduledTask {
+
+	nextRuns := map[string]string{}
+	for key, value := range section {
+		lowerKey := strings.ToLower(key)
+		if !strings.HasPrefix(lowerKey, "next ") {
+			continue
+		}
+		if text, ok := value.(string); ok {
+			task := strings.TrimSuffix(strings.TrimPrefix(lowerKey, "next "), " launch")
+			nextRuns[task] = text
+		}
+	}
+
+	var tasks []ScheduledTask
+	for key, value := range section {
+		if !strings.HasSuffix(key, " Reoccurrence") {
+			continue
+		}
+		reoccurrence, _ := value.(string)
+		name := strings.ToLower(strings.TrimSuffix(key, " Reoccurrence"))
+
+		task := ScheduledTask{Name: strings.ReplaceAll(name, " ", "_")}
+		if interval, ok := parseScheduleInterval(reoccurrence); ok {
+			task.Enabled = true
+			task.IntervalSeconds = interval.Seconds()
+		}
+
+		for nextName, nextRun := range nextRuns {
+			if !strings.HasPrefix(name, nextName) && !strings.HasPrefix(nextName, name) {
+				continue
+			}
+			// Dates are in storcli's time zone, like the system date.
+			next, err := time.ParseInLocation("01/02/2006, 15:04:05", nextRun, storcliLocation)
+			if err == nil && task.Enabled {
+				task.NextRun = next
+				task.HasNextRun = true
+			}
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+
+	return tasks
+}
+
+// parseScheduleInterval reads a reoccurrence like "168 hrs". Disabled
+// tasks are reported as "NA", "Disabled" or "0 hrs" depending on the
+// firmware.
+func parseScheduleInterval(reoccurrence string) (time.Duration, bool) {
+
+	fields := strings.Fields(reoccurrence)
+	if len(fields) != 2 {
+		return 0, false
+	}
+	count, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || count <= 0 {
+		return 0, false
+	}
+	unit, ok := scheduleUnits[strings.ToLower(fields[1])]
+	if !ok {
+		return 0, false
+	}
+
+	return time.Duration(count * float64(unit)), true
+}
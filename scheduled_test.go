@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleInterval(t *testing.T) {
+
+	tests := []struct {
+		reoccurrence string
+		interval     time.Duration
+		enabled      bool
+	}{
+		{"168 hrs", 168 * time.Hour, true},
+		{"670 hrs", 670 * time.Hour, true},
+		{"1 Day", 24 * time.Hour, true},
+		{"7 days", 7 * 24 * time.Hour, true},
+		{"30 mins", 30 * time.Minute, true},
+		{"0.5 hours", 30 * time.Minute, true},
+		{"0 hrs", 0, false},
+		{"NA", 0, false},
+		{"Disabled", 0, false},
+		{"", 0, false},
+		{"168", 0, false},
+		{"168 weeks", 0, false},
+		{"-1 hrs", 0, false},
+	}
+
+	for _, test := range tests {
+		interval, enabled := parseScheduleInterval(test.reoccurrence)
+		if interval != test.interval || enabled != test.enabled {
+			t.Errorf("parseScheduleInterval(%q) = %v, %v, want %v, %v", test.reoccurrence, interval, enabled, test.interval, test.enabled)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+var selfCheck = flag.Bool("self-check", false, "Parse the output again before writing a textfile and fail instead of writing one node_exporter would reject.")
+
+// checkExposition reads rendered metrics back the way the textfile
+// collector does. node_exporter drops the whole file on a single bad
+// line, so it's better to fail loudly here than to lose every metric.
+func checkExposition(data []byte) error {
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for name, family := range families {
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			return fmt.Errorf("invalid metric name %q", name)
+		}
+		for _, metric := range family.Metric {
+			var pairs []string
+			for _, label := range metric.Label {
+				if !model.LabelName(label.GetName()).IsValid() {
+					return fmt.Errorf("invalid label name %q in %s", label.GetName(), name)
+				}
+				if !utf8.ValidString(label.GetValue()) {
+					return fmt.Errorf("invalid label value %q in %s", label.GetValue(), name)
+				}
+				pairs = append(pairs, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+			sort.Strings(pairs)
+			series := name + "{" + strings.Join(pairs, ",") + "}"
+			if seen[series] {
+				return fmt.Errorf("duplicate series %s", series)
+			}
+			seen[series] = true
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// shutdownContext is cancelled on SIGTERM or SIGINT. Running storcli
+// commands are killed, the collection fails and nothing half collected
+// is written. Outputs that are already being written are finished.
+var shutdownContext, shutdown = context.WithCancel(context.Background())
+
+// handleShutdownSignals turns the first SIGTERM or SIGINT into a
+// shutdown. A second one ends the collector right away.
+func handleShutdownSignals() {
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		received := <-signals
+		log.Printf("Received %s, shutting down", received)
+		if err := sdNotify("STOPPING=1"); err != nil {
+			log.Printf("Notifying systemd: %v", err)
+		}
+		shutdown()
+
+		received = <-signals
+		log.Printf("Received %s again, exiting", received)
+		os.Exit(fatalExitCode)
+	}()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Simulated failures let teams test alert routing end to end without
+// pulling drives. The flags are left out of --help on purpose.
+var simulate = flag.String("simulate", "", "")
+var simulateConfirmed = flag.Bool("i-know-this-is-fake", false, "")
+
+var hiddenFlags = map[string]bool{
+	"simulate":            true,
+	"i-know-this-is-fake": true,
+}
+
+func init() {
+	flag.Usage = func() {
+		visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		flag.VisitAll(func(f *flag.Flag) {
+			if !hiddenFlags[f.Name] {
+				visible.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		visible.SetOutput(flag.CommandLine.Output())
+		visible.PrintDefaults()
+	}
+}
+
+// Simulation is a single fake failure, e.g. drive-failed:0/252/4.
+type Simulation struct {
+	Kind   string
+	Target []string
+}
+
+// How many parts of the target each kind takes: controller, then
+// enclosure and slot for drives or the VD number for virtual drives.
+var simulationTargets = map[string]int{
+	"controller-degraded": 1,
+	"vd-degraded":         2,
+	"drive-failed":        3,
+	"drive-smart-alert":   3,
+}
+
+var simulations []Simulation
+
+func parseSimulations(spec string) ([]Simulation, error) {
+
+	var parsed []Simulation
+	for _, item := range strings.Split(spec, ",") {
+		kind, target, _ := strings.Cut(strings.TrimSpace(item), ":")
+		parts, known := simulationTargets[kind]
+		if !known {
+			return nil, fmt.Errorf("unknown simulation %q", kind)
+		}
+		simulation := Simulation{Kind: kind, Target: strings.Split(target, "/")}
+		if len(simulation.Target) != parts {
+			return nil, fmt.Errorf("simulation %s needs %d parts separated by /, got %q", kind, parts, target)
+		}
+		parsed = append(parsed, simulation)
+	}
+
+	return parsed, nil
+}
+
+// applySimulations mutates the model before export. Targets that don't
+// exist are ignored, so the same flags can be rolled out to a fleet.
+func applySimulations(system *System) {
+
+	if len(simulations) == 0 {
+		return
+	}
+
+	for _, simulation := range simulations {
+		for _, controller := range system.Controllers {
+			if strconv.Itoa(controller.Index) != simulation.Target[0] {
+				continue
+			}
+			switch simulation.Kind {
+			case "controller-degraded":
+				controller.Status = "Degraded"
+			case "vd-degraded":
+				for i := range controller.VirtualDrives {
+					if controller.VirtualDrives[i].VolumeGroup == simulation.Target[1] {
+						controller.VirtualDrives[i].State = "Dgrd"
+					}
+				}
+			case "drive-failed", "drive-smart-alert":
+				for _, drive := range controller.PhysicalDrives {
+					if drive.Enclosure != simulation.Target[1] || drive.Slot != simulation.Target[2] {
+						continue
+					}
+					if simulation.Kind == "drive-failed" {
+						drive.State = "Failed"
+					} else {
+						drive.SmartAlerted = true
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+func init() {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	output := flags.String("o", "", "Archive to write, e.g. host-$(date +%F).tar.gz")
+
+	RegisterSubcommand("snapshot", &Subcommand{
+		Flags: flags,
+		Run: func(source Source) error {
+			if *output == "" {
+				return errors.New("snapshot needs -o")
+			}
+			return writeSnapshot(source, *output)
+		},
+	})
+}
+
+// CaptureSource keeps the raw output of every query it passes through.
+type CaptureSource struct {
+	Source Source
+
+	mu      sync.Mutex
+	outputs map[string][]byte
+}
+
+func (c *CaptureSource) Query(args ...string) ([]byte, error) {
+
+	data, err := c.Source.Query(args...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.outputs == nil {
+		c.outputs = map[string][]byte{}
+	}
+	c.outputs[spoolFileName(args)] = data
+
+	return data, err
+}
+
+// writeSnapshot runs a collection and archives everything it saw: the
+// raw storcli output, the command transcript, the normalized model and
+// the rendered metrics. This is what vendor cases and postmortems need.
+func writeSnapshot(source Source, output string) error {
+
+	capture := &CaptureSource{Source: source}
+	recorder := NewRecordingSource(capture)
+	system, collectErr := collect(recorder)
+	transcript := recorder.Finish(collectErr)
+
+	files := map[string][]byte{}
+	for name, data := range capture.outputs {
+		files[path.Join("raw", name)] = data
+	}
+
+	var err error
+	if files["transcript.json"], err = json.MarshalIndent(transcript, "", "  "); err != nil {
+		return err
+	}
+
+	// A failed collection is often the reason for taking a snapshot,
+	// so the raw output is archived regardless.
+	if collectErr == nil {
+		if files["model.json"], err = json.MarshalIndent(system, "", "  "); err != nil {
+			return err
+		}
+		families, err := gatherMetrics(system)
+		if err != nil {
+			return err
+		}
+		if files["metrics.prom"], err = printMetrics(families); err != nil {
+			return err
+		}
+	}
+
+	if err := writeTarGz(output, files); err != nil {
+		return err
+	}
+
+	return collectErr
+}
+
+func writeTarGz(output string, files map[string][]byte) error {
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	archive := tar.NewWriter(gz)
+	now := time.Now()
+
+	for name, data := range files {
+		header := &tar.Header{
+			Name:    path.Join("snapshot", name),
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
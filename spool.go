@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Split deployments run a small root cron job with --spool-write that only
+// executes storcli and saves the raw output, while the unprivileged exporter
+// reads the same directory with --spool-dir alone.
+var spoolDir = flag.String("spool-dir", "", "Directory holding raw storcli output. Read from it instead of running storcli unless --spool-write is set.")
+var spoolWrite = flag.Bool("spool-write", false, "Run storcli, save the raw output to --spool-dir and exit without exporting metrics.")
+var spoolMaxAge = flag.Duration("spool-max-age", 0, "(Optional) Fail the collection when a --spool-dir file is older than this, e.g. 15m, so a spool writer that stopped running doesn't go unnoticed.")
+
+// spoolFileName maps a storcli query to the file holding its output.
+func spoolFileName(args []string) string {
+	fields := strings.FieldsFunc(strings.Join(args, " "), func(r rune) bool {
+		return r == ' ' || r == '/'
+	})
+	name := strings.ReplaceAll(strings.Join(fields, "_"), "=", "-")
+	return name + ".out"
+}
+
+// SpoolSource replays the output saved by SpoolWriter.
+type SpoolSource struct {
+	Dir string
+	// Files older than this fail their query, unless it is zero.
+	MaxAge time.Duration
+}
+
+func (s SpoolSource) Query(args ...string) ([]byte, error) {
+
+	path := filepath.Join(s.Dir, spoolFileName(args))
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if age := time.Since(info.ModTime()); s.MaxAge > 0 && age > s.MaxAge {
+		return nil, fmt.Errorf("%s was written %s ago, more than --spool-max-age", path, age.Round(time.Second))
+	}
+	recordSpoolRead(info.ModTime())
+
+	return io.ReadAll(file)
+}
+
+// When the oldest spool file read by the current collection was written.
+var spoolReads struct {
+	sync.Mutex
+	oldest time.Time
+}
+
+func recordSpoolRead(written time.Time) {
+	spoolReads.Lock()
+	defer spoolReads.Unlock()
+	if spoolReads.oldest.IsZero() || written.Before(spoolReads.oldest) {
+		spoolReads.oldest = written
+	}
+}
+
+// takeSpoolMtime returns when the oldest spool file the last collection
+// read was written, if it read any, and starts over for the next one.
+func takeSpoolMtime() (time.Time, bool) {
+	spoolReads.Lock()
+	defer spoolReads.Unlock()
+	oldest := spoolReads.oldest
+	spoolReads.oldest = time.Time{}
+	return oldest, !oldest.IsZero()
+}
+
+// SpoolWriter saves the output of every query it passes through.
+type SpoolWriter struct {
+	Source Source
+	Dir    string
+}
+
+func (s SpoolWriter) Query(args ...string) ([]byte, error) {
+
+	data, cmdErr := s.Source.Query(args...)
+	if len(data) == 0 {
+		return data, cmdErr
+	}
+
+	// The exporter runs as another user and must never see a half
+	// written file.
+	target := filepath.Join(s.Dir, spoolFileName(args))
+	if err := writeFileAtomic(target, data, 0644); err != nil {
+		return data, err
+	}
+
+	return data, cmdErr
+}
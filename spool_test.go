@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolFileName(t *testing.T) {
+
+	tests := map[string]string{
+		"show ctrlcount J":                 "show_ctrlcount_J.out",
+		"/c0 show all J":                   "c0_show_all_J.out",
+		"/c0/eALL/sALL show all J":         "c0_eALL_sALL_show_all_J.out",
+		"/c1 show events type=latest=100":  "c1_show_events_type-latest-100.out",
+		"-AdpAllInfo -a0 -NoLog":           "-AdpAllInfo_-a0_-NoLog.out",
+		"/c0 show termlog type=contents":   "c0_show_termlog_type-contents.out",
+		"/c0/pALL show phyerrorcounters J": "c0_pALL_show_phyerrorcounters_J.out",
+	}
+	for command, name := range tests {
+		if got := spoolFileName(strings.Fields(command)); got != name {
+			t.Errorf("%q: got %q, want %q", command, got, name)
+		}
+	}
+}
+
+func TestSpoolSourceMaxAge(t *testing.T) {
+
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(command string, age time.Duration) {
+		path := filepath.Join(dir, spoolFileName(strings.Fields(command)))
+		if err := os.WriteFile(path, []byte(command), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("show ctrlcount J", time.Minute)
+	write("/c0 show all J", time.Hour)
+
+	tests := []struct {
+		command string
+		maxAge  time.Duration
+		ok      bool
+	}{
+		{"show ctrlcount J", 0, true},
+		{"/c0 show all J", 0, true},
+		{"show ctrlcount J", 15 * time.Minute, true},
+		{"/c0 show all J", 15 * time.Minute, false},
+		{"/c1 show all J", 0, false},
+	}
+
+	for _, test := range tests {
+		takeSpoolMtime()
+		data, err := SpoolSource{Dir: dir, MaxAge: test.maxAge}.Query(strings.Fields(test.command)...)
+		if (err == nil) != test.ok {
+			t.Errorf("%q with max age %v: got %v, want ok %v", test.command, test.maxAge, err, test.ok)
+			continue
+		}
+		if test.ok && string(data) != test.command {
+			t.Errorf("%q: read %q", test.command, data)
+		}
+	}
+
+	// The metric shows the oldest file of the collection.
+	takeSpoolMtime()
+	source := SpoolSource{Dir: dir}
+	source.Query("show", "ctrlcount", "J")
+	source.Query("/c0", "show", "all", "J")
+	mtime, found := takeSpoolMtime()
+	if !found || mtime.Unix() != now.Add(-time.Hour).Unix() {
+		t.Errorf("got spool mtime %v, %v, want %v", mtime, found, now.Add(-time.Hour))
+	}
+	if _, found := takeSpoolMtime(); found {
+		t.Error("the spool mtime wasn't reset")
+	}
+}
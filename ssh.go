@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var sshTarget = flag.String("ssh-target", "", "Run storcli on these hosts over SSH instead of locally, comma separated, e.g. root@nas1,root@nas2. --storcli_path is the path on the remote host.")
+var sshKey = flag.String("ssh-key", "", "(Optional) Private key to log in with. Defaults to the keys ssh finds itself.")
+var sshPath = flag.String("ssh-path", "ssh", "ssh client to run, searched in PATH unless absolute.")
+
+// Target is a host to collect from. Host is empty for the local one and
+// becomes the host label otherwise.
+type Target struct {
+	Host   string
+	Source Source
+}
+
+// sshTargets builds a target for every host in --ssh-target.
+func sshTargets(client string, storcliPath string, extraArgs map[string][]string, timeout time.Duration) []Target {
+
+	var targets []Target
+	for _, target := range strings.Split(*sshTarget, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		targets = append(targets, Target{
+			Host: sshHost(target),
+			Source: RetrySource{
+				Source: SSHSource{
+					Target:      target,
+					StorcliPath: storcliPath,
+					Identity:    *sshKey,
+					ExtraArgs:   extraArgs,
+					Timeout:     timeout,
+					TimeZone:    *storcliTZ,
+					Client:      client,
+				},
+				Retries: *retries,
+				Backoff: *retryBackoff,
+			},
+		})
+	}
+
+	return targets
+}
+
+// SSHSource runs storcli on another host with the ssh client, so the
+// usual ~/.ssh/config, known_hosts and agent apply. Password prompts are
+// turned off, only keys work.
+type SSHSource struct {
+	Target string
+	// storcli on the remote host.
+	StorcliPath string
+	Identity    string
+	ExtraArgs   map[string][]string
+	Timeout     time.Duration
+	TimeZone    string
+	// The local ssh client.
+	Client string
+}
+
+func (s SSHSource) Query(args ...string) ([]byte, error) {
+	return s.client().Query(s.remoteArgs(args)...)
+}
+
+func (s SSHSource) QueryStream(args ...string) (io.ReadCloser, error) {
+	return s.client().QueryStream(s.remoteArgs(args)...)
+}
+
+// client runs ssh the way storcli is run locally, with the same timeout.
+func (s SSHSource) client() StorcliSource {
+	return StorcliSource{Path: s.Client, Timeout: s.Timeout}
+}
+
+// remoteArgs builds the ssh command line. The remote shell splits the
+// command again, so every word is quoted.
+func (s SSHSource) remoteArgs(args []string) []string {
+
+	kind := commandKind(args)
+	args = append(append([]string{}, args...), s.ExtraArgs["all"]...)
+	args = append(args, s.ExtraArgs[kind]...)
+
+	sshArgs := []string{"-o", "BatchMode=yes"}
+	if s.Identity != "" {
+		sshArgs = append(sshArgs, "-i", s.Identity)
+	}
+	sshArgs = append(sshArgs, "--", s.Target)
+
+	remote := []string{"env", "LC_ALL=C", "LANG=C"}
+	if s.TimeZone != "" {
+		remote = append(remote, "TZ="+s.TimeZone)
+	}
+	remote = append(remote, s.StorcliPath)
+	for _, word := range append(remote, args...) {
+		sshArgs = append(sshArgs, shellQuote(word))
+	}
+
+	return sshArgs
+}
+
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// sshHost is the host name of a target, for the host label.
+func sshHost(target string) string {
+	if _, host, found := strings.Cut(target, "@"); found {
+		return host
+	}
+	return target
+}
+
+// mergeFamilies combines the families of several collections, keeping
+// samples of the same metric in one family.
+func mergeFamilies(families []*dto.MetricFamily, more []*dto.MetricFamily) []*dto.MetricFamily {
+
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	for _, family := range more {
+		if existing, found := byName[family.GetName()]; found {
+			existing.Metric = append(existing.Metric, family.Metric...)
+			continue
+		}
+		byName[family.GetName()] = family
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+
+	return families
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var stateFile = flag.String("state-file", "", "(Optional) File to remember the previous collection in between runs: drive firmware and error counts, the controller events counted and health findings. Without it, changes are only tracked while the process runs.")
+
+// State is what the collector remembers from one collection to the next.
+// Every change it notices is also handed to the hooks.
+type State struct {
+	Drives      map[string]*DriveRecord      `json:"drives"`
+	Controllers map[string]*ControllerRecord `json:"controllers,omitempty"`
+	// Findings of the last collection, by findingKey.
+	Findings map[string]*FindingRecord `json:"findings,omitempty"`
+}
+
+type DriveRecord struct {
+	Serial          string `json:"serial"`
+	Firmware        string `json:"firmware"`
+	FirmwareChanges int    `json:"firmware_changes"`
+	// Unset until the drive in the slot was collected once.
+	Errors *ErrorCounts `json:"errors,omitempty"`
+}
+
+type ErrorCounts struct {
+	Media      float64 `json:"media"`
+	Other      float64 `json:"other"`
+	Predictive float64 `json:"predictive"`
+}
+
+type ControllerRecord struct {
+	// Sequence number of the newest event in the event log.
+	LastEvent int64 `json:"last_event"`
+	// Events counted so far by severity, for megaraid_events_total.
+	EventCounts map[string]float64 `json:"event_counts,omitempty"`
+}
+
+func newState() *State {
+	return &State{
+		Drives:      map[string]*DriveRecord{},
+		Controllers: map[string]*ControllerRecord{},
+		Findings:    map[string]*FindingRecord{},
+	}
+}
+
+var state = newState()
+
+func loadState(path string) (*State, error) {
+
+	loaded := newState()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return loaded, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, loaded); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if loaded.Drives == nil {
+		loaded.Drives = map[string]*DriveRecord{}
+	}
+	if loaded.Controllers == nil {
+		loaded.Controllers = map[string]*ControllerRecord{}
+	}
+	if loaded.Findings == nil {
+		loaded.Findings = map[string]*FindingRecord{}
+	}
+
+	return loaded, nil
+}
+
+func saveState(path string, s *State) error {
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data, 0644)
+}
+
+func driveKey(host string, controllerIndex int, drive *PhysicalDriveState) string {
+	key := strconv.Itoa(controllerIndex) + "/" + drive.Enclosure + "/" + drive.Slot
+	if host != "" {
+		key = host + "/" + key
+	}
+	return key
+}
+
+// trackFirmware compares every drive with the previous collection and
+// counts firmware changes. A different serial in the slot is a new
+// drive, not a firmware change, so its count starts over.
+func (s *State) trackFirmware(system *System) {
+
+	for _, controller := range system.Controllers {
+		for _, drive := range controller.PhysicalDrives {
+			if !drive.Detailed {
+				continue
+			}
+
+			key := driveKey(system.Host, controller.Index, drive)
+			record, seen := s.Drives[key]
+			if !seen || record.Serial != drive.Serial {
+				record = &DriveRecord{Serial: drive.Serial, Firmware: drive.Firmware}
+				s.Drives[key] = record
+			}
+			if record.Firmware != drive.Firmware {
+				record.Firmware = drive.Firmware
+				record.FirmwareChanges++
+			}
+
+			drive.FirmwareChanges = record.FirmwareChanges
+		}
+	}
+}
+
+// trackErrorCounts reports drives whose error counters went up since the
+// previous collection. It runs after trackFirmware, which starts a new
+// record for a replaced drive.
+func (s *State) trackErrorCounts(system *System, now time.Time) []HookEvent {
+
+	var events []HookEvent
+	for _, controller := range system.Controllers {
+		for _, drive := range controller.PhysicalDrives {
+			record, seen := s.Drives[driveKey(system.Host, controller.Index, drive)]
+			if !seen {
+				continue
+			}
+			counts := &ErrorCounts{Media: drive.MediaErrors, Other: drive.OtherErrors, Predictive: drive.PredictiveErrors}
+			if previous := record.Errors; previous != nil {
+				for _, counter := range []struct {
+					name     string
+					old, new float64
+					severity string
+				}{
+					{"media errors", previous.Media, counts.Media, "warn"},
+					{"other errors", previous.Other, counts.Other, "warn"},
+					{"predictive failures", previous.Predictive, counts.Predictive, "crit"},
+				} {
+					if counter.new > counter.old {
+						events = append(events, HookEvent{
+							Event:    "error_count",
+							Time:     now.UTC().Format(time.RFC3339),
+							Host:     system.Host,
+							Object:   physicalDriveObject(controller, drive),
+							Severity: counter.severity,
+							Message:  fmt.Sprintf("%s went up from %s to %s", counter.name, formatCount(counter.old), formatCount(counter.new)),
+						})
+					}
+				}
+			}
+			record.Errors = counts
+		}
+	}
+	return events
+}
+
+// trackControllerEvents reports the warnings and worse that were logged
+// since the previous collection, if --collect-events reads the log. The
+// log of a controller seen for the first time is taken as old news. The
+// events newer than the last one counted are added to the counts of the
+// controller.
+func (s *State) trackControllerEvents(system *System, now time.Time) []HookEvent {
+
+	var events []HookEvent
+	for _, controller := range system.Controllers {
+		if !controller.EventsCollected {
+			continue
+		}
+		key := system.Host + controllerObject(controller)
+		newest := int64(-1)
+		for _, event := range controller.Events {
+			if event.Sequence > newest {
+				newest = event.Sequence
+			}
+		}
+
+		record, seen := s.Controllers[key]
+		if !seen {
+			record = &ControllerRecord{LastEvent: -1}
+			s.Controllers[key] = record
+		}
+		if record.EventCounts == nil {
+			record.EventCounts = map[string]float64{}
+		}
+		// A log that went backwards was cleared or belongs to a
+		// replaced controller, so all of it is new.
+		continued := seen && newest >= record.LastEvent
+		for _, event := range controller.Events {
+			if continued && event.Sequence <= record.LastEvent {
+				continue
+			}
+			record.EventCounts[event.Severity()]++
+			if !continued || event.Class < 1 {
+				continue
+			}
+			severity := "warn"
+			if event.Class > 1 {
+				severity = "crit"
+			}
+			events = append(events, HookEvent{
+				Event:    "controller_event",
+				Time:     now.UTC().Format(time.RFC3339),
+				Host:     system.Host,
+				Object:   controllerObject(controller),
+				Severity: severity,
+				Message:  event.Description,
+			})
+		}
+		if newest >= 0 || !seen {
+			record.LastEvent = newest
+		}
+
+		controller.EventCounts = map[string]float64{}
+		for severity, count := range record.EventCounts {
+			controller.EventCounts[severity] = count
+		}
+	}
+	return events
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrackControllerEventCounts(t *testing.T) {
+
+	event := func(sequence int64, class int) Event {
+		return Event{Sequence: sequence, Class: class}
+	}
+	tests := []struct {
+		name   string
+		events []Event
+		counts map[string]float64
+		hooks  int
+	}{
+		{"first collection counts the log", []Event{event(1, 0), event(2, 1)}, map[string]float64{"info": 1, "warning": 1}, 0},
+		{"same log again", []Event{event(1, 0), event(2, 1)}, map[string]float64{"info": 1, "warning": 1}, 0},
+		{"new events", []Event{event(1, 0), event(2, 1), event(3, 2), event(4, 0)}, map[string]float64{"info": 2, "warning": 1, "critical": 1}, 1},
+		{"older events rolled out", []Event{event(4, 0)}, map[string]float64{"info": 2, "warning": 1, "critical": 1}, 0},
+		{"cleared log", []Event{event(1, 1)}, map[string]float64{"info": 2, "warning": 2, "critical": 1}, 0},
+		{"empty log", nil, map[string]float64{"info": 2, "warning": 2, "critical": 1}, 0},
+	}
+
+	s := newState()
+	for _, test := range tests {
+		controller := &ControllerState{Events: test.events, EventsCollected: true}
+		hooks := s.trackControllerEvents(&System{Controllers: []*ControllerState{controller}}, time.Now())
+		if !reflect.DeepEqual(controller.EventCounts, test.counts) {
+			t.Errorf("%s: got counts %v, want %v", test.name, controller.EventCounts, test.counts)
+		}
+		if len(hooks) != test.hooks {
+			t.Errorf("%s: got %d hook events, want %d", test.name, len(hooks), test.hooks)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	all := flags.Bool("all", false, "List every drive, not only those that aren't online or have findings.")
+	color := flags.String("color", "auto", "Color the output: auto, always or never.")
+
+	RegisterSubcommand("status", &Subcommand{
+		Flags: flags,
+		Run: func(source Source) error {
+			if *color != "auto" && *color != "always" && *color != "never" {
+				return fmt.Errorf("-color must be auto, always or never, not %q", *color)
+			}
+			system, err := collect(source)
+			if err != nil {
+				return err
+			}
+			applySimulations(system)
+
+			useColor := *color == "always"
+			if *color == "auto" {
+				useColor = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+			}
+			printStatus(os.Stdout, system, *all, useColor)
+			return nil
+		},
+	})
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Drive states that are fine without further notice.
+var quietDriveStates = map[string]bool{"Onln": true, "UGood": true, "JBOD": true, "GHS": true, "DHS": true}
+
+// printStatus writes the model as tables for a person at the console,
+// with the health of every row from the health rules.
+func printStatus(w io.Writer, system *System, all bool, useColor bool) {
+
+	findings := evaluateHealth(system)
+	health := func(object string) Severity {
+		worst := SeverityOK
+		for _, finding := range findings {
+			if finding.Object == object && finding.Severity > worst {
+				worst = finding.Severity
+			}
+		}
+		return worst
+	}
+	paint := func(severity Severity, text string) statusCell {
+		return statusCell{text, severity}
+	}
+	plain := func(text string) statusCell {
+		return statusCell{text: text}
+	}
+
+	controllers := statusTable{header: []string{"CONTROLLER", "MODEL", "FIRMWARE", "STATUS", "TEMP", "BBU", "HEALTH"}}
+	virtualDrives := statusTable{header: []string{"VD", "NAME", "RAID", "STATE", "CACHE", "HEALTH"}}
+	drives := statusTable{header: []string{"DRIVE", "MODEL", "MEDIA", "STATE", "MEDIA ERR", "OTHER ERR", "PRED FAIL", "HEALTH"}}
+	hidden := 0
+
+	for _, controller := range system.Controllers {
+		object := controllerObject(controller)
+		severity := health(object)
+
+		bbu := "-"
+		if controller.IsMegaraid() {
+			bbu = "OK"
+			if !controller.BBUHealthy() {
+				bbu = "status " + strconv.Itoa(controller.BBUStatus)
+			}
+			for _, temperature := range append(append([]float64{}, controller.BBUTemperatures...), controller.CacheVaultTemperatures...) {
+				bbu += fmt.Sprintf(" %.0f°C", temperature)
+			}
+		}
+		controllers.add(
+			plain(object),
+			plain(controller.Model),
+			plain(controller.FirmwareVersion),
+			paint(severity, controller.Status),
+			plain(fmt.Sprintf("%.0f°C", controller.Temperature)),
+			plain(bbu),
+			paint(severity, severity.String()),
+		)
+
+		for _, virtualDrive := range controller.VirtualDrives {
+			object := virtualDriveObject(controller, virtualDrive)
+			severity := health(object)
+			virtualDrives.add(
+				plain(object),
+				plain(virtualDrive.Name),
+				plain(virtualDrive.Type),
+				paint(severity, virtualDrive.State),
+				plain(virtualDrive.Cache),
+				paint(severity, severity.String()),
+			)
+		}
+
+		for _, drive := range controller.PhysicalDrives {
+			object := physicalDriveObject(controller, drive)
+			severity := health(object)
+			if !all && severity == SeverityOK && quietDriveStates[drive.State] {
+				hidden++
+				continue
+			}
+			drives.add(
+				plain(object),
+				plain(drive.Model),
+				plain(drive.Media),
+				paint(severity, drive.State),
+				plain(strconv.FormatFloat(drive.MediaErrors, 'f', -1, 64)),
+				plain(strconv.FormatFloat(drive.OtherErrors, 'f', -1, 64)),
+				plain(strconv.FormatFloat(drive.PredictiveErrors, 'f', -1, 64)),
+				paint(severity, severity.String()),
+			)
+		}
+	}
+
+	controllers.print(w, useColor)
+	for _, index := range system.FailedControllers {
+		fmt.Fprintf(w, "/c%d could not be collected\n", index)
+	}
+	fmt.Fprintln(w)
+	if len(virtualDrives.rows) > 0 {
+		virtualDrives.print(w, useColor)
+		fmt.Fprintln(w)
+	}
+	if len(drives.rows) > 0 {
+		drives.print(w, useColor)
+	}
+	if hidden > 0 {
+		fmt.Fprintf(w, "%d more drives are fine, -all lists them\n", hidden)
+	}
+
+	if len(findings) > 0 {
+		fmt.Fprintln(w)
+		for _, finding := range findings {
+			line := fmt.Sprintf("%s: %s %s (%s)", finding.Severity, finding.Object, finding.Message, finding.Rule)
+			fmt.Fprintln(w, statusCell{line, finding.Severity}.render(len(line), useColor))
+		}
+	}
+}
+
+// statusCell is a table cell, colored by its severity. Only cells that
+// carry a state are given one, the rest stay OK and plain.
+type statusCell struct {
+	text     string
+	severity Severity
+}
+
+var severityColors = map[Severity]string{
+	SeverityWarn: "\x1b[33m",
+	SeverityCrit: "\x1b[31m",
+}
+
+// render pads the cell to width. The escape codes go around the padded
+// text so they don't count towards the width.
+func (c statusCell) render(width int, useColor bool) string {
+	padded := c.text + strings.Repeat(" ", width-len([]rune(c.text)))
+	if code, found := severityColors[c.severity]; found && useColor {
+		return code + padded + "\x1b[0m"
+	}
+	return padded
+}
+
+type statusTable struct {
+	header []string
+	rows   [][]statusCell
+}
+
+func (t *statusTable) add(cells ...statusCell) {
+	t.rows = append(t.rows, cells)
+}
+
+func (t *statusTable) print(w io.Writer, useColor bool) {
+
+	widths := make([]int, len(t.header))
+	for i, title := range t.header {
+		widths[i] = len(title)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if n := len([]rune(cell.text)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	line := make([]string, len(t.header))
+	for i, title := range t.header {
+		line[i] = statusCell{text: title}.render(widths[i], false)
+	}
+	fmt.Fprintln(w, strings.TrimRight(strings.Join(line, "  "), " "))
+	for _, row := range t.rows {
+		for i, cell := range row {
+			line[i] = cell.render(widths[i], useColor)
+		}
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(line, "  "), " "))
+	}
+}
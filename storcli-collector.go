@@ -1,714 +1,466 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"os/exec"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"runtime"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/expfmt"
+	dto "github.com/prometheus/client_model/go"
 )
 
 const Namespace = "megaraid"
-const Version = "0.1.3"
 
-var StorcliPath string
-
-type PhysicalDrive struct {
-	EIDSlt string      `json:"EID:Slt"`
-	DID    int         `json:"DID"`
-	Intf   string      `json:"Intf"`
-	Med    string      `json:"Med"`
-	Model  string      `json:"Model"`
-	DG     interface{} `json:"DG"`
-	State  string      `json:"State"`
-}
-
-type PhysicalDriveUnpack struct {
-	Controllers []struct {
-		ResponseData map[string]interface{} `json:"Response Data"`
-	} `json:"Controllers"`
-}
+// Set at build time with -ldflags "-X main.Version=... -X main.Revision=...".
+var Version = "0.1.3"
+var Revision = ""
 
-type Controller struct {
-	CommandStatus struct {
-		Status string `json:"Status"`
-	} `json:"Command Status"`
-	ResponseData struct {
-		Basics struct {
-			Controller     int    `json:"Controller"`
-			Model          string `json:"Model"`
-			SerialNumber   string `json:"Serial Number"`
-			ControllerDate string `json:"Current Controller Date/Time"`
-			SystemDate     string `json:"Current System Date/time"`
-		} `json:"Basics"`
-		Version struct {
-			DriverName      string `json:"Driver Name"`
-			FirmwareVersion string `json:"Firmware Version"`
-		} `json:"Version"`
-		Status struct {
-			ControllerStatus string `json:"Controller Status"`
-			BBUStatus        int    `json:"BBU Status"`
-		} `json:"Status"`
-		HwCfg struct {
-			BackendPortCount int `json:"Backend Port Count"`
-			// spelling can vary
-			ROCTempCelsius int `json:"ROC temperature(Degree Celsius)"`
-			ROCTempCelcius int `json:"ROC temperature(Degree Celcius)"`
-		} `json:"HwCfg"`
-		ScheduledTasks struct {
-			PatrolReadReoccurrence string `json:"Patrol Read Reoccurrence"`
-		} `json:"Scheduled Tasks"`
-		DriveGroups   int `json:"Drive Groups"`
-		VirtualDrives int `json:"Virtual Drives"`
-		VDList        []struct {
-			DG_VD string `json:"DG/VD"`
-			Name  string `json:"Name"`
-			Cache string `json:"Cache"`
-			Type  string `json:"TYPE"`
-			State string `json:"State"`
-		} `json:"VD LIST"`
-		PhysicalDrives int             `json:"Physical Drives"`
-		PDList         []PhysicalDrive `json:"PD LIST"`
-		CachevaultInfo []struct {
-			Temp string `json:"Temp"`
-		} `json:"Cachevault_Info"`
-		BBUInfo []struct {
-			Temp string `json:"Temp"`
-		} `json:"BBU_Info"`
-	} `json:"Response Data"`
-}
+// The storcli that is run, on the remote hosts with --ssh-target.
+var StorcliPath string
 
-type ControllerData struct {
-	Controllers []Controller `json:"Controllers"`
-}
+func main() {
 
-var Metrics = map[string]*prometheus.GaugeVec{
-	"ctrl_info": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "controller_info",
-			Help:      "MegaRAID controller info",
-		},
-		[]string{"controller", "model", "serial", "fwversion"},
-	),
-	"ctrl_temperature": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "temperature",
-			Help:      "MegaRAID controller temperature",
-		},
-		[]string{"controller"},
-	),
-	"ctrl_healthy": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "healthy",
-			Help:      "MegaRAID controller healthy",
-		},
-		[]string{"controller"},
-	),
-	"ctrl_degraded": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "degraded",
-			Help:      "MegaRAID controller degraded",
-		},
-		[]string{"controller"},
-	),
-	"ctrl_failed": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "failed",
-			Help:      "MegaRAID controller failed",
-		},
-		[]string{"controller"},
-	),
-	"ctrl_time_difference": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "time_difference",
-			Help:      "MegaRAID controller failed",
-		},
-		[]string{"controller"},
-	),
-	"bbu_healthy": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "battery_backup_healthy",
-			Help:      "MegaRAID battery backup healthy",
-		},
-		[]string{"controller"},
-	),
-	"bbu_temperature": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "bbu_temperature",
-			Help:      "MegaRAID battery backup temperature",
-		},
-		[]string{"controller", "bbuidx"},
-	),
-	"cv_temperature": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "cv_temperature",
-			Help:      "MegaRAID CacheVault temperature",
-		},
-		[]string{"controller", "cvidx"},
-	),
-	"ctrl_sched_patrol_read": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "scheduled_patrol_read",
-			Help:      "MegaRAID scheduled patrol read",
-		},
-		[]string{"controller"},
-	),
-	"ctrl_ports": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "ports",
-			Help:      "MegaRAID ports",
-		},
-		[]string{"controller"},
-	),
-	"ctrl_physical_drives": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "physical_drives",
-			Help:      "MegaRAID physical drives",
-		},
-		[]string{"controller"},
-	),
-	"ctrl_drive_groups": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "drive_groups",
-			Help:      "MegaRAID drive groups",
-		},
-		[]string{"controller"},
-	),
-	"ctrl_virtual_drives": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "virtual_drives",
-			Help:      "MegaRAID virtual drives",
-		},
-		[]string{"controller"},
-	),
-	"vd_info": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "vd_info",
-			Help:      "MegaRAID virtual drive info",
-		},
-		[]string{"controller", "DG", "VG", "name", "cache", "type", "state"},
-	),
-	"pd_shield_counter": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_shield_counter",
-			Help:      "MegaRAID physical drive shield counter",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_media_errors": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_media_errors",
-			Help:      "MegaRAID physical drive media errors",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_other_errors": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_other_errors",
-			Help:      "MegaRAID physical drive other errors",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_predictive_errors": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_predictive_errors",
-			Help:      "MegaRAID physical drive predictive errors",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_smart_alerted": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_smart_alerted",
-			Help:      "MegaRAID physical drive SMART alerted",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_link_speed": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_link_speed_gbps",
-			Help:      "MegaRAID physical drive link speed in Gbps",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_device_speed": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_device_speed_gbps",
-			Help:      "MegaRAID physical drive device speed in Gbps",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_commissioned_spare": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_commissioned_spare",
-			Help:      "MegaRAID physical drive commissioned spare",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_emergency_spare": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_emergency_spare",
-			Help:      "MegaRAID physical drive emergency spare",
-		},
-		[]string{"controller", "enclosure", "slot"},
-	),
-	"pd_info": prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: Namespace,
-			Name:      "pd_info",
-			Help:      "MegaRAID physical drive info",
-		},
-		[]string{
-			"controller",
-			"enclosure",
-			"slot",
-			"disk_id",
-			"interface",
-			"media",
-			"model",
-			"DG",
-			"state",
-			"firmware",
-			"serial",
-		},
-	),
-}
+	var storcliPath = flag.String("storcli_path", "/opt/MegaRAID/storcli/storcli64", "(Optional) Absolute path to StorCLI binary. Defaults to /opt/MegaRAID/storcli/storcli64 or storcli in PATH")
+	var storcliDontfail = flag.Bool("storcli_dontfailover", false, "(Optional) Don't fall back to PATH env if absolute path is missing.")
+	var version = flag.Bool("version", false, "Get version information")
+	var commandTimeout = flag.Duration("command-timeout", 5*time.Minute, "Give up on a storcli command after this long. 0 disables the timeout.")
+	var interval = flag.Duration("interval", 0, "Collect repeatedly at this interval, e.g. 60s, instead of running once.")
+	var intervalJitter = flag.Duration("interval-jitter", 0, "Add a random delay of up to this much to every --interval.")
 
-func getStorcliJson() ControllerData {
+	subcommand := parseArgs(os.Args[1:])
+	if subcommand != nil && subcommand.ExitCode != 0 {
+		fatalExitCode = subcommand.ExitCode
+	}
 
-	if _, err := os.Stat(StorcliPath); os.IsNotExist(err) {
-		log.Fatal(err)
+	if *version {
+		fmt.Println(Version)
+		os.Exit(0)
 	}
 
-	data, cmdErr := exec.Command(StorcliPath, "/cALL", "show", "all", "J").Output()
+	if *timeSource != "storcli" && *timeSource != "host" {
+		fatalf("--time-source must be storcli or host, not %q", *timeSource)
+	}
 
-	/* TEST CASE - Temporarily use a text file
-	data, err := os.ReadFile("controllers.json")
-	if err != nil {
-		log.Fatal(err)
+	if err := selectEncoder(); err != nil {
+		fatal(err)
 	}
-	*/
 
-	// Because this thing will return a string of NA if the
-	// BBU doesn't exist, which won't unpack into the struct.
-	// Why though?
-	dataString := string(data)
-	dataString = strings.Replace(dataString, `"BBU Status" : "NA"`, `"BBU Status" : 9999`, 1)
-	data = []byte(dataString)
+	if *backend != "auto" && *backend != "storcli" && *backend != "megacli" {
+		fatalf("--backend must be auto, storcli or megacli, not %q", *backend)
+	}
 
-	var getControllers ControllerData
-	err := json.Unmarshal(data, &getControllers)
-	if err != nil {
-		log.Print(cmdErr)
-		log.Fatal(err)
+	if *simulate != "" {
+		if !*simulateConfirmed {
+			fatal("--simulate reports failures that don't exist and needs --i-know-this-is-fake")
+		}
+		parsed, err := parseSimulations(*simulate)
+		if err != nil {
+			fatal(err)
+		}
+		simulations = parsed
+		log.Printf("Simulating %s, the exported metrics are fake", *simulate)
 	}
 
-	if getControllers.Controllers[0].CommandStatus.Status != "Success" {
-		log.Fatal("Could not find controllers in output.")
+	if *labelsFlag != "" {
+		parsed, err := parseLabels(*labelsFlag)
+		if err != nil {
+			fatal(err)
+		}
+		staticLabels = parsed
+	}
+	if *addHostnameLabel {
+		hostname, err := os.Hostname()
+		if err != nil {
+			fatal(err)
+		}
+		staticLabels["hostname"] = hostname
 	}
 
-	return getControllers
-}
+	if *storcliTZ != "" {
+		location, err := time.LoadLocation(*storcliTZ)
+		if err != nil {
+			fatal(err)
+		}
+		storcliLocation = location
+	}
 
-func getStorcliDrivesJson() PhysicalDriveUnpack {
+	if *configFile != "" {
+		if err := applyConfig(*configFile); err != nil {
+			fatal(err)
+		}
+	}
 
-	/* TEST CASE - Temporarily use a text file
-	data, err := os.ReadFile("drives.json")
-	if err != nil {
-		log.Fatal(err)
+	if *stateFile != "" {
+		loaded, err := loadState(*stateFile)
+		if err != nil {
+			fatal(err)
+		}
+		state = loaded
 	}
-	*/
 
-	data, cmdErr := exec.Command(StorcliPath, "/cALL/eALL/sALL", "show", "all", "J").Output()
+	if subcommand != nil && subcommand.NoSource {
+		if err := subcommand.Run(nil); err != nil {
+			fatal(err)
+		}
+		return
+	}
 
-	var jsonOutput PhysicalDriveUnpack
-	err := json.Unmarshal(data, &jsonOutput)
-	if err != nil {
-		log.Print(cmdErr)
-		log.Fatal(err)
+	if *drivesFile != "" && *fromFile == "" {
+		fatal("--drives-file is only read with --from-file")
 	}
 
-	return jsonOutput
-}
+	var source Source
+	var targets []Target
+	if *helperSocket != "" {
+		if *backend == "megacli" {
+			fatal("--helper-socket only runs storcli and can't be combined with --backend=megacli")
+		}
+		*backend = "storcli"
+		source = RetrySource{
+			Source:  HelperSource{Socket: *helperSocket},
+			Retries: *retries,
+			Backoff: *retryBackoff,
+		}
+	} else if *sshTarget != "" {
+		if *backend == "megacli" || *spoolDir != "" {
+			fatal("--ssh-target only runs storcli and can't be combined with --backend=megacli or --spool-dir")
+		}
+		*backend = "storcli"
+		StorcliPath = *storcliPath
+		client, err := exec.LookPath(*sshPath)
+		if err != nil {
+			fatal(err)
+		}
+		targets = sshTargets(client, *storcliPath, config.ExtraArgs, *commandTimeout)
+		if len(targets) == 0 {
+			fatal("--ssh-target has no hosts")
+		}
+		source = targets[0].Source
+	} else if *fromFile != "" {
+		if *backend == "megacli" || *spoolDir != "" {
+			fatal("--from-file parses storcli output and can't be combined with --backend=megacli or --spool-dir")
+		}
+		*backend = "storcli"
+		fileSource, err := NewFileSource(*fromFile, *drivesFile)
+		if err != nil {
+			fatal(err)
+		}
+		source = fileSource
+	} else if *spoolDir != "" && !*spoolWrite {
+		source = SpoolSource{Dir: *spoolDir, MaxAge: *spoolMaxAge}
+	} else {
+		wrapper, err := storcliWrapper()
+		if err != nil {
+			fatal(err)
+		}
+		priority, err := parsePriority(*storcliNice, *storcliIOnice)
+		if err != nil {
+			fatal(err)
+		}
+		path, err := findBackend(*storcliPath, *storcliDontfail)
+		if err != nil {
+			fatal(err)
+		}
+		StorcliPath = path
+		source = RetrySource{
+			Source: StorcliSource{
+				Path:      StorcliPath,
+				ExtraArgs: config.ExtraArgs,
+				Timeout:   *commandTimeout,
+				TimeZone:  *storcliTZ,
+				Wrapper:   wrapper,
+				LockFile:  *storcliLockFile,
+				Priority:  priority,
+			},
+			Retries: *retries,
+			Backoff: *retryBackoff,
+		}
+	}
 
-func printMetrics(reg *prometheus.Registry) string {
+	if targets == nil {
+		targets = []Target{{Source: source}}
+	}
 
-	g := prometheus.Gatherers{reg}
-	gatheredMetrics, err := g.Gather()
-	if err != nil {
-		log.Fatal(err)
+	if subcommand != nil {
+		if len(targets) > 1 {
+			fatal("Subcommands run against a single --ssh-target")
+		}
+		if err := subcommand.Run(source); err != nil {
+			fatal(err)
+		}
+		return
 	}
 
-	buf := new(bytes.Buffer)
-	for _, metric := range gatheredMetrics {
-		_, err = expfmt.MetricFamilyToOpenMetrics(buf, metric)
+	if *dropToUser != "" {
+		if *sshTarget != "" || *helperSocket != "" {
+			fatal("--drop-to-user can't be combined with --ssh-target or --helper-socket")
+		}
+		dropped, err := dropPrivileges(*dropToUser)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
+		source = dropped
+		targets = []Target{{Source: source}}
 	}
 
-	return (buf.String())
+	if *cacheTTL > 0 {
+		for i := range targets {
+			targets[i].Source = NewCacheSource(targets[i].Source, *cacheTTL, targets[i].Host)
+		}
+	}
 
-}
+	if *lockFilePath != "" {
+		if err := acquireLockFile(*lockFilePath); err != nil {
+			fatal(err)
+		}
+	}
+	handleShutdownSignals()
 
-func handleCommonController(controller Controller) {
+	if *listenAddress != "" || *listenUnix != "" || *systemdSocket {
+		if *spoolWrite {
+			fatal("HTTP mode serves metrics and can't be combined with --spool-write")
+		}
+		if (*listenAddress != "") && (*listenUnix != "") || (*listenAddress != "" || *listenUnix != "") && *systemdSocket {
+			fatal("Only one of --listen-address, --listen-unix and --systemd-socket can be used")
+		}
+		if *enablePprof && *debugToken == "" {
+			fatal("--enable-pprof needs --debug-token, the profiler shows the command line and memory of the collector")
+		}
+		if err := serveHTTP(*listenAddress, targets, *interval, *intervalJitter); err != nil {
+			fatal(err)
+		}
+		return
+	}
 
-	controllerIndex := strconv.Itoa(controller.ResponseData.Basics.Controller)
+	// collectd's exec plugin expects the process to keep running and
+	// tells it how often to report.
+	if *interval == 0 && *outputFormat == "collectd" {
+		if seconds, found := collectdIntervalSeconds(); found {
+			*interval = time.Duration(seconds * float64(time.Second))
+		}
+	}
 
-	Metrics["ctrl_info"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"model":      controller.ResponseData.Basics.Model,
-		"serial":     controller.ResponseData.Basics.SerialNumber,
-		"fwversion":  controller.ResponseData.Version.FirmwareVersion,
-	}).Set(1)
+	run := func() error {
+		return collectAndWrite(targets)
+	}
+	if *spoolWrite {
+		if *spoolDir == "" {
+			fatal("--spool-write needs --spool-dir")
+		}
+		run = func() error {
+			_, err := collect(SpoolWriter{Source: source, Dir: *spoolDir})
+			return err
+		}
+	}
 
-	var tempCelsius float64
-	if controller.ResponseData.HwCfg.ROCTempCelcius > 0 {
-		tempCelsius = float64(controller.ResponseData.HwCfg.ROCTempCelcius)
-	} else if controller.ResponseData.HwCfg.ROCTempCelsius > 0 {
-		tempCelsius = float64(controller.ResponseData.HwCfg.ROCTempCelsius)
-	} else {
-		tempCelsius = 0
+	if *interval == 0 {
+		err := run()
+		waitForHooks()
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// Running as a service, so a failed collection is logged and
+	// retried on the next tick instead of exiting.
+	reload := reloadSignal()
+	notifyReady()
+	for {
+		done := beginCollection()
+		if err := run(); err != nil {
+			log.Print(err)
+		}
+		done()
+
+		next := time.After(nextInterval(collectionInterval(*interval), *intervalJitter))
+	wait:
+		for {
+			select {
+			case <-next:
+				break wait
+			case <-reload:
+				reloadConfig(*configFile)
+			case <-shutdownContext.Done():
+				return
+			}
+		}
 	}
+}
 
-	Metrics["ctrl_temperature"].With(prometheus.Labels{
-		"controller": controllerIndex,
-	}).Set(tempCelsius)
+// Exit code when the collector can't start or collect.
+var fatalExitCode = 1
 
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	os.Exit(fatalExitCode)
 }
 
-func handleMegaraidController(controller Controller) {
-
-	controllerIndex := strconv.Itoa(controller.ResponseData.Basics.Controller)
-
-	var bbuStatus float64
-	switch controller.ResponseData.Status.BBUStatus {
-	case 0:
-		bbuStatus = 1
-	case 8:
-		bbuStatus = 1
-	case 4096:
-		bbuStatus = 1
-	default:
-		bbuStatus = 0
-	}
-	Metrics["bbu_healthy"].With(prometheus.Labels{
-		"controller": controllerIndex,
-	}).Set(bbuStatus)
-
-	var controllerStatusDegraded float64
-	var controllerStatusFailed float64
-	var controllerStatusOptimal float64
-
-	switch controller.ResponseData.Status.ControllerStatus {
-	case "Degraded":
-		controllerStatusDegraded = 1
-	case "Failed":
-		controllerStatusFailed = 1
-	case "Optimal":
-		controllerStatusOptimal = 1
-	}
-
-	Metrics["ctrl_degraded"].With(prometheus.Labels{
-		"controller": controllerIndex,
-	}).Set(controllerStatusDegraded)
-	Metrics["ctrl_failed"].With(prometheus.Labels{
-		"controller": controllerIndex,
-	}).Set(controllerStatusFailed)
-	Metrics["ctrl_healthy"].With(prometheus.Labels{
-		"controller": controllerIndex,
-	}).Set(controllerStatusOptimal)
-
-	Metrics["ctrl_ports"].With(prometheus.Labels{
-		"controller": controllerIndex,
-	}).Set(float64(controller.ResponseData.HwCfg.BackendPortCount))
-
-	var scheduledPatrolRead float64
-	if strings.Contains(controller.ResponseData.ScheduledTasks.PatrolReadReoccurrence, "hrs") {
-		scheduledPatrolRead = 1
-	}
-	Metrics["ctrl_sched_patrol_read"].With(prometheus.Labels{
-		"controller": controllerIndex,
-	}).Set(scheduledPatrolRead)
-
-	for cvidx, cvinfo := range controller.ResponseData.CachevaultInfo {
-		tempString := strings.Replace(cvinfo.Temp, "C", "", 1)
-		temperature, _ := strconv.ParseFloat(tempString, 64)
-		Metrics["cv_temperature"].With(prometheus.Labels{
-			"controller": controllerIndex,
-			"cvidx":      strconv.Itoa(cvidx),
-		}).Set(temperature)
-	}
-
-	for bbuidx, bbuinfo := range controller.ResponseData.BBUInfo {
-		tempString := strings.Replace(bbuinfo.Temp, "C", "", 1)
-		temperature, _ := strconv.ParseFloat(tempString, 64)
-		Metrics["bbu_temperature"].With(prometheus.Labels{
-			"controller": controllerIndex,
-			"bbuidx":     strconv.Itoa(bbuidx),
-		}).Set(temperature)
-	}
-
-	timefmt := "01/02/2006, 15:04:05"
-
-	if controller.ResponseData.Basics.ControllerDate != "" && controller.ResponseData.Basics.SystemDate != "" {
-		controllerDateTime, conErr := time.Parse(timefmt, controller.ResponseData.Basics.ControllerDate)
-		systemDateTime, sysErr := time.Parse(timefmt, controller.ResponseData.Basics.SystemDate)
-		if conErr == nil || sysErr == nil {
-			timeDiff := float64(systemDateTime.Unix() - controllerDateTime.Unix())
-			Metrics["ctrl_time_difference"].With(prometheus.Labels{
-				"controller": controllerIndex,
-			}).Set(timeDiff)
-		}
-	}
-
-	if controller.ResponseData.DriveGroups > 0 {
-		Metrics["ctrl_drive_groups"].With(prometheus.Labels{
-			"controller": controllerIndex,
-		}).Set(float64(controller.ResponseData.DriveGroups))
-		Metrics["ctrl_virtual_drives"].With(prometheus.Labels{
-			"controller": controllerIndex,
-		}).Set(float64(controller.ResponseData.VirtualDrives))
-
-		for _, virtualDrive := range controller.ResponseData.VDList {
-			var driveGroup string = "-1"
-			var volumeGroup string = "-1"
-			if virtualDrive.DG_VD != "" {
-				groups := strings.Split(virtualDrive.DG_VD, "/")
-				driveGroup = groups[0]
-				volumeGroup = groups[1]
+func fatalf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(fatalExitCode)
+}
+
+// collectAndWrite runs a full collection of every target and hands the
+// result to every enabled writer. A target that fails fails the whole
+// run, so a stale file is kept rather than one with hosts missing.
+func collectAndWrite(targets []Target) error {
+
+	var families []*dto.MetricFamily
+	var systems []*System
+	for _, target := range targets {
+		system, err := collect(dumpRaw(target.Source, target.Host))
+		if err != nil {
+			if *healthFile != "" {
+				recordHealthFailure(target.Host, err)
+				if err := writeHealthFile(*healthFile); err != nil {
+					log.Print(err)
+				}
 			}
-			Metrics["vd_info"].With(prometheus.Labels{
-				"controller": controllerIndex,
-				"DG":         driveGroup,
-				"VG":         volumeGroup,
-				"name":       virtualDrive.Name,
-				"cache":      virtualDrive.Cache,
-				"type":       virtualDrive.Type,
-				"state":      virtualDrive.State,
-			}).Set(1)
+			if target.Host != "" {
+				return fmt.Errorf("%s: %w", target.Host, err)
+			}
+			return err
+		}
+		system.Host = target.Host
+		applySimulations(system)
+		if err := trackState(system); err != nil {
+			return err
+		}
+
+		gathered, err := gatherMetrics(system)
+		if err != nil {
+			return err
+		}
+		families = mergeFamilies(families, gathered)
+		systems = append(systems, system)
+	}
+
+	if *healthFile != "" {
+		if err := writeHealthFile(*healthFile); err != nil {
+			return err
 		}
 	}
 
-	Metrics["ctrl_physical_drives"].With(prometheus.Labels{
-		"controller": controllerIndex,
-	}).Set(float64(controller.ResponseData.PhysicalDrives))
+	if *exportTopology != "" {
+		if err := writeTopology(*exportTopology, systems); err != nil {
+			return err
+		}
+	}
 
-	if controller.ResponseData.PhysicalDrives > 0 {
-		data := getStorcliDrivesJson()
-		driveInfo := data.Controllers[controller.ResponseData.Basics.Controller].ResponseData
-		for _, physicalDrive := range controller.ResponseData.PDList {
-			createMetricsOfPhysicalDrive(physicalDrive, driveInfo, controllerIndex)
+	for _, writer := range enabledWriters() {
+		if err := writer.Write(families); err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
-func createMetricsOfPhysicalDrive(physicalDrive PhysicalDrive, detailedInfoArray map[string]interface{}, controllerIndex string) {
+// trackState compares the collection with the previous one and
+// remembers it for the next.
+func trackState(system *System) error {
 
-	splitEIDSlt := strings.Split(physicalDrive.EIDSlt, ":")
-	enclosure := splitEIDSlt[0]
-	slot := splitEIDSlt[1]
+	state.trackFirmware(system)
 
-	var driveIdentifier string
-	if enclosure == " " {
-		driveIdentifier = fmt.Sprintf("Drive /c%s/s%s", controllerIndex, slot)
-		enclosure = ""
-	} else {
-		driveIdentifier = fmt.Sprintf("Drive /c%s/e%s/s%s", controllerIndex, enclosure, slot)
-	}
+	now := time.Now()
+	findings := evaluateHealth(system)
+	recordHealth(system.Host, findings)
+	events := state.trackFindings(system, findings, now)
+	events = append(events, state.trackErrorCounts(system, now)...)
+	events = append(events, state.trackControllerEvents(system, now)...)
 
-	var info map[string]interface{}
-	switch detailedInfoArray[driveIdentifier+" - Detailed Information"].(type) {
-	case map[string]interface{}:
-		info = detailedInfoArray[driveIdentifier+" - Detailed Information"].(map[string]interface{})
-	default:
-		return
+	if *stateFile != "" {
+		if err := saveState(*stateFile, state); err != nil {
+			return err
+		}
 	}
-	state := info[driveIdentifier+" State"].(map[string]interface{})
-	attributes := info[driveIdentifier+" Device attributes"].(map[string]interface{})
-	settings := info[driveIdentifier+" Policies/Settings"].(map[string]interface{})
-
-	Metrics["pd_shield_counter"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(state["Shield Counter"].(float64))
-	Metrics["pd_media_errors"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(state["Media Error Count"].(float64))
-	Metrics["pd_other_errors"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(state["Other Error Count"].(float64))
-	Metrics["pd_predictive_errors"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(state["Predictive Failure Count"].(float64))
-	var smartAlerted float64
-	if state["S.M.A.R.T alert flagged by drive"].(string) == "Yes" {
-		smartAlerted = 1.0
-	}
-	Metrics["pd_smart_alerted"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(smartAlerted)
-
-	linkSpeedAttr := strings.Split(attributes["Link Speed"].(string), ".")
-	linkSpeed, _ := strconv.ParseFloat(linkSpeedAttr[0], 64)
-	Metrics["pd_link_speed"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(linkSpeed)
-	deviceSpeedAttr := strings.Split(attributes["Device Speed"].(string), ".")
-	deviceSpeed, _ := strconv.ParseFloat(deviceSpeedAttr[0], 64)
-	Metrics["pd_device_speed"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(deviceSpeed)
-
-	var commissionedSpare float64
-	var emergencySpare float64
-	if settings["Commissioned Spare"].(string) == "Yes" {
-		commissionedSpare = 1.0
-	}
-	if settings["Emergency Spare"].(string) == "Yes" {
-		emergencySpare = 1.0
-	}
-	Metrics["pd_commissioned_spare"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(commissionedSpare)
-	Metrics["pd_emergency_spare"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-	}).Set(emergencySpare)
-
-	model := strings.Replace(physicalDrive.Model, " ", "", -1)
-	firmware := strings.Replace(attributes["Firmware Revision"].(string), " ", "", -1)
-	serial := strings.Replace(attributes["SN"].(string), " ", "", -1)
-
-	// Because sometimes it's not part of a device group.
-	var dgFixed string
-	switch v := physicalDrive.DG.(type) {
-	case int:
-		dgFixed = strconv.Itoa(v)
-	case float64:
-		dgFixed = strconv.Itoa(int(v))
-	case string:
-		dgFixed = v
-	default:
-		dgFixed = ""
-	}
-
-	Metrics["pd_info"].With(prometheus.Labels{
-		"controller": controllerIndex,
-		"enclosure":  enclosure,
-		"slot":       slot,
-		"disk_id":    strconv.Itoa(physicalDrive.DID),
-		"interface":  physicalDrive.Intf,
-		"media":      physicalDrive.Med,
-		"model":      model,
-		"DG":         dgFixed,
-		"state":      physicalDrive.State,
-		"firmware":   firmware,
-		"serial":     serial,
-	}).Set(1)
+
+	runHooks(config.Hooks, events)
+	return nil
 }
 
-func main() {
+// nextInterval adds a random jitter to the interval, which keeps a fleet
+// started at the same time from hitting storcli in lockstep.
+func nextInterval(interval time.Duration, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(jitter)))
+}
 
-	var storcliPath = flag.String("storcli_path", "/opt/MegaRAID/storcli/storcli64", "(Optional) Absolute path to StorCLI binary. Defaults to /opt/MegaRAID/storcli/storcli64 or storcli in PATH")
-	var storcliDontfail = flag.Bool("storcli_dontfailover", false, "(Optional) Don't fall back to PATH env if absolute path is missing.")
-	var version = flag.Bool("version", false, "Get version information")
-	var outputFile = flag.String("outfile", "", "Text file to write output to. Defaults to standard output.")
+// Where storcli ends up besides the default path, by GOOS/GOARCH.
+// Broadcom's packages for Arm servers use the 64-bit name too, but
+// distribution and OEM packages scatter the binary around more on those
+// platforms.
+var storcliLocations = map[string][]string{
+	"linux/amd64": {
+		"/opt/MegaRAID/storcli/storcli64",
+		"/usr/sbin/storcli64",
+		"/usr/local/sbin/storcli64",
+	},
+	"linux/arm64": {
+		"/opt/MegaRAID/storcli/storcli64",
+		"/opt/MegaRAID/storcli/storcli",
+		"/usr/sbin/storcli64",
+		"/usr/sbin/storcli",
+		"/usr/local/sbin/storcli64",
+		"/usr/local/sbin/storcli",
+	},
+	// The sysutils/storcli port.
+	"freebsd/amd64": {
+		"/usr/local/sbin/storcli64",
+		"/usr/local/sbin/storcli",
+		"/usr/local/bin/storcli64",
+		"/usr/local/bin/storcli",
+		"/opt/MegaRAID/storcli/storcli64",
+	},
+	"windows/amd64": {
+		`C:\Program Files\MegaRAID\storcli\storcli64.exe`,
+		`C:\Program Files\Broadcom\storcli\storcli64.exe`,
+		`C:\Program Files (x86)\MegaRAID\storcli\storcli64.exe`,
+	},
+}
 
-	flag.Parse()
+// Names searched for in PATH.
+var storcliNames = []string{"storcli64", "storcli"}
 
-	if *version {
-		fmt.Println(Version)
-		os.Exit(0)
-	}
+// findStorcli returns the storcli binary to run.
+func findStorcli(storcliPath string, dontFailover bool) (string, error) {
 
 	// In testing I found that even if storcli is in the user's PATH,
 	// exec.Command won't find it.
-	if _, err := os.Stat(*storcliPath); err == nil {
-		StorcliPath = *storcliPath
-	} else if *storcliDontfail {
-		log.Fatal(err)
-	} else {
-		folders := strings.Split(os.Getenv("PATH"), ":")
-		for _, folder := range folders {
-			executable := fmt.Sprintf("%s/storcli", folder)
-			if _, err := os.Stat(executable); err == nil {
-				StorcliPath = executable
-				break
-			}
-		}
-		if StorcliPath == "" {
-			log.Fatal("storcli not found.")
-		}
+	_, err := os.Stat(hostPath(storcliPath))
+	if err == nil {
+		return storcliPath, nil
+	} else if dontFailover {
+		return "", err
 	}
 
-	getControllers := getStorcliJson()
-
-	reg := prometheus.NewRegistry()
-	for _, v := range Metrics {
-		reg.MustRegister(v)
-	}
-
-	for _, controller := range getControllers.Controllers {
-		handleCommonController(controller)
-		if controller.ResponseData.Version.DriverName == "megaraid_sas" {
-			handleMegaraidController(controller)
+	for _, executable := range storcliLocations[runtime.GOOS+"/"+runtime.GOARCH] {
+		if _, err := os.Stat(hostPath(executable)); err == nil {
+			return executable, nil
 		}
 	}
 
-	if *outputFile != "" {
-		err := os.WriteFile(*outputFile, []byte(printMetrics(reg)), 0644)
-		if err != nil {
-			log.Fatal(err)
+	folders := filepath.SplitList(os.Getenv("PATH"))
+	for _, name := range storcliNames {
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		for _, folder := range folders {
+			executable := filepath.Join(folder, name)
+			if _, err := os.Stat(hostPath(executable)); err == nil {
+				return executable, nil
+			}
 		}
-	} else {
-		fmt.Print(printMetrics(reg))
 	}
+
+	return "", errors.New("storcli not found.")
 }
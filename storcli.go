@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The types in this file mirror storcli's JSON output. They are only used
+// to decode it; everything past parsing works on the normalized model.
+
+// FlexInt is a number that storcli may replace with a placeholder
+// string, like "BBU Status": "NA" on controllers without a BBU. Valid is
+// false for placeholders.
+type FlexInt struct {
+	Value int
+	Valid bool
+}
+
+func (f *FlexInt) UnmarshalJSON(data []byte) error {
+
+	if bytes.Equal(data, []byte("null")) {
+		*f = FlexInt{}
+		return nil
+	}
+
+	var number float64
+	if err := json.Unmarshal(data, &number); err == nil {
+		*f = FlexInt{Value: int(number), Valid: true}
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(text))
+	*f = FlexInt{Value: value, Valid: err == nil}
+	return nil
+}
+
+// FlexString is a string that storcli sometimes prints as a number, like
+// "DG": 0 for drives in a drive group and "DG": "-" for the others.
+type FlexString string
+
+func (f *FlexString) UnmarshalJSON(data []byte) error {
+
+	if bytes.Equal(data, []byte("null")) {
+		*f = ""
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*f = FlexString(text)
+		return nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*f = FlexString(number.String())
+	return nil
+}
+
+type PhysicalDrive struct {
+	EIDSlt string     `json:"EID:Slt"`
+	DID    FlexInt    `json:"DID"`
+	Intf   string     `json:"Intf"`
+	Med    string     `json:"Med"`
+	Size   string     `json:"Size"`
+	Model  string     `json:"Model"`
+	DG     FlexString `json:"DG"`
+	State  string     `json:"State"`
+}
+
+type PhysicalDriveUnpack struct {
+	Controllers []struct {
+		CommandStatus struct {
+			Controller FlexInt `json:"Controller"`
+		} `json:"Command Status"`
+		ResponseData map[string]interface{} `json:"Response Data"`
+	} `json:"Controllers"`
+}
+
+// ByController indexes the drive details by controller number.
+func (p PhysicalDriveUnpack) ByController() map[int]map[string]interface{} {
+	indexed := map[int]map[string]interface{}{}
+	for _, controller := range p.Controllers {
+		indexed[controller.CommandStatus.Controller.Value] = controller.ResponseData
+	}
+	return indexed
+}
+
+type Controller struct {
+	CommandStatus struct {
+		Status string `json:"Status"`
+	} `json:"Command Status"`
+	ResponseData struct {
+		Basics struct {
+			Controller     FlexInt `json:"Controller"`
+			Model          string  `json:"Model"`
+			SerialNumber   string  `json:"Serial Number"`
+			ControllerDate string  `json:"Current Controller Date/Time"`
+			SystemDate     string  `json:"Current System Date/time"`
+		} `json:"Basics"`
+		Version struct {
+			DriverName      string `json:"Driver Name"`
+			DriverVersion   string `json:"Driver Version"`
+			FirmwareVersion string `json:"Firmware Version"`
+		} `json:"Version"`
+		Status struct {
+			ControllerStatus string  `json:"Controller Status"`
+			BBUStatus        FlexInt `json:"BBU Status"`
+			// Older firmware says lock key, some report both.
+			LockKeyAssigned     string `json:"Lock Key Assigned"`
+			SecurityKeyAssigned string `json:"Security Key Assigned"`
+		} `json:"Status"`
+		SupportedAdapterOperations struct {
+			SupportSecurity string `json:"Support Security"`
+			SupportEKM      string `json:"support EKM"`
+		} `json:"Supported Adapter Operations"`
+		HwCfg struct {
+			BackendPortCount FlexInt `json:"Backend Port Count"`
+			ROCTempCelsius   FlexInt `json:"ROC temperature(Degree Celsius)"`
+		} `json:"HwCfg"`
+		// Keyed by task, which differs between vendors.
+		ScheduledTasks map[string]interface{} `json:"Scheduled Tasks"`
+		DriveGroups    FlexInt                `json:"Drive Groups"`
+		VirtualDrives  FlexInt                `json:"Virtual Drives"`
+		VDList         []struct {
+			DG_VD string `json:"DG/VD"`
+			Name  string `json:"Name"`
+			Cache string `json:"Cache"`
+			Type  string `json:"TYPE"`
+			State string `json:"State"`
+		} `json:"VD LIST"`
+		PhysicalDrives FlexInt         `json:"Physical Drives"`
+		PDList         []PhysicalDrive `json:"PD LIST"`
+		// The controller's own summary of its devices.
+		DevicePresent struct {
+			Degraded      FlexInt `json:"Degraded"`
+			Offline       FlexInt `json:"Offline"`
+			CriticalDisks FlexInt `json:"Critical Disks"`
+			FailedDisks   FlexInt `json:"Failed Disks"`
+		} `json:"Device Present"`
+		CachevaultInfo []struct {
+			Temp string `json:"Temp"`
+		} `json:"Cachevault_Info"`
+		BBUInfo []struct {
+			Temp string `json:"Temp"`
+		} `json:"BBU_Info"`
+	} `json:"Response Data"`
+}
+
+type ControllerData struct {
+	Controllers []Controller `json:"Controllers"`
+}
+
+type ControllerCount struct {
+	Controllers []struct {
+		CommandStatus struct {
+			Status string `json:"Status"`
+		} `json:"Command Status"`
+		ResponseData struct {
+			ControllerCount FlexInt `json:"Controller Count"`
+		} `json:"Response Data"`
+	} `json:"Controllers"`
+}
+
+func parseControllerCount(data []byte) (int, error) {
+
+	var count ControllerCount
+	err := json.Unmarshal(data, &count)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(count.Controllers) == 0 || count.Controllers[0].CommandStatus.Status != "Success" || count.Controllers[0].ResponseData.ControllerCount.Value == 0 {
+		return 0, errors.New("Could not find controllers in output.")
+	}
+
+	return count.Controllers[0].ResponseData.ControllerCount.Value, nil
+}
+
+// parseCLIVersion returns the storcli version every output starts with,
+// e.g. 007.1017.0000.0000, or an empty string if there is none.
+func parseCLIVersion(data []byte) string {
+
+	var version string
+	if looksLikeText(data) {
+		for _, line := range strings.Split(string(data), "\n") {
+			key, value, found := strings.Cut(line, "=")
+			if found && strings.TrimSpace(key) == "CLI Version" {
+				version = value
+			}
+		}
+	} else {
+		var output struct {
+			Controllers []struct {
+				CommandStatus struct {
+					CLIVersion string `json:"CLI Version"`
+				} `json:"Command Status"`
+			} `json:"Controllers"`
+		}
+		if json.Unmarshal(data, &output) == nil && len(output.Controllers) > 0 {
+			version = output.Controllers[0].CommandStatus.CLIVersion
+		}
+	}
+
+	// The build date that follows doesn't add anything.
+	if fields := strings.Fields(version); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+func parseControllers(data []byte) (ControllerData, error) {
+
+	data, err := normalizeJSON(data)
+	if err != nil {
+		return ControllerData{}, err
+	}
+
+	var getControllers ControllerData
+	err = json.Unmarshal(data, &getControllers)
+	if err != nil {
+		return getControllers, err
+	}
+
+	if len(getControllers.Controllers) == 0 || getControllers.Controllers[0].CommandStatus.Status != "Success" {
+		return getControllers, errors.New("Could not find controllers in output.")
+	}
+
+	return getControllers, nil
+}
+
+func parseDrives(r io.Reader) (PhysicalDriveUnpack, error) {
+
+	var jsonOutput PhysicalDriveUnpack
+	err := json.NewDecoder(r).Decode(&jsonOutput)
+	for _, controller := range jsonOutput.Controllers {
+		normalizeKeys(controller.ResponseData)
+	}
+
+	return jsonOutput, err
+}
+
+// Keys storcli spells differently between versions, mapped to the
+// spelling the types in this file use. Field names already match case
+// insensitively, so only the drive details, which are decoded into maps,
+// need the case variants.
+var keyVariants = map[string]string{
+	"ROC temperature(Degree Celcius)":  "ROC temperature(Degree Celsius)",
+	"Current System Date/Time":         "Current System Date/time",
+	"Current Controller Date/time":     "Current Controller Date/Time",
+	"S.M.A.R.T alert flagged by Drive": "S.M.A.R.T alert flagged by drive",
+	"Firmware revision":                "Firmware Revision",
+	"Link speed":                       "Link Speed",
+	"Device speed":                     "Device Speed",
+}
+
+// normalizeJSON rewrites known key variants to their canonical spelling.
+func normalizeJSON(data []byte) ([]byte, error) {
+
+	var tree interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keep large numbers such as serials exactly as printed.
+	decoder.UseNumber()
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	normalizeKeys(tree)
+
+	return json.Marshal(tree)
+}
+
+// normalizeKeys renames key variants in place. When both spellings are
+// present, the canonical one wins.
+func normalizeKeys(value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			normalizeKeys(child)
+			canonical, ok := keyVariants[key]
+			if !ok {
+				continue
+			}
+			if _, exists := value[canonical]; !exists {
+				value[canonical] = child
+			}
+			delete(value, key)
+		}
+	case []interface{}:
+		for _, child := range value {
+			normalizeKeys(child)
+		}
+	}
+}
+
+// The PHY error counters, keyed by how storcli names them.
+var phyErrorTypes = map[string]string{
+	"invalid dword count":           "invalid_dword",
+	"running disparity error count": "running_disparity",
+	"loss of dword sync count":      "loss_of_dword_sync",
+	"phy reset problem count":       "phy_reset_problem",
+}
+
+// parsePhyErrors reads "/cN/pALL show phyerrorcounters J" of an HBA. The
+// layout of the response differs between storcli versions, so every
+// object with a PHY number is searched for known counters.
+func parsePhyErrors(data []byte) ([]PhyErrorCount, error) {
+
+	var output struct {
+		Controllers []struct {
+			CommandStatus struct {
+				Status string `json:"Status"`
+			} `json:"Command Status"`
+			ResponseData interface{} `json:"Response Data"`
+		} `json:"Controllers"`
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, err
+	}
+	if len(output.Controllers) == 0 || output.Controllers[0].CommandStatus.Status != "Success" {
+		return nil, errors.New("Could not find PHY error counters in output.")
+	}
+
+	var counts []PhyErrorCount
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case []interface{}:
+			for _, child := range value {
+				walk(child)
+			}
+		case map[string]interface{}:
+			phy, isPhy := value["PHY"].(float64)
+			if !isPhy {
+				phy, isPhy = value["Phy"].(float64)
+			}
+			for key, child := range value {
+				errorType, known := phyErrorTypes[strings.ToLower(key)]
+				count, isNumber := child.(float64)
+				if isPhy && known && isNumber {
+					counts = append(counts, PhyErrorCount{Phy: int(phy), Type: errorType, Count: count})
+					continue
+				}
+				walk(child)
+			}
+		}
+	}
+	walk(output.Controllers[0].ResponseData)
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Phy != counts[j].Phy {
+			return counts[i].Phy < counts[j].Phy
+		}
+		return counts[i].Type < counts[j].Type
+	})
+
+	return counts, nil
+}
+
+// parseKeyManagement returns the active key source of show securitykey,
+// ekms or local, and nothing if the output doesn't say. Firmware words
+// it differently, so any field about the key mode or source counts, as
+// fields or as rows of a property table with a Value column.
+func parseKeyManagement(data []byte) string {
+
+	var output struct {
+		Controllers []struct {
+			ResponseData interface{} `json:"Response Data"`
+		} `json:"Controllers"`
+	}
+	if json.Unmarshal(data, &output) != nil || len(output.Controllers) == 0 {
+		return ""
+	}
+
+	aboutKeyMode := func(name string) bool {
+		name = strings.ToLower(name)
+		return strings.Contains(name, "mode") || strings.Contains(name, "source") || strings.Contains(name, "management")
+	}
+	mode := ""
+	classify := func(value string) {
+		switch value = strings.ToLower(value); {
+		case strings.Contains(value, "ekm") || strings.Contains(value, "external"):
+			mode = "ekms"
+		case (strings.Contains(value, "local") || strings.Contains(value, "lkm")) && mode == "":
+			mode = "local"
+		}
+	}
+
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if row, ok := value["Value"].(string); ok {
+				for key, name := range value {
+					if name, ok := name.(string); ok && key != "Value" && aboutKeyMode(name) {
+						classify(row)
+					}
+				}
+			}
+			for key, child := range value {
+				if text, ok := child.(string); ok {
+					if aboutKeyMode(key) {
+						classify(text)
+					}
+					continue
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, item := range value {
+				walk(item)
+			}
+		}
+	}
+	walk(output.Controllers[0].ResponseData)
+	return mode
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseKeyManagement(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		responseData string
+		mode         string
+	}{
+		{"field", `{"Security Key Mode": "Local Key Management"}`, "local"},
+		{"lkm", `{"Key Management Mode": "LKM"}`, "local"},
+		{"ekm field", `{"Key Management Mode": "EKM"}`, "ekms"},
+		{"external source", `{"Key Source": "External"}`, "ekms"},
+		{"nested", `{"Security Key": {"Key Mode": "Local"}}`, "local"},
+		{"property table", `{"Controller Properties": [
+			{"Ctrl_Prop": "SecurityKey Assigned", "Value": "Yes"},
+			{"Ctrl_Prop": "Key Management Mode", "Value": "EKMS"}]}`, "ekms"},
+		{"external wins", `{"Key Source": "Local", "Key Management": "External"}`, "ekms"},
+		{"other fields", `{"SecurityKey Assigned": "Local"}`, ""},
+		{"table without mode", `{"Controller Properties": [{"Ctrl_Prop": "Lock Key Id", "Value": "local"}]}`, ""},
+		{"empty", `{}`, ""},
+	}
+
+	for _, test := range tests {
+		data := `{"Controllers": [{"Command Status": {"Status": "Success"}, "Response Data": ` + test.responseData + `}]}`
+		if mode := parseKeyManagement([]byte(data)); mode != test.mode {
+			t.Errorf("%s: got %q, want %q", test.name, mode, test.mode)
+		}
+	}
+
+	for _, data := range []string{"", "not json", `{"Controllers": []}`} {
+		if mode := parseKeyManagement([]byte(data)); mode != "" {
+			t.Errorf("%q: got %q, want none", data, mode)
+		}
+	}
+}
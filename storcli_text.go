@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Old storcli and firmware combinations don't know the J switch. Their
+// plain text output is turned into the same tree the JSON output has,
+// so everything past parsing works unchanged. Drive details aren't read
+// in this mode.
+
+// looksLikeText reports whether storcli answered with something other
+// than JSON, as opposed to not answering at all.
+func looksLikeText(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] != '{'
+}
+
+func parseTextControllerCount(data []byte) (int, error) {
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if found && strings.TrimSpace(key) == "Controller Count" {
+			count, err := strconv.Atoi(strings.TrimSpace(value))
+			if err == nil && count > 0 {
+				return count, nil
+			}
+		}
+	}
+
+	return 0, errors.New("Could not find controllers in output.")
+}
+
+// parseTextControllers reads the output of "/cN show all". It consists
+// of "key = value" lines, grouped into sections headed by "Name :" and
+// an underline, and tables framed by dashed lines.
+func parseTextControllers(data []byte) (ControllerData, error) {
+
+	commandStatus := map[string]interface{}{}
+	responseData := map[string]interface{}{}
+
+	// Lines before the first section describe the command itself.
+	current := commandStatus
+	var sectionName string
+	var header []string
+	// Tables are framed by three dashed lines: above and below the
+	// header and below the last row.
+	rules := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			// A blank line ends a section, unless it is the one
+			// between a section name and its table.
+			if rules == 0 && sectionName != "" && len(current) > 0 {
+				current = responseData
+				sectionName = ""
+			}
+			continue
+		case strings.Trim(line, "=") == "":
+			continue
+		case strings.Trim(line, "-") == "":
+			rules++
+			if rules == 3 {
+				rules = 0
+				header = nil
+				sectionName = ""
+				current = responseData
+			}
+			continue
+		}
+
+		if rules == 1 {
+			header = strings.Fields(line)
+			continue
+		}
+		if rules == 2 {
+			rows, _ := responseData[sectionName].([]interface{})
+			responseData[sectionName] = append(rows, parseTextRow(header, strings.Fields(line)))
+			continue
+		}
+
+		if name, found := strings.CutSuffix(line, " :"); found {
+			sectionName = name
+			section := map[string]interface{}{}
+			responseData[name] = section
+			current = section
+			continue
+		}
+
+		key, value, found := strings.Cut(line, " = ")
+		if !found {
+			continue
+		}
+		current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	if commandStatus["Status"] == nil {
+		return ControllerData{}, errors.New("Could not find controllers in output.")
+	}
+
+	// Tables replaced their section map, other sections stay objects.
+	tree := map[string]interface{}{
+		"Controllers": []interface{}{
+			map[string]interface{}{
+				"Command Status": commandStatus,
+				"Response Data":  responseData,
+			},
+		},
+	}
+	converted, err := json.Marshal(tree)
+	if err != nil {
+		return ControllerData{}, err
+	}
+
+	return parseControllers(converted)
+}
+
+// Units that storcli prints as a separate word after a size.
+var sizeUnits = map[string]bool{"B": true, "KB": true, "MB": true, "GB": true, "TB": true, "PB": true}
+
+// parseTextRow maps the words of a table row onto the header. Sizes are
+// printed as two words, and model and VD names may contain spaces, so
+// surplus words go to the Model column, or the last one if there is
+// none.
+func parseTextRow(header []string, fields []string) map[string]interface{} {
+
+	var merged []string
+	for i := 0; i < len(fields); i++ {
+		if i+1 < len(fields) && sizeUnits[fields[i+1]] {
+			if _, err := strconv.ParseFloat(fields[i], 64); err == nil {
+				merged = append(merged, fields[i]+" "+fields[i+1])
+				i++
+				continue
+			}
+		}
+		merged = append(merged, fields[i])
+	}
+
+	absorb := len(header) - 1
+	for i, column := range header {
+		if column == "Model" {
+			absorb = i
+		}
+	}
+	if surplus := len(merged) - len(header); surplus > 0 {
+		joined := strings.Join(merged[absorb:absorb+surplus+1], " ")
+		merged = append(append(merged[:absorb:absorb], joined), merged[absorb+surplus+1:]...)
+	}
+
+	row := map[string]interface{}{}
+	for i, column := range header {
+		if i < len(merged) {
+			row[column] = merged[i]
+		} else {
+			row[column] = ""
+		}
+	}
+
+	return row
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTextRow(t *testing.T) {
+
+	pdHeader := strings.Fields("EID:Slt DID State DG Size Intf Med SED PI SeSz Model Sp")
+	vdHeader := strings.Fields("DG/VD TYPE State Access Consist Cache sCC Size Name")
+
+	tests := []struct {
+		name   string
+		header []string
+		line   string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "size with unit",
+			header: pdHeader,
+			line:   "32:2      2 UGood  - 558.375 GB SAS  HDD N   N  512B ST600MM0006           U",
+			want: map[string]interface{}{
+				"EID:Slt": "32:2", "DID": "2", "State": "UGood", "DG": "-", "Size": "558.375 GB",
+				"Intf": "SAS", "Med": "HDD", "SED": "N", "PI": "N", "SeSz": "512B", "Model": "ST600MM0006", "Sp": "U",
+			},
+		},
+		{
+			name:   "model with spaces",
+			header: pdHeader,
+			line:   "32:0      0 Onln   0 446.625 GB SATA SSD N   N  512B SAMSUNG MZ7LH480HAHQ-00005 U",
+			want: map[string]interface{}{
+				"EID:Slt": "32:0", "DID": "0", "State": "Onln", "DG": "0", "Size": "446.625 GB",
+				"Intf": "SATA", "Med": "SSD", "SED": "N", "PI": "N", "SeSz": "512B", "Model": "SAMSUNG MZ7LH480HAHQ-00005", "Sp": "U",
+			},
+		},
+		{
+			name:   "name with spaces in the last column",
+			header: vdHeader,
+			line:   "0/0   RAID1 Optl  RW     Yes     RWBD  -   446.625 GB boot volume",
+			want: map[string]interface{}{
+				"DG/VD": "0/0", "TYPE": "RAID1", "State": "Optl", "Access": "RW", "Consist": "Yes",
+				"Cache": "RWBD", "sCC": "-", "Size": "446.625 GB", "Name": "boot volume",
+			},
+		},
+		{
+			name:   "missing trailing columns",
+			header: vdHeader,
+			line:   "0/0   RAID1 Optl  RW     Yes     RWBD  -   446.625 GB",
+			want: map[string]interface{}{
+				"DG/VD": "0/0", "TYPE": "RAID1", "State": "Optl", "Access": "RW", "Consist": "Yes",
+				"Cache": "RWBD", "sCC": "-", "Size": "446.625 GB", "Name": "",
+			},
+		},
+		{
+			name:   "unit without a number stays a word",
+			header: []string{"A", "B", "C"},
+			line:   "x GB y",
+			want:   map[string]interface{}{"A": "x", "B": "GB", "C": "y"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseTextRow(test.header, strings.Fields(test.line))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
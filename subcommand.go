@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// Subcommand is an alternative to exporting metrics, run as
+// "storcli-collector <name> [flags]". Besides its own flags it accepts
+// every global flag, so storcli is found and queried the same way.
+type Subcommand struct {
+	Flags *flag.FlagSet
+	Run   func(source Source) error
+	// Exit code if the collector can't even start, e.g. because
+	// storcli is missing. Zero means 1.
+	ExitCode int
+	// Works on files alone and runs without storcli, Run gets a nil
+	// Source.
+	NoSource bool
+}
+
+var subcommands = map[string]*Subcommand{}
+
+func RegisterSubcommand(name string, subcommand *Subcommand) {
+	if _, exists := subcommands[name]; exists {
+		panic(fmt.Sprintf("subcommand %q registered twice", name))
+	}
+	subcommands[name] = subcommand
+}
+
+// parseArgs parses the command line and returns the subcommand it names,
+// or nil for the default of exporting metrics.
+func parseArgs(args []string) *Subcommand {
+
+	if len(args) == 0 || subcommands[args[0]] == nil {
+		flag.CommandLine.Parse(args)
+		return nil
+	}
+
+	subcommand := subcommands[args[0]]
+	flag.VisitAll(func(f *flag.Flag) {
+		subcommand.Flags.Var(f.Value, f.Name, f.Usage)
+	})
+	subcommand.Flags.Parse(args[1:])
+
+	return subcommand
+}
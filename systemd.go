@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var systemdSocket = flag.Bool("systemd-socket", false, "Serve HTTP on the socket passed by systemd socket activation instead of --listen-address.")
+
+// systemd services of Type=notify are told when the collector is ready,
+// and with WatchdogSec= it is restarted when the watchdog isn't pinged
+// in time. The watchdog is only pinged while no collection has been
+// running for longer than WatchdogSec, so a storcli call that hangs gets
+// the collector restarted.
+
+// sdNotify sends a state like READY=1 to systemd. Without NOTIFY_SOCKET
+// the collector isn't run by systemd and it does nothing.
+func sdNotify(state string) error {
+
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets start with a NUL byte.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the WatchdogSec= of the service, if it's set
+// for this process.
+func watchdogInterval() (time.Duration, bool) {
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// Running collections by when they started, for the watchdog.
+var runningCollections = struct {
+	sync.Mutex
+	started map[*time.Time]bool
+}{started: map[*time.Time]bool{}}
+
+// beginCollection marks a collection as running until the returned
+// function is called.
+func beginCollection() func() {
+
+	started := time.Now()
+	runningCollections.Lock()
+	runningCollections.started[&started] = true
+	runningCollections.Unlock()
+
+	return func() {
+		runningCollections.Lock()
+		delete(runningCollections.started, &started)
+		runningCollections.Unlock()
+	}
+}
+
+// oldestCollection returns when the longest running collection started.
+func oldestCollection() (time.Time, bool) {
+
+	runningCollections.Lock()
+	defer runningCollections.Unlock()
+
+	var oldest time.Time
+	for started := range runningCollections.started {
+		if oldest.IsZero() || started.Before(oldest) {
+			oldest = *started
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
+
+// notifyReady tells systemd the collector is up and starts pinging its
+// watchdog.
+func notifyReady() {
+
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Notifying systemd: %v", err)
+	}
+
+	timeout, enabled := watchdogInterval()
+	if !enabled {
+		return
+	}
+	go func() {
+		stuck := false
+		for range time.Tick(timeout / 2) {
+			if started, running := oldestCollection(); running && time.Since(started) > timeout {
+				if !stuck {
+					log.Printf("Collection running since %s, no longer pinging the systemd watchdog", started.Format(time.RFC3339))
+				}
+				stuck = true
+				continue
+			}
+			stuck = false
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("Pinging the systemd watchdog: %v", err)
+			}
+		}
+	}()
+}
+
+// systemdListener returns the socket of a socket activated service.
+// systemd passes it as file descriptor 3 and says so in LISTEN_FDS.
+func systemdListener() (net.Listener, error) {
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("--systemd-socket: not started by a systemd socket unit")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("--systemd-socket: systemd passed no socket")
+	}
+	if count > 1 {
+		return nil, fmt.Errorf("--systemd-socket: systemd passed %d sockets, the socket unit should have one ListenStream=", count)
+	}
+	// storcli and the hooks mustn't think they were passed the socket.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(3, "systemd-socket")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("--systemd-socket: %w", err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"regexp"
+	"strconv"
+)
+
+var collectTermLog = flag.Bool("collect-termlog", false, "Export the number of firmware error lines in the controller TTY log. Adds one storcli call per controller.")
+
+var termLogErrorPattern = regexp.MustCompile(`(?i)\b(exception|error)\b`)
+
+func queryTermLog(source Source, controllerIndex int) ([]byte, error) {
+	return source.Query("/c"+strconv.Itoa(controllerIndex), "show", "termlog", "type=contents")
+}
+
+// countTermLogErrors counts the firmware exception and error lines of
+// the TTY log. Some faults never show up anywhere else.
+func countTermLogErrors(data []byte) int {
+
+	var errors int
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if termLogErrorPattern.Match(scanner.Bytes()) {
+			errors++
+		}
+	}
+
+	return errors
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestCountTermLogErrors(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		log    string
+		errors int
+	}{
+		{"empty", "", 0},
+		{"quiet", "10/16/26  3:04:05: EVT#06915-10/16/26  3:04:05:  44=Time established\n", 0},
+		{"errors", `10/16/26  3:04:05: C0:Exception in ISR, addr 0x1234
+10/16/26  3:04:06: C0:Unrecoverable ERROR on PD 01
+10/16/26  3:04:07: C0:errorless recovery done
+10/16/26  3:04:08: C0:medium error count 3
+`, 3},
+	}
+
+	for _, test := range tests {
+		if errors := countTermLogErrors([]byte(test.log)); errors != test.errors {
+			t.Errorf("%s: got %d, want %d", test.name, errors, test.errors)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var exportTopology = flag.String("export-topology", "", "Also write the controllers, enclosures, drive groups, virtual drives and drives as a JSON document to this file, for CMDBs like NetBox.")
+
+// The topology is the layout of the storage rather than its health, in
+// a shape that asset systems can import without knowing storcli. Every
+// object has an id, its storcli address, which stays the same across
+// runs and is what the other outputs call it.
+
+type Topology struct {
+	Generated time.Time      `json:"generated"`
+	Hosts     []TopologyHost `json:"hosts"`
+}
+
+type TopologyHost struct {
+	// Empty for the local machine without --add-hostname-label.
+	Host        string               `json:"host,omitempty"`
+	Controllers []TopologyController `json:"controllers"`
+}
+
+type TopologyController struct {
+	ID              string               `json:"id"`
+	Index           int                  `json:"index"`
+	Model           string               `json:"model"`
+	Serial          string               `json:"serial"`
+	FirmwareVersion string               `json:"firmware_version"`
+	DriverName      string               `json:"driver_name"`
+	DriverVersion   string               `json:"driver_version"`
+	Enclosures      []TopologyEnclosure  `json:"enclosures"`
+	DriveGroups     []TopologyDriveGroup `json:"drive_groups"`
+}
+
+type TopologyEnclosure struct {
+	ID string `json:"id"`
+	// Empty for drives attached to the controller directly.
+	Enclosure string          `json:"enclosure"`
+	Drives    []TopologyDrive `json:"drives"`
+}
+
+// TopologyDriveGroup is an array of drives and the virtual drives carved
+// out of it. Drives are listed by id, their details are in the
+// enclosures.
+type TopologyDriveGroup struct {
+	ID            string                 `json:"id"`
+	VirtualDrives []TopologyVirtualDrive `json:"virtual_drives"`
+	Drives        []string               `json:"drives"`
+}
+
+type TopologyVirtualDrive struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	RAID  string `json:"raid"`
+	State string `json:"state"`
+	Cache string `json:"cache"`
+}
+
+type TopologyDrive struct {
+	ID        string `json:"id"`
+	Slot      string `json:"slot"`
+	Model     string `json:"model"`
+	Serial    string `json:"serial"`
+	Firmware  string `json:"firmware"`
+	Size      string `json:"size"`
+	Media     string `json:"media"`
+	Interface string `json:"interface"`
+	State     string `json:"state"`
+	// Empty for drives that aren't in a drive group, like spares.
+	DriveGroup string `json:"drive_group,omitempty"`
+}
+
+// buildTopology arranges the collected systems as a Topology.
+func buildTopology(systems []*System, now time.Time) Topology {
+
+	serial := func(serial string) string {
+		if *anonymizeSerials {
+			return anonymizeSerial(serial)
+		}
+		return serial
+	}
+
+	topology := Topology{Generated: now.UTC(), Hosts: []TopologyHost{}}
+	for _, system := range systems {
+		host := TopologyHost{Host: system.Host, Controllers: []TopologyController{}}
+		if host.Host == "" {
+			host.Host = configLabels()["hostname"]
+		}
+
+		for _, controller := range system.Controllers {
+			entry := TopologyController{
+				ID:              controllerObject(controller),
+				Index:           controller.Index,
+				Model:           controller.Model,
+				Serial:          serial(controller.Serial),
+				FirmwareVersion: controller.FirmwareVersion,
+				DriverName:      controller.DriverName,
+				DriverVersion:   controller.DriverVersion,
+				Enclosures:      []TopologyEnclosure{},
+				DriveGroups:     []TopologyDriveGroup{},
+			}
+
+			enclosures := map[string]*TopologyEnclosure{}
+			groups := map[string]*TopologyDriveGroup{}
+			group := func(id string) *TopologyDriveGroup {
+				if groups[id] == nil {
+					groups[id] = &TopologyDriveGroup{
+						ID:            entry.ID + "/d" + id,
+						VirtualDrives: []TopologyVirtualDrive{},
+						Drives:        []string{},
+					}
+				}
+				return groups[id]
+			}
+
+			for _, virtualDrive := range controller.VirtualDrives {
+				dg := group(virtualDrive.DriveGroup)
+				dg.VirtualDrives = append(dg.VirtualDrives, TopologyVirtualDrive{
+					ID:    virtualDriveObject(controller, virtualDrive),
+					Name:  virtualDrive.Name,
+					RAID:  virtualDrive.Type,
+					State: virtualDrive.State,
+					Cache: virtualDrive.Cache,
+				})
+			}
+
+			for _, drive := range controller.PhysicalDrives {
+				if enclosures[drive.Enclosure] == nil {
+					id := entry.ID
+					if drive.Enclosure != "" {
+						id += "/e" + drive.Enclosure
+					}
+					enclosures[drive.Enclosure] = &TopologyEnclosure{ID: id, Enclosure: drive.Enclosure, Drives: []TopologyDrive{}}
+				}
+				object := physicalDriveObject(controller, drive)
+				inGroup := isDriveGroup(drive.DriveGroup)
+				topologyDrive := TopologyDrive{
+					ID:        object,
+					Slot:      drive.Slot,
+					Model:     drive.Model,
+					Serial:    serial(drive.Serial),
+					Firmware:  drive.Firmware,
+					Size:      drive.Size,
+					Media:     drive.Media,
+					Interface: drive.Interface,
+					State:     drive.State,
+				}
+				if inGroup {
+					dg := group(drive.DriveGroup)
+					topologyDrive.DriveGroup = dg.ID
+					dg.Drives = append(dg.Drives, object)
+				}
+				enclosures[drive.Enclosure].Drives = append(enclosures[drive.Enclosure].Drives, topologyDrive)
+			}
+
+			for _, id := range sortedKeys(enclosures) {
+				entry.Enclosures = append(entry.Enclosures, *enclosures[id])
+			}
+			for _, id := range sortedKeys(groups) {
+				entry.DriveGroups = append(entry.DriveGroups, *groups[id])
+			}
+			host.Controllers = append(host.Controllers, entry)
+		}
+		topology.Hosts = append(topology.Hosts, host)
+	}
+
+	return topology
+}
+
+// isDriveGroup reports whether the DG column of a drive names a drive
+// group, rather than "-" for none or "F" for foreign.
+func isDriveGroup(dg string) bool {
+	_, err := strconv.Atoi(dg)
+	return err == nil
+}
+
+// sortedKeys orders numeric keys by value and puts the others first.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.Atoi(keys[i])
+		b, errB := strconv.Atoi(keys[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		if (errA == nil) != (errB == nil) {
+			return errA != nil
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func writeTopology(path string, systems []*System) error {
+	data, err := json.MarshalIndent(buildTopology(systems, time.Now()), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
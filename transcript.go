@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandRecord describes a single storcli query of a collection.
+type CommandRecord struct {
+	Command  string  `json:"command"`
+	Duration float64 `json:"duration_seconds"`
+	ExitCode int     `json:"exit_code"`
+	Bytes    int     `json:"bytes"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Transcript is what happened during one collection, for answering
+// "why are my metrics missing" without shell access to the host.
+type Transcript struct {
+	Started  time.Time       `json:"started"`
+	Duration float64         `json:"duration_seconds"`
+	Error    string          `json:"error,omitempty"`
+	Commands []CommandRecord `json:"commands"`
+}
+
+// RecordingSource passes queries through and records them.
+type RecordingSource struct {
+	Source Source
+
+	mu         sync.Mutex
+	transcript Transcript
+}
+
+func NewRecordingSource(source Source) *RecordingSource {
+	return &RecordingSource{
+		Source:     source,
+		transcript: Transcript{Started: time.Now(), Commands: []CommandRecord{}},
+	}
+}
+
+func (r *RecordingSource) Query(args ...string) ([]byte, error) {
+
+	start := time.Now()
+	data, err := r.Source.Query(args...)
+	r.record(args, start, len(data), err)
+
+	return data, err
+}
+
+// QueryStream records the query once its output has been read and
+// closed.
+func (r *RecordingSource) QueryStream(args ...string) (io.ReadCloser, error) {
+
+	start := time.Now()
+	output, err := queryStream(r.Source, args...)
+	if err != nil {
+		r.record(args, start, 0, err)
+		return nil, err
+	}
+
+	return &recordedOutput{ReadCloser: output, recorder: r, args: args, start: start}, nil
+}
+
+func (r *RecordingSource) record(args []string, start time.Time, size int, err error) {
+
+	record := CommandRecord{
+		Command:  strings.Join(args, " "),
+		Duration: time.Since(start).Seconds(),
+		Bytes:    size,
+	}
+	if err != nil {
+		record.Error = err.Error()
+		record.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			record.ExitCode = exitErr.ExitCode()
+		}
+	}
+
+	r.mu.Lock()
+	r.transcript.Commands = append(r.transcript.Commands, record)
+	r.mu.Unlock()
+}
+
+type recordedOutput struct {
+	io.ReadCloser
+	recorder *RecordingSource
+	args     []string
+	start    time.Time
+	size     int
+}
+
+func (o *recordedOutput) Read(p []byte) (int, error) {
+	n, err := o.ReadCloser.Read(p)
+	o.size += n
+	return n, err
+}
+
+func (o *recordedOutput) Close() error {
+	err := o.ReadCloser.Close()
+	o.recorder.record(o.args, o.start, o.size, err)
+	return err
+}
+
+// Finish closes the transcript with the outcome of the collection.
+func (r *RecordingSource) Finish(err error) Transcript {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.transcript.Duration = time.Since(r.transcript.Started).Seconds()
+	if err != nil {
+		r.transcript.Error = err.Error()
+	}
+
+	return r.transcript
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+
+	RegisterSubcommand("validate", &Subcommand{
+		Flags: flags,
+		Run: func(source Source) error {
+			report := &schemaReport{w: os.Stdout}
+			validate(source, report)
+			if report.problems > 0 {
+				return fmt.Errorf("%d problems found", report.problems)
+			}
+			fmt.Fprintln(report.w, "No problems found")
+			return nil
+		},
+	})
+}
+
+// expectedField is a field of storcli's JSON output the collector reads.
+// Missing optional fields only cost a metric on some hardware, like the
+// BBU status on controllers without one.
+type expectedField struct {
+	Path     []string
+	Type     string
+	Optional bool
+}
+
+// Types are those of JSON, several allowed ones separated by " or ".
+var expectedControllerFields = []expectedField{
+	{Path: []string{"Command Status", "Status"}, Type: "string"},
+	{Path: []string{"Response Data", "Basics", "Controller"}, Type: "number or string"},
+	{Path: []string{"Response Data", "Basics", "Model"}, Type: "string"},
+	{Path: []string{"Response Data", "Basics", "Serial Number"}, Type: "string"},
+	{Path: []string{"Response Data", "Basics", "Current Controller Date/Time"}, Type: "string", Optional: true},
+	{Path: []string{"Response Data", "Basics", "Current System Date/time"}, Type: "string", Optional: true},
+	{Path: []string{"Response Data", "Version", "Driver Name"}, Type: "string", Optional: true},
+	{Path: []string{"Response Data", "Version", "Driver Version"}, Type: "string", Optional: true},
+	{Path: []string{"Response Data", "Version", "Firmware Version"}, Type: "string"},
+	{Path: []string{"Response Data", "Status", "Controller Status"}, Type: "string"},
+	{Path: []string{"Response Data", "Status", "BBU Status"}, Type: "number or string", Optional: true},
+	{Path: []string{"Response Data", "HwCfg", "Backend Port Count"}, Type: "number or string", Optional: true},
+	{Path: []string{"Response Data", "HwCfg", "ROC temperature(Degree Celsius)"}, Type: "number or string", Optional: true},
+	{Path: []string{"Response Data", "Scheduled Tasks"}, Type: "object", Optional: true},
+	{Path: []string{"Response Data", "Virtual Drives"}, Type: "number or string", Optional: true},
+	{Path: []string{"Response Data", "VD LIST"}, Type: "array", Optional: true},
+	{Path: []string{"Response Data", "Physical Drives"}, Type: "number or string"},
+	{Path: []string{"Response Data", "PD LIST"}, Type: "array", Optional: true},
+}
+
+var expectedVirtualDriveFields = []expectedField{
+	{Path: []string{"DG/VD"}, Type: "string"},
+	{Path: []string{"TYPE"}, Type: "string"},
+	{Path: []string{"State"}, Type: "string"},
+	{Path: []string{"Cache"}, Type: "string", Optional: true},
+	{Path: []string{"Name"}, Type: "string", Optional: true},
+}
+
+var expectedPhysicalDriveFields = []expectedField{
+	{Path: []string{"EID:Slt"}, Type: "string"},
+	{Path: []string{"DID"}, Type: "number or string"},
+	{Path: []string{"State"}, Type: "string"},
+	{Path: []string{"Model"}, Type: "string"},
+	{Path: []string{"Intf"}, Type: "string"},
+	{Path: []string{"Med"}, Type: "string"},
+	{Path: []string{"Size"}, Type: "string"},
+	{Path: []string{"DG"}, Type: "number or string", Optional: true},
+}
+
+// Relative to the detailed information of a drive, with the drive's name
+// in front of the section names.
+var expectedDriveDetailFields = []expectedField{
+	{Path: []string{"State", "Media Error Count"}, Type: "number"},
+	{Path: []string{"State", "Other Error Count"}, Type: "number"},
+	{Path: []string{"State", "Predictive Failure Count"}, Type: "number"},
+	{Path: []string{"State", "Shield Counter"}, Type: "number", Optional: true},
+	{Path: []string{"State", "S.M.A.R.T alert flagged by drive"}, Type: "string"},
+	{Path: []string{"Device attributes", "SN"}, Type: "string"},
+	{Path: []string{"Device attributes", "Firmware Revision"}, Type: "string"},
+	{Path: []string{"Device attributes", "Link Speed"}, Type: "string", Optional: true},
+	{Path: []string{"Device attributes", "Device Speed"}, Type: "string", Optional: true},
+	{Path: []string{"Policies/Settings", "Commissioned Spare"}, Type: "string", Optional: true},
+	{Path: []string{"Policies/Settings", "Emergency Spare"}, Type: "string", Optional: true},
+}
+
+// schemaReport prints what validate finds, one block per command.
+type schemaReport struct {
+	w        io.Writer
+	problems int
+}
+
+func (r *schemaReport) problem(format string, args ...interface{}) {
+	r.problems++
+	fmt.Fprintf(r.w, "  "+format+"\n", args...)
+}
+
+// check reports the fields of tree that are missing or have another type
+// than expected.
+func (r *schemaReport) check(prefix string, tree interface{}, fields []expectedField) {
+
+	for _, field := range fields {
+		value, found := lookupPath(tree, field.Path)
+		name := prefix + quotePath(field.Path)
+		switch {
+		case !found && field.Optional:
+			fmt.Fprintf(r.w, "  missing %s (optional)\n", name)
+		case !found:
+			r.problem("missing %s", name)
+		case !jsonTypeIn(value, field.Type):
+			r.problem("%s is a %s, expected a %s", name, jsonType(value), field.Type)
+		}
+	}
+}
+
+// validate runs the queries of a collection and checks their output,
+// carrying on past problems to report as many as it can.
+func validate(source Source, r *schemaReport) {
+
+	data, cmdErr := source.Query("show", "ctrlcount", "J")
+	fmt.Fprintln(r.w, "show ctrlcount J:")
+	count, err := parseControllerCount(data)
+	if err != nil {
+		if looksLikeText(data) {
+			r.problem("storcli doesn't print JSON, the collector only reads the plain text summary")
+		} else {
+			r.problem("%v", wrapCommandError(err, cmdErr))
+		}
+		return
+	}
+	fmt.Fprintf(r.w, "  %d controllers, CLI version %s\n", count, parseCLIVersion(data))
+
+	for i := 0; i < count; i++ {
+		validateController(source, i, r)
+	}
+}
+
+func validateController(source Source, index int, r *schemaReport) {
+
+	controllerPath := "/c" + strconv.Itoa(index)
+	data, cmdErr := source.Query(controllerPath, "show", "all", "J")
+	fmt.Fprintf(r.w, "%s show all J:\n", controllerPath)
+	tree, err := decodeTree(data)
+	if err != nil {
+		r.problem("%v", wrapCommandError(err, cmdErr))
+		return
+	}
+	controllers, _ := lookupPath(tree, []string{"Controllers"})
+	list, _ := controllers.([]interface{})
+	if len(list) == 0 {
+		r.problem("missing \"Controllers\"")
+		return
+	}
+
+	responseData, _ := lookupPath(list[0], []string{"Response Data"})
+	if sections, ok := responseData.(map[string]interface{}); ok {
+		fmt.Fprintf(r.w, "  found %s\n", strings.Join(sortedKeys(sections), ", "))
+	}
+	r.check("", list[0], expectedControllerFields)
+
+	vdList, _ := lookupPath(list[0], []string{"Response Data", "VD LIST"})
+	for i, vd := range asList(vdList) {
+		r.check(fmt.Sprintf("VD LIST[%d] ", i), vd, expectedVirtualDriveFields)
+	}
+	pdList, _ := lookupPath(list[0], []string{"Response Data", "PD LIST"})
+	drives := asList(pdList)
+	for i, pd := range drives {
+		r.check(fmt.Sprintf("PD LIST[%d] ", i), pd, expectedPhysicalDriveFields)
+	}
+	if len(drives) == 0 {
+		return
+	}
+
+	data, cmdErr = source.Query(controllerPath+"/eALL/sALL", "show", "all", "J")
+	fmt.Fprintf(r.w, "%s/eALL/sALL show all J:\n", controllerPath)
+	details, err := parseDrives(bytes.NewReader(data))
+	if err != nil {
+		r.problem("%v", wrapCommandError(err, cmdErr))
+		return
+	}
+	info := details.ByController()[index]
+	if info == nil {
+		r.problem("no drive details for controller %d", index)
+		return
+	}
+	for _, pd := range drives {
+		eidSlt, _ := lookupPath(pd, []string{"EID:Slt"})
+		text, _ := eidSlt.(string)
+		_, _, name := driveName(text, index)
+		detailed, found := info[name+" - Detailed Information"]
+		if !found {
+			r.problem("missing %s", quotePath([]string{name + " - Detailed Information"}))
+			continue
+		}
+		fields := make([]expectedField, len(expectedDriveDetailFields))
+		for i, field := range expectedDriveDetailFields {
+			fields[i] = field
+			fields[i].Path = append([]string{name + " " + field.Path[0]}, field.Path[1:]...)
+		}
+		r.check("", detailed, fields)
+	}
+}
+
+// decodeTree decodes storcli's JSON output with the key variants of
+// other versions renamed, as the collector sees it.
+func decodeTree(data []byte) (interface{}, error) {
+	data, err := normalizeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	err = json.Unmarshal(data, &tree)
+	return tree, err
+}
+
+func lookupPath(tree interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		object, ok := tree.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if tree, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return tree, true
+}
+
+func asList(value interface{}) []interface{} {
+	list, _ := value.([]interface{})
+	return list
+}
+
+func quotePath(path []string) string {
+	quoted := make([]string, len(path))
+	for i, key := range path {
+		quoted[i] = strconv.Quote(key)
+	}
+	return strings.Join(quoted, " > ")
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "null"
+}
+
+func jsonTypeIn(value interface{}, types string) bool {
+	actual := jsonType(value)
+	for _, allowed := range strings.Split(types, " or ") {
+		if actual == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+var webConfigFile = flag.String("web-config-file", "", "(Optional) Prometheus exporter-toolkit web config file to serve --listen-address with TLS and basic auth. It's read again on every connection and request.")
+
+// serveWithWebConfig serves the handler on the listener with the TLS,
+// basic auth and headers of the web config file, handled by the
+// exporter-toolkit like in node_exporter. Without one it's plain HTTP.
+// On shutdown the listener is closed and running requests, whose
+// storcli commands are killed, are waited for.
+func serveWithWebConfig(listener net.Listener, handler http.Handler, path string) error {
+
+	server := &http.Server{Handler: handler}
+
+	stopped := make(chan error, 1)
+	go func() {
+		<-shutdownContext.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		stopped <- server.Shutdown(ctx)
+	}()
+
+	logger := kitlog.NewLogfmtLogger(logWriter{})
+	err := web.Serve(listener, server, &web.FlagConfig{WebConfigFile: &path}, logger)
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return <-stopped
+}
+
+// logWriter passes the lines the exporter-toolkit logs on to the
+// standard logger, so they get the same timestamps as ours.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	log.Print(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}